@@ -32,6 +32,7 @@ func init() {
 	iamClient = iam.New(session, aws.NewConfig().WithLogLevel(logLevel))
 	secretsClient = secretsmanager.New(session, aws.NewConfig().WithLogLevel(logLevel))
 	discoveryClient = servicediscovery.New(session, aws.NewConfig().WithLogLevel(logLevel))
+	scalingClient = newApplicationAutoScaling(session, aws.NewConfig().WithLogLevel(logLevel))
 }
 
 // KeyValuePairGetValue searches the array of values and returns the matching name or nil if none are found.
@@ -0,0 +1,190 @@
+package aws
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/aws/aws-sdk-go/private/protocol/jsonrpc"
+)
+
+const (
+	// scalingServiceNamespace and scalingDimension identify the desired count of an ECS service as a scalable target
+	scalingServiceNamespace = "ecs"
+	scalingDimension        = "ecs:service:DesiredCount"
+
+	// scalingErrCodeObjectNotFound is returned when the scalable target or policy does not exist
+	scalingErrCodeObjectNotFound = "ObjectNotFoundException"
+)
+
+// scalingAPI is the part of the Application Auto Scaling api faas-fargate uses. The version of the aws sdk we use has
+// no Application Auto Scaling client, applicationAutoScaling implements the requests we need.
+type scalingAPI interface {
+	RegisterScalableTarget(target *scalableTarget) error
+	DeregisterScalableTarget(target *scalableTarget) error
+	DescribeScalableTarget(target *scalableTarget) (*scalableTarget, error)
+	PutScalingPolicy(policy *scalingPolicy) error
+	DeleteScalingPolicy(policy *scalingPolicy) error
+	DescribeScalingPolicies(target *scalableTarget) ([]*scalingPolicy, error)
+}
+
+// scalableTarget is an ECS service whose desired count Application Auto Scaling manages
+type scalableTarget struct {
+	ServiceNamespace  *string
+	ResourceId        *string
+	ScalableDimension *string
+	MinCapacity       *int64
+	MaxCapacity       *int64
+}
+
+// scalingPolicy is a target tracking policy of a scalable target
+type scalingPolicy struct {
+	PolicyName                               *string
+	ServiceNamespace                         *string
+	ResourceId                               *string
+	ScalableDimension                        *string
+	PolicyType                               *string
+	TargetTrackingScalingPolicyConfiguration *targetTrackingConfiguration
+}
+
+type targetTrackingConfiguration struct {
+	TargetValue                   *float64
+	PredefinedMetricSpecification *predefinedMetricSpecification
+}
+
+type predefinedMetricSpecification struct {
+	PredefinedMetricType *string
+	ResourceLabel        *string
+}
+
+// applicationAutoScaling sends requests to the Application Auto Scaling json api
+type applicationAutoScaling struct {
+	*client.Client
+}
+
+func newApplicationAutoScaling(p client.ConfigProvider, cfgs ...*aws.Config) *applicationAutoScaling {
+	c := p.ClientConfig("application-autoscaling", cfgs...)
+	svc := &applicationAutoScaling{
+		Client: client.New(
+			*c.Config,
+			metadata.ClientInfo{
+				ServiceName:   "autoscaling",
+				SigningName:   "application-autoscaling",
+				SigningRegion: c.SigningRegion,
+				Endpoint:      c.Endpoint,
+				APIVersion:    "2016-02-06",
+				JSONVersion:   "1.1",
+				TargetPrefix:  "AnyScaleFrontendService",
+			},
+			c.Handlers,
+		),
+	}
+
+	svc.Handlers.Sign.PushBackNamed(v4.SignRequestHandler)
+	svc.Handlers.Build.PushBackNamed(jsonrpc.BuildHandler)
+	svc.Handlers.Unmarshal.PushBackNamed(jsonrpc.UnmarshalHandler)
+	svc.Handlers.UnmarshalMeta.PushBackNamed(jsonrpc.UnmarshalMetaHandler)
+	svc.Handlers.UnmarshalError.PushBackNamed(jsonrpc.UnmarshalErrorHandler)
+
+	return svc
+}
+
+func (c *applicationAutoScaling) send(operation string, input interface{}, output interface{}) error {
+	op := &request.Operation{Name: operation, HTTPMethod: "POST", HTTPPath: "/"}
+	if output == nil {
+		output = &struct{}{}
+	}
+
+	return c.NewRequest(op, input, output).Send()
+}
+
+// RegisterScalableTarget creates or updates the scalable target
+func (c *applicationAutoScaling) RegisterScalableTarget(target *scalableTarget) error {
+	return c.send("RegisterScalableTarget", target, nil)
+}
+
+// DeregisterScalableTarget deletes the scalable target and its policies
+func (c *applicationAutoScaling) DeregisterScalableTarget(target *scalableTarget) error {
+	return c.send("DeregisterScalableTarget", scalableTargetID(target), nil)
+}
+
+// DescribeScalableTarget returns the scalable target, or nil if it is not registered
+func (c *applicationAutoScaling) DescribeScalableTarget(target *scalableTarget) (*scalableTarget, error) {
+	input := &struct {
+		ServiceNamespace  *string
+		ResourceIds       []*string
+		ScalableDimension *string
+	}{target.ServiceNamespace, []*string{target.ResourceId}, target.ScalableDimension}
+
+	output := &struct{ ScalableTargets []*scalableTarget }{}
+	if err := c.send("DescribeScalableTargets", input, output); err != nil {
+		return nil, err
+	}
+
+	if len(output.ScalableTargets) == 0 {
+		return nil, nil
+	}
+
+	return output.ScalableTargets[0], nil
+}
+
+// PutScalingPolicy creates or replaces the policy
+func (c *applicationAutoScaling) PutScalingPolicy(policy *scalingPolicy) error {
+	return c.send("PutScalingPolicy", policy, nil)
+}
+
+// DeleteScalingPolicy deletes the policy
+func (c *applicationAutoScaling) DeleteScalingPolicy(policy *scalingPolicy) error {
+	input := &struct {
+		PolicyName        *string
+		ServiceNamespace  *string
+		ResourceId        *string
+		ScalableDimension *string
+	}{policy.PolicyName, policy.ServiceNamespace, policy.ResourceId, policy.ScalableDimension}
+
+	return c.send("DeleteScalingPolicy", input, nil)
+}
+
+// DescribeScalingPolicies returns the policies of the scalable target
+func (c *applicationAutoScaling) DescribeScalingPolicies(target *scalableTarget) ([]*scalingPolicy, error) {
+	var result []*scalingPolicy
+	var next *string
+	for {
+		input := &struct {
+			ServiceNamespace  *string
+			ResourceId        *string
+			ScalableDimension *string
+			NextToken         *string
+		}{target.ServiceNamespace, target.ResourceId, target.ScalableDimension, next}
+
+		output := &struct {
+			ScalingPolicies []*scalingPolicy
+			NextToken       *string
+		}{}
+		if err := c.send("DescribeScalingPolicies", input, output); err != nil {
+			return nil, err
+		}
+
+		result = append(result, output.ScalingPolicies...)
+		next = output.NextToken
+		if next == nil {
+			return result, nil
+		}
+	}
+}
+
+// scalableTargetID returns the fields identifying the scalable target
+func scalableTargetID(target *scalableTarget) *scalableTarget {
+	return &scalableTarget{
+		ServiceNamespace:  target.ServiceNamespace,
+		ResourceId:        target.ResourceId,
+		ScalableDimension: target.ScalableDimension,
+	}
+}
+
+func isScalingObjectNotFound(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	return ok && awsErr.Code() == scalingErrCodeObjectNotFound
+}
@@ -1,6 +1,7 @@
 package aws
 
 import (
+	"strings"
 	"sync"
 	"time"
 
@@ -16,38 +17,34 @@ import (
 
 const dnsNamespace = "openfaas.local"
 
-var once = &sync.Once{}
-var namespaceID *string
+// registrationOwnerMarker precedes the installation, namespace and function owning a route 53 auto-naming service in
+// its description, so the garbage collector only removes services created by this installation
+const registrationOwnerMarker = "owner="
 
-func deleteServiceRegistration(serviceName string, vpcID string) error {
-	namespaceID, err := ensureDNSNamespaceExists(vpcID)
+var dnsNamespacesLock = &sync.Mutex{}
+var dnsNamespaceIDs = map[string]*string{}
+
+func deleteServiceRegistration(namespace *Namespace, functionName string, vpcID string) error {
+	serviceName := namespace.DiscoveryNameFromFunctionName(functionName)
+	namespaceID, err := ensureDNSNamespaceExists(namespace, vpcID)
 	if err != nil {
 		return fmt.Errorf("error ensuring dns namespace existing. %v", err)
 	}
 
-	listResults, err := discoveryClient.ListServices(&servicediscovery.ListServicesInput{
-		Filters: []*servicediscovery.ServiceFilter{
-			{
-				Name: aws.String("NAMESPACE_ID"),
-				Values: []*string{
-					namespaceID,
-				},
-			},
-		},
-	})
-
-	serviceID := ""
-	for _, item := range listResults.Services {
-		if aws.StringValue(item.Name) == serviceName {
-			serviceID = aws.StringValue(item.Id)
-			break
-		}
+	registration, err := findServiceRegistration(namespaceID, serviceName)
+	if err != nil {
+		return fmt.Errorf("error finding service discovery service %s. %v", serviceName, err)
 	}
 
-	if len(serviceID) == 0 {
+	if registration == nil {
 		return nil // nothing to do
 	}
 
+	return deleteServiceRegistrationByID(serviceName, aws.StringValue(registration.Id))
+}
+
+// deleteServiceRegistrationByID de-registers the instances of the route 53 auto-naming service and deletes it
+func deleteServiceRegistrationByID(serviceName string, serviceID string) error {
 	log.Infof("Listing service instances for %s", serviceID)
 	instances, err := discoveryClient.ListInstances(&servicediscovery.ListInstancesInput{
 		ServiceId: aws.String(serviceID),
@@ -95,112 +92,289 @@ func deleteServiceRegistration(serviceName string, vpcID string) error {
 	return nil
 }
 
-func ensureServiceRegistrationExists(serviceName string, vpcID string) (string, error) {
+// ensureServiceRegistrationExists creates the route 53 auto-naming service of the function if it does not exist,
+// returning its arn and an undo function which deletes it if it was created. An existing service marked as another
+// function's is a ConflictError, so two functions never share a dns name.
+func ensureServiceRegistrationExists(namespace *Namespace, functionName string, vpcID string) (string, undoFunc, error) {
+	serviceName := namespace.DiscoveryNameFromFunctionName(functionName)
 
-	namespaceID, err := ensureDNSNamespaceExists(vpcID)
+	namespaceID, err := ensureDNSNamespaceExists(namespace, vpcID)
 	if err != nil {
 		log.Errorln("error ensuring dns namespace existing. ", err)
-		return "", err
+		return "", nil, err
 	}
 
-	listResults, err := discoveryClient.ListServices(&servicediscovery.ListServicesInput{
-		Filters: []*servicediscovery.ServiceFilter{
-			{
-				Name: aws.String("NAMESPACE_ID"),
-				Values: []*string{
-					namespaceID,
-				},
-			},
-		},
-	})
-
+	registration, err := findServiceRegistration(namespaceID, serviceName)
 	if err != nil {
 		log.Errorln("error listing route 53 auto-naming services. ", err)
-		return "", err
+		return "", nil, err
 	}
 
 	serviceArn := ""
-	for _, item := range listResults.Services {
-		if aws.StringValue(item.Name) == serviceName {
-			serviceArn = aws.StringValue(item.Arn)
-			break
+	if registration != nil {
+		if !isReusableRegistration(namespace, aws.StringValue(registration.Description), functionName) {
+			return "", nil, newConflictError("route 53 auto-naming service %s in %s belongs to a function of another "+
+				"installation or namespace", serviceName, namespace.DNSNamespace())
 		}
+
+		serviceArn = aws.StringValue(registration.Arn)
 	}
 
+	var undo undoFunc
 	if serviceArn == "" {
-		requestID := uuid.NewV4()
-		createResult, err := discoveryClient.CreateService(&servicediscovery.CreateServiceInput{
-			Name:             aws.String(serviceName),
-			CreatorRequestId: aws.String(requestID.String()),
-			Description:      aws.String(fmt.Sprintf("Openfaas auto-naming service for %s", serviceName)),
-			DnsConfig: &servicediscovery.DnsConfig{
-				NamespaceId: namespaceID,
-				DnsRecords: []*servicediscovery.DnsRecord{
-					{
-						Type: aws.String("A"),
-						TTL:  aws.Int64(10),
-					},
-				},
-			},
-			HealthCheckCustomConfig: &servicediscovery.HealthCheckCustomConfig{
-				FailureThreshold: aws.Int64(1),
-			},
-		})
+		createResult, err := discoveryClient.CreateService(serviceRegistrationInput(namespace, functionName, namespaceID))
 
 		if err != nil {
 			log.Errorln(fmt.Sprintf("error creating route 53 auto-naming services for %s. ", serviceName), err)
-			return "", err
+			return "", nil, err
 		}
 
 		serviceArn = aws.StringValue(createResult.Service.Arn)
+		undo = func() error { return deleteServiceRegistration(namespace, functionName, vpcID) }
+	}
+
+	return serviceArn, undo, nil
+}
+
+// serviceRegistrationInput returns the request creating the route 53 auto-naming service of the function
+func serviceRegistrationInput(
+	namespace *Namespace,
+	functionName string,
+	namespaceID *string) *servicediscovery.CreateServiceInput {
+
+	serviceName := namespace.DiscoveryNameFromFunctionName(functionName)
+	requestID := uuid.NewV4()
+	return &servicediscovery.CreateServiceInput{
+		Name:             aws.String(serviceName),
+		CreatorRequestId: aws.String(requestID.String()),
+		Description: aws.String(fmt.Sprintf("Openfaas auto-naming service for %s, %s%s",
+			serviceName, registrationOwnerMarker, registrationOwner(namespace, functionName))),
+		DnsConfig: &servicediscovery.DnsConfig{
+			NamespaceId: namespaceID,
+			DnsRecords: []*servicediscovery.DnsRecord{
+				{
+					Type: aws.String("A"),
+					TTL:  aws.Int64(10),
+				},
+			},
+		},
+		HealthCheckCustomConfig: &servicediscovery.HealthCheckCustomConfig{
+			FailureThreshold: aws.Int64(1),
+		},
+	}
+}
+
+// registrationOwner identifies the installation, namespace and function owning a route 53 auto-naming service
+func registrationOwner(namespace *Namespace, functionName string) string {
+	return fmt.Sprintf("%s/%s/%s", installationID, namespace.Name, functionName)
+}
+
+// isOwnedRegistration returns true if the description of a route 53 auto-naming service marks it as belonging to the
+// named function of this installation in the namespace. Services created before the marker existed are not owned.
+func isOwnedRegistration(namespace *Namespace, description string, functionName string) bool {
+	for _, item := range strings.Split(description, ", ") {
+		if item == registrationOwnerMarker+registrationOwner(namespace, functionName) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isReusableRegistration returns true if a route 53 auto-naming service may be used by the function: it is marked as
+// the function's, or it was created before the marker existed and the function belongs to the default installation
+// and namespace
+func isReusableRegistration(namespace *Namespace, description string, functionName string) bool {
+	if !strings.Contains(description, registrationOwnerMarker) {
+		return installationID == defaultInstallationID && namespace.isDefault
+	}
+
+	return isOwnedRegistration(namespace, description, functionName)
+}
+
+// registeredHealthyInstances returns the ids of the instances registered for the function that route 53 auto-naming
+// considers healthy. For ECS tasks the instance id is the task id.
+func registeredHealthyInstances(namespace *Namespace, functionName string) (map[string]bool, error) {
+	serviceName := namespace.DiscoveryNameFromFunctionName(functionName)
+
+	id, found, err := lookupDNSNamespace(namespace)
+	if err != nil || !found {
+		return map[string]bool{}, err
+	}
+
+	registration, err := findServiceRegistration(id, serviceName)
+	if err != nil {
+		return nil, err
 	}
 
-	return serviceArn, nil
+	return healthyInstances(serviceName, registration)
 }
 
-func ensureDNSNamespaceExists(vpcID string) (id *string, err error) {
-	once.Do(func() {
-		var found bool
+// healthyInstances returns the ids of the instances of the route 53 auto-naming service that are healthy, or none if
+// the registration is nil
+func healthyInstances(serviceName string, registration *servicediscovery.ServiceSummary) (map[string]bool, error) {
+	result := map[string]bool{}
+	if registration == nil {
+		return result, nil
+	}
 
-		id, found, err = findNamespace()
+	var next *string
+	for {
+		output, err := discoveryClient.GetInstancesHealthStatus(&servicediscovery.GetInstancesHealthStatusInput{
+			ServiceId: registration.Id,
+			NextToken: next,
+		})
 		if err != nil {
-			log.Errorln("error finding private dns name. ", err)
-			return
+			return nil, fmt.Errorf("error getting instance health for service %s. %v", serviceName, err)
 		}
 
-		if !found {
-			requestID := uuid.NewV4()
-			_, err = discoveryClient.CreatePrivateDnsNamespace(&servicediscovery.CreatePrivateDnsNamespaceInput{
-				Name:             aws.String(dnsNamespace),
-				CreatorRequestId: aws.String(requestID.String()),
-				Description:      aws.String("Openfaas private DNS namespace"),
-				Vpc:              aws.String(vpcID),
-			})
-
-			if err != nil {
-				log.Errorln("error creating private dns name. ", err)
-				return
+		for id, status := range output.Status {
+			if aws.StringValue(status) == servicediscovery.HealthStatusHealthy {
+				result[id] = true
 			}
+		}
 
-			id, found, err = findNamespace()
-			if err != nil {
-				log.Errorln("error finding private dns name. ", err)
-				return
+		next = output.NextToken
+		if next == nil {
+			break
+		}
+	}
+
+	return result, nil
+}
+
+// serviceRegistrations returns the route 53 auto-naming services in the private dns namespace of the function
+// namespace by name, so functions can be listed without looking up each registration
+func serviceRegistrations(namespace *Namespace) (map[string]*servicediscovery.ServiceSummary, error) {
+	result := map[string]*servicediscovery.ServiceSummary{}
+	id, found, err := lookupDNSNamespace(namespace)
+	if err != nil || !found {
+		return result, err
+	}
+
+	err = discoveryClient.ListServicesPages(
+		&servicediscovery.ListServicesInput{
+			Filters: []*servicediscovery.ServiceFilter{
+				{
+					Name:   aws.String("NAMESPACE_ID"),
+					Values: []*string{id},
+				},
+			},
+		},
+		func(output *servicediscovery.ListServicesOutput, lastPage bool) bool {
+			for _, item := range output.Services {
+				result[aws.StringValue(item.Name)] = item
 			}
+			return true
+		})
+	if err != nil {
+		return nil, fmt.Errorf("error listing route 53 auto-naming services of %s. %v", namespace.DNSNamespace(), err)
+	}
 
-			if !found {
-				log.Errorln("could not find private dns after creating it")
-				err = errors.New("could not find private dns after creating it")
+	return result, nil
+}
+
+func findServiceRegistration(namespaceID *string, serviceName string) (*servicediscovery.ServiceSummary, error) {
+	var next *string
+	for {
+		listResults, err := discoveryClient.ListServices(&servicediscovery.ListServicesInput{
+			Filters: []*servicediscovery.ServiceFilter{
+				{
+					Name: aws.String("NAMESPACE_ID"),
+					Values: []*string{
+						namespaceID,
+					},
+				},
+			},
+			NextToken: next,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range listResults.Services {
+			if aws.StringValue(item.Name) == serviceName {
+				return item, nil
 			}
 		}
 
-		namespaceID = id
-	})
+		next = listResults.NextToken
+		if next == nil {
+			return nil, nil
+		}
+	}
+}
+
+func ensureDNSNamespaceExists(namespace *Namespace, vpcID string) (*string, error) {
+	dnsNamespacesLock.Lock()
+	defer dnsNamespacesLock.Unlock()
+
+	name := namespace.DNSNamespace()
+	if id, exists := dnsNamespaceIDs[name]; exists {
+		return id, nil
+	}
+
+	id, found, err := findNamespace(name)
+	if err != nil {
+		log.Errorln("error finding private dns name. ", err)
+		return nil, err
+	}
+
+	if !found {
+		requestID := uuid.NewV4()
+		_, err = discoveryClient.CreatePrivateDnsNamespace(&servicediscovery.CreatePrivateDnsNamespaceInput{
+			Name:             aws.String(name),
+			CreatorRequestId: aws.String(requestID.String()),
+			Description:      aws.String(fmt.Sprintf("Openfaas private DNS namespace for the %s function namespace", namespace.Name)),
+			Vpc:              aws.String(vpcID),
+		})
+
+		if err != nil {
+			log.Errorln("error creating private dns name. ", err)
+			return nil, err
+		}
+
+		id, found, err = findNamespace(name)
+		if err != nil {
+			log.Errorln("error finding private dns name. ", err)
+			return nil, err
+		}
+
+		if !found {
+			log.Errorln("could not find private dns after creating it")
+			return nil, errors.New("could not find private dns after creating it")
+		}
+	}
+
+	dnsNamespaceIDs[name] = id
+	return id, nil
+}
+
+// lookupDNSNamespace returns the id of the private dns namespace of the function namespace without creating it. An
+// id that is found is remembered, as ensureDNSNamespaceExists does.
+func lookupDNSNamespace(namespace *Namespace) (*string, bool, error) {
+	name := namespace.DNSNamespace()
+	dnsNamespacesLock.Lock()
+	id, exists := dnsNamespaceIDs[name]
+	dnsNamespacesLock.Unlock()
+
+	if exists {
+		return id, true, nil
+	}
+
+	id, found, err := findNamespace(name)
+	if err != nil || !found {
+		return id, found, err
+	}
+
+	dnsNamespacesLock.Lock()
+	dnsNamespaceIDs[name] = id
+	dnsNamespacesLock.Unlock()
 
-	return namespaceID, err
+	return id, true, nil
 }
 
-func findNamespace() (*string, bool, error) {
+func findNamespace(name string) (*string, bool, error) {
 	var listResult *servicediscovery.ListNamespacesOutput
 	listResult, err := discoveryClient.ListNamespaces(&servicediscovery.ListNamespacesInput{})
 	if err != nil {
@@ -211,7 +385,7 @@ func findNamespace() (*string, bool, error) {
 	found := false
 	var id *string
 	for _, item := range listResult.Namespaces {
-		if aws.StringValue(item.Name) == dnsNamespace {
+		if aws.StringValue(item.Name) == name {
 			id = item.Id
 			found = true
 			break
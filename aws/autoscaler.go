@@ -0,0 +1,599 @@
+package aws
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// maxScalingDecisions is the number of recent decisions kept for each function
+	maxScalingDecisions = 20
+
+	// discoveryInterval is how often functions the proxy has not seen are looked for, so that they can be scaled to
+	// zero
+	discoveryInterval = 5 * time.Minute
+
+	// wakePollInterval is how often a function being woken is checked for a healthy task, maxWakeWait is how long
+	// it is waited for
+	wakePollInterval = 2 * time.Second
+	maxWakeWait      = 10 * time.Minute
+
+	// unknownReplicas is the replicas of a function the autoscaler has not yet described
+	unknownReplicas = -1
+
+	// scheduledZeroDuration is how long a function woken while its schedule has it scaled to zero must go without
+	// requests to be scaled back to zero, unless it is labelled com.openfaas.scale.zero-duration
+	scheduledZeroDuration = 5 * time.Minute
+)
+
+// RequestAutoscaler scales functions labelled com.openfaas.scale.type=concurrency on the requests passing through
+// the proxy, keeping the requests in flight per replica near the com.openfaas.scale.target. Functions are scaled
+// once the proxy has seen a request for them.
+//
+// Functions labelled com.openfaas.scale.zero-duration are scaled to zero when they have had no requests for that
+// long, and woken by the next request. They are either scaled on concurrency or not autoscaled. Functions woken
+// while their schedule has them scaled to zero are scaled back to zero in the same way.
+type RequestAutoscaler struct {
+	lock        *sync.Mutex
+	functions   map[string]*trackedFunction
+	wakeTimeout time.Duration
+	discovered  time.Time
+	scheduler   *ScaleScheduler
+}
+
+// trackedFunction is the traffic of a function since the last scaling decision
+type trackedFunction struct {
+	namespace *Namespace
+	name      string
+	inFlight  int64
+	requests  int64
+	// requestSeconds accumulates the requests in flight multiplied by how long they were in flight, so the average
+	// concurrency over the window is requestSeconds divided by the length of the window
+	requestSeconds float64
+	changed        time.Time
+	windowStart    time.Time
+	lastScaled     time.Time
+	lastRequest    time.Time
+	decisions      []ScalingDecision
+
+	// replicas is the desired count of the service as last seen, requests to a function with no replicas wake it.
+	// Requests to a function whose replicas are unknown are forwarded while they are read.
+	replicas int64
+	wake     *wakeCall
+	learning bool
+	// missing is set when the function was not found, it is no longer tracked once its requests finish
+	missing bool
+
+	// scaleLock orders the changes the autoscaler and a wake make to the desired count
+	scaleLock *sync.Mutex
+	// settings are read from the task definition, and only read again when it changes
+	taskDefinition string
+	settings       *scalingSettings
+}
+
+// wakeCall is a function being scaled from zero, shared by the requests waiting on it
+type wakeCall struct {
+	done chan struct{}
+	err  error
+}
+
+// ScalingDecision explains a decision of the RequestAutoscaler
+type ScalingDecision struct {
+	Time time.Time `json:"time"`
+	// Concurrency is the average number of requests in flight since the last decision
+	Concurrency float64 `json:"concurrency"`
+	RPS         float64 `json:"rps"`
+	// Target is the concurrency per replica being aimed for
+	Target float64 `json:"target"`
+	From   int64   `json:"from"`
+	To     int64   `json:"to"`
+	Reason string  `json:"reason"`
+	Error  string  `json:"error,omitempty"`
+}
+
+// WakeTimeoutError is returned when a function scaled to zero has no healthy task within the wake timeout
+type WakeTimeoutError struct {
+	Function string
+	Timeout  time.Duration
+}
+
+func (e *WakeTimeoutError) Error() string {
+	return fmt.Sprintf("function %s is scaling up from zero replicas and was not ready within %s, retry later",
+		e.Function, e.Timeout)
+}
+
+// NewRequestAutoscaler creates an autoscaler, requests must be reported to it using RequestStarted and
+// RequestFinished. Requests to a function scaled to zero wait up to the wake timeout for it to be ready. The
+// scheduler, if any, tells it which functions are scaled to zero by their schedule.
+func NewRequestAutoscaler(wakeTimeout time.Duration, scheduler *ScaleScheduler) *RequestAutoscaler {
+	return &RequestAutoscaler{
+		lock:        &sync.Mutex{},
+		functions:   map[string]*trackedFunction{},
+		wakeTimeout: wakeTimeout,
+		scheduler:   scheduler,
+	}
+}
+
+// RequestStarted records a request to the function going in flight
+func (a *RequestAutoscaler) RequestStarted(namespace *Namespace, functionName string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	now := time.Now()
+	function := a.track(namespace, functionName, now)
+	function.accumulate(now)
+	function.inFlight++
+	function.requests++
+	function.lastRequest = now
+}
+
+// RequestFinished records a request to the function completing
+func (a *RequestAutoscaler) RequestFinished(namespace *Namespace, functionName string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	function, found := a.functions[rolloutKey(namespace, functionName)]
+	if !found || function.inFlight == 0 {
+		return
+	}
+
+	now := time.Now()
+	function.accumulate(now)
+	function.inFlight--
+	function.lastRequest = now
+
+	if function.missing {
+		a.untrack(function)
+	}
+}
+
+// track returns the tracked function, tracking it from now if it is not. The caller must hold the lock.
+func (a *RequestAutoscaler) track(namespace *Namespace, functionName string, now time.Time) *trackedFunction {
+	key := rolloutKey(namespace, functionName)
+	function, found := a.functions[key]
+	if !found {
+		function = &trackedFunction{
+			namespace:   namespace,
+			name:        functionName,
+			changed:     now,
+			windowStart: now,
+			lastRequest: now,
+			replicas:    unknownReplicas,
+			scaleLock:   &sync.Mutex{},
+		}
+		a.functions[key] = function
+	}
+
+	return function
+}
+
+// accumulate adds the requests in flight since the last change to the window
+func (f *trackedFunction) accumulate(now time.Time) {
+	f.requestSeconds += float64(f.inFlight) * now.Sub(f.changed).Seconds()
+	f.changed = now
+}
+
+// takeWindow returns the average concurrency and requests per second since the last window, starting a new one
+func (f *trackedFunction) takeWindow(now time.Time) (float64, float64) {
+	f.accumulate(now)
+
+	var concurrency, rps float64
+	if elapsed := now.Sub(f.windowStart).Seconds(); elapsed > 0 {
+		concurrency = f.requestSeconds / elapsed
+		rps = float64(f.requests) / elapsed
+	}
+
+	f.requestSeconds = 0
+	f.requests = 0
+	f.windowStart = now
+	return concurrency, rps
+}
+
+// idleFor returns how long the function has had no requests in flight. The caller must hold the lock.
+func (f *trackedFunction) idleFor(now time.Time) time.Duration {
+	if f.inFlight > 0 {
+		return 0
+	}
+
+	return now.Sub(f.lastRequest)
+}
+
+// Start makes a scaling decision for every tracked function each interval in the background
+func (a *RequestAutoscaler) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			a.Scale()
+		}
+	}()
+}
+
+// Scale makes a scaling decision for every tracked function
+func (a *RequestAutoscaler) Scale() {
+	if time.Since(a.discovered) >= discoveryInterval {
+		a.discover()
+	}
+
+	a.lock.Lock()
+	var functions []*trackedFunction
+	for _, function := range a.functions {
+		functions = append(functions, function)
+	}
+	a.lock.Unlock()
+
+	for _, function := range functions {
+		a.scaleFunction(function)
+	}
+}
+
+// discover tracks the functions the proxy has not seen, so that idle functions are scaled to zero after a restart
+// of the provider
+func (a *RequestAutoscaler) discover() {
+	a.discovered = time.Now()
+	for _, name := range Namespaces() {
+		namespace, err := GetNamespace(name)
+		if err != nil {
+			log.Errorf("Autoscaler could not find namespace %s. %v", name, err)
+			continue
+		}
+
+		live, err := liveFunctions(namespace)
+		if err != nil {
+			log.Errorf("Autoscaler could not list functions in namespace %s. %v", name, err)
+			continue
+		}
+
+		a.lock.Lock()
+		for functionName := range live {
+			a.track(namespace, functionName, a.discovered)
+		}
+		a.lock.Unlock()
+	}
+}
+
+func (a *RequestAutoscaler) scaleFunction(function *trackedFunction) {
+	function.scaleLock.Lock()
+	defer function.scaleLock.Unlock()
+
+	now := time.Now()
+
+	a.lock.Lock()
+	concurrency, rps := function.takeWindow(now)
+	lastScaled := function.lastScaled
+	a.lock.Unlock()
+
+	service, settings, err := function.scaling()
+	if err != nil {
+		log.Errorf("Autoscaler could not read function %s. %v", function.name, err)
+		return
+	}
+
+	if service == nil {
+		a.forget(function)
+		return
+	}
+
+	current := aws.Int64Value(service.DesiredCount)
+
+	zeroDuration := settings.zeroDuration
+	if zeroDuration == 0 && !settings.applicationAutoScaling() && a.scheduler != nil &&
+		a.scheduler.ScaledToZero(function.namespace, function.name) {
+		zeroDuration = scheduledZeroDuration
+	}
+
+	a.lock.Lock()
+	if function.wake == nil {
+		function.replicas = current
+	}
+
+	idle := current > 0 && zeroDuration > 0 && function.wake == nil &&
+		function.idleFor(now) >= zeroDuration
+	if idle {
+		// requests arriving from now on wait for the function to be woken
+		function.replicas = 0
+	}
+	a.lock.Unlock()
+
+	var decision ScalingDecision
+	switch {
+	case idle:
+		decision = ScalingDecision{Concurrency: concurrency, From: current, To: 0,
+			Reason: fmt.Sprintf("no requests for %s, scaled to zero", zeroDuration)}
+	case !settings.enabled || settings.scaleType != scaleTypeConcurrency || current == 0:
+		// functions scaled to zero are woken by the proxy
+		return
+	default:
+		decision = decideReplicas(settings, current, concurrency, now.Sub(lastScaled))
+	}
+
+	decision.Time = now
+	decision.RPS = rps
+
+	if decision.To != decision.From {
+		_, err := UpdateECSServiceDesiredCount(function.namespace, function.name, int(decision.To))
+		if err != nil {
+			decision.Error = err.Error()
+		}
+	}
+
+	a.record(function, decision)
+}
+
+// scaling returns the function service and its scaling settings, or nil if the function is not found. The settings
+// are only read again when the task definition of the service changes.
+func (f *trackedFunction) scaling() (*ecs.Service, *scalingSettings, error) {
+	details, err := ecsClient.DescribeServices(&ecs.DescribeServicesInput{
+		Cluster:  f.namespace.ClusterID(),
+		Services: []*string{aws.String(f.namespace.ServiceNameFromFunctionName(f.name))},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var service *ecs.Service
+	for _, item := range details.Services {
+		if aws.StringValue(item.Status) == "ACTIVE" {
+			service = item
+		}
+	}
+
+	if service == nil {
+		return nil, nil, nil
+	}
+
+	if aws.StringValue(service.TaskDefinition) == f.taskDefinition {
+		return service, f.settings, nil
+	}
+
+	output, err := ecsClient.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{TaskDefinition: service.TaskDefinition})
+	if err != nil {
+		return nil, nil, fmt.Errorf("error describing task definition %s. %v", aws.StringValue(service.TaskDefinition), err)
+	}
+
+	container := FunctionContainer(output.TaskDefinition)
+	if !f.namespace.isOwnedFunction(container, f.name) {
+		return nil, nil, nil
+	}
+
+	settings, err := newScalingSettings(labelsFromContainer(container))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f.taskDefinition = aws.StringValue(service.TaskDefinition)
+	f.settings = settings
+	return service, settings, nil
+}
+
+// decideReplicas returns the replicas needed to keep the concurrency per replica near the target, within the min
+// and max of the function and respecting the cooldown since it was last scaled
+func decideReplicas(settings *scalingSettings, current int64, concurrency float64, sinceScaled time.Duration) ScalingDecision {
+	decision := ScalingDecision{Concurrency: concurrency, Target: settings.target, From: current, To: current}
+
+	desired := int64(math.Ceil(concurrency / settings.target))
+	switch {
+	case desired < settings.min:
+		desired = settings.min
+	case desired > settings.max:
+		desired = settings.max
+	}
+
+	switch {
+	case desired == current:
+		decision.Reason = fmt.Sprintf("%d replicas keep concurrency per replica within target %v", current, settings.target)
+	case desired > current && sinceScaled < settings.upCooldown:
+		decision.Reason = fmt.Sprintf("scale up to %d held, %s of the up cooldown %s remain",
+			desired, (settings.upCooldown - sinceScaled).Round(time.Second), settings.upCooldown)
+	case desired < current && sinceScaled < settings.downCooldown:
+		decision.Reason = fmt.Sprintf("scale down to %d held, %s of the down cooldown %s remain",
+			desired, (settings.downCooldown - sinceScaled).Round(time.Second), settings.downCooldown)
+	default:
+		decision.To = desired
+		decision.Reason = fmt.Sprintf("concurrency %.2f needs %d replicas at target %v, bounded to %d-%d",
+			concurrency, desired, settings.target, settings.min, settings.max)
+	}
+
+	return decision
+}
+
+// Wake waits for a function scaled to zero to be scaled up to its minimum replicas and to have a healthy task
+// registered in service discovery. Requests waiting on the same function share one wake. Returns a WakeTimeoutError
+// if the function is not ready within the wake timeout. Only functions known to be scaled to zero are woken, the
+// replicas of a function not yet described are read in the background.
+func (a *RequestAutoscaler) Wake(namespace *Namespace, functionName string) error {
+	a.lock.Lock()
+	function := a.track(namespace, functionName, time.Now())
+	if function.replicas == unknownReplicas && !function.learning {
+		function.learning = true
+		go a.learnReplicas(function)
+	}
+
+	if function.replicas != 0 {
+		a.lock.Unlock()
+		return nil
+	}
+
+	if function.wake == nil {
+		function.wake = &wakeCall{done: make(chan struct{})}
+		go a.wakeFunction(function, function.wake)
+	}
+
+	call := function.wake
+	a.lock.Unlock()
+
+	timer := time.NewTimer(a.wakeTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-call.done:
+		return call.err
+	case <-timer.C:
+		return &WakeTimeoutError{Function: functionName, Timeout: a.wakeTimeout}
+	}
+}
+
+// learnReplicas reads the desired count of a function the autoscaler has not described yet. A function which is not
+// found is no longer tracked.
+func (a *RequestAutoscaler) learnReplicas(function *trackedFunction) {
+	function.scaleLock.Lock()
+	service, _, err := function.scaling()
+	function.scaleLock.Unlock()
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	function.learning = false
+	if err != nil {
+		log.Warnf("Autoscaler could not read function %s. %v", function.name, err)
+		return
+	}
+
+	function.missing = service == nil
+	if function.missing {
+		a.untrack(function)
+		return
+	}
+
+	if function.wake == nil && function.replicas == unknownReplicas {
+		function.replicas = aws.Int64Value(service.DesiredCount)
+	}
+}
+
+func (a *RequestAutoscaler) wakeFunction(function *trackedFunction, call *wakeCall) {
+	replicas, err := a.scaleFromZero(function)
+	if err == nil && replicas == 0 {
+		err = fmt.Errorf("function %s was not ready within %s", function.name, maxWakeWait)
+	}
+
+	a.lock.Lock()
+	function.wake = nil
+	if err == nil {
+		function.replicas = replicas
+	}
+	a.lock.Unlock()
+
+	call.err = err
+	close(call.done)
+}
+
+// scaleFromZero scales the function up to its minimum replicas when it has none and waits for a healthy task,
+// returning its desired count or zero if no task became healthy. A function which is not found is reported as
+// having replicas, leaving the proxy to fail the request as it would any other.
+func (a *RequestAutoscaler) scaleFromZero(function *trackedFunction) (int64, error) {
+	function.scaleLock.Lock()
+	service, settings, err := function.scaling()
+	if err != nil || service == nil {
+		function.scaleLock.Unlock()
+		return 1, err
+	}
+
+	current := aws.Int64Value(service.DesiredCount)
+	if current > 0 {
+		function.scaleLock.Unlock()
+		return current, nil
+	}
+
+	decision := ScalingDecision{Time: time.Now(), From: 0, To: settings.min, Reason: "woken by a request"}
+	_, err = UpdateECSServiceDesiredCount(function.namespace, function.name, int(settings.min))
+	if err != nil {
+		decision.Error = err.Error()
+	}
+
+	a.record(function, decision)
+	function.scaleLock.Unlock()
+
+	if err != nil {
+		return 0, fmt.Errorf("error scaling %s up from zero. %v", function.name, err)
+	}
+
+	deadline := time.Now().Add(maxWakeWait)
+	for time.Now().Before(deadline) {
+		healthy, err := registeredHealthyInstances(function.namespace, function.name)
+		if err != nil {
+			log.Warnf("Error checking if %s is ready. %v", function.name, err)
+		}
+
+		if len(healthy) > 0 {
+			log.Infof("Function %s is ready after scaling up from zero", function.name)
+			return settings.min, nil
+		}
+
+		time.Sleep(wakePollInterval)
+	}
+
+	return 0, nil
+}
+
+// record logs the decision and keeps it with the recent decisions of the function
+func (a *RequestAutoscaler) record(function *trackedFunction, decision ScalingDecision) {
+	logDecision(function.name, decision)
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if decision.To != decision.From && len(decision.Error) == 0 {
+		function.lastScaled = decision.Time
+	}
+
+	function.decisions = append(function.decisions, decision)
+	if len(function.decisions) > maxScalingDecisions {
+		function.decisions = function.decisions[len(function.decisions)-maxScalingDecisions:]
+	}
+}
+
+func logDecision(functionName string, decision ScalingDecision) {
+	entry := log.WithFields(log.Fields{
+		"function":    functionName,
+		"concurrency": fmt.Sprintf("%.2f", decision.Concurrency),
+		"rps":         fmt.Sprintf("%.2f", decision.RPS),
+		"from":        decision.From,
+		"to":          decision.To,
+	})
+
+	switch {
+	case len(decision.Error) > 0:
+		entry.Errorf("Autoscaler failed to scale, %s. %s", decision.Reason, decision.Error)
+	case decision.From != decision.To:
+		entry.Infof("Autoscaler scaled, %s", decision.Reason)
+	default:
+		entry.Debugf("Autoscaler unchanged, %s", decision.Reason)
+	}
+}
+
+// Decisions returns the recent scaling decisions for the function, oldest first
+func (a *RequestAutoscaler) Decisions(namespace *Namespace, functionName string) []ScalingDecision {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	function, found := a.functions[rolloutKey(namespace, functionName)]
+	if !found {
+		return nil
+	}
+
+	return append([]ScalingDecision(nil), function.decisions...)
+}
+
+// forget stops tracking a function which no longer exists
+func (a *RequestAutoscaler) forget(function *trackedFunction) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.untrack(function)
+}
+
+// untrack stops tracking the function unless it has requests in flight or is being woken. The caller must hold the
+// lock.
+func (a *RequestAutoscaler) untrack(function *trackedFunction) {
+	key := rolloutKey(function.namespace, function.name)
+	if a.functions[key] == function && function.inFlight == 0 && function.wake == nil && !function.learning {
+		delete(a.functions, key)
+	}
+}
@@ -0,0 +1,205 @@
+package aws
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func Test_ScalingSettings_Concurrency(t *testing.T) {
+	settings, err := newScalingSettings(&map[string]string{
+		scaleTypeLabel:         scaleTypeConcurrency,
+		scaleUpCooldownLabel:   "15",
+		scaleDownCooldownLabel: "5m",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if settings.applicationAutoScaling() {
+		t.Error("Want concurrency scaling left to the request autoscaler")
+	}
+
+	if settings.target != defaultConcurrencyTarget || settings.upCooldown != 15*time.Second || settings.downCooldown != 5*time.Minute {
+		t.Errorf("Unexpected settings %+v", settings)
+	}
+}
+
+func Test_DecideReplicas(t *testing.T) {
+	settings := &scalingSettings{enabled: true, scaleType: scaleTypeConcurrency, min: 1, max: 5, target: 10,
+		upCooldown: 30 * time.Second, downCooldown: 2 * time.Minute}
+
+	cases := []struct {
+		name        string
+		current     int64
+		concurrency float64
+		sinceScaled time.Duration
+		want        int64
+	}{
+		{"within target", 2, 15, time.Hour, 2},
+		{"scale up", 2, 31, time.Hour, 4},
+		{"bounded by max", 2, 100, time.Hour, 5},
+		{"bounded by min", 3, 0, time.Hour, 1},
+		{"up cooldown", 2, 31, 10 * time.Second, 2},
+		{"down cooldown", 3, 5, time.Minute, 3},
+		{"down after cooldown", 3, 5, 3 * time.Minute, 1},
+	}
+
+	for _, c := range cases {
+		decision := decideReplicas(settings, c.current, c.concurrency, c.sinceScaled)
+		if decision.To != c.want || decision.From != c.current || len(decision.Reason) == 0 {
+			t.Errorf("%s: want %d replicas, got %+v", c.name, c.want, decision)
+		}
+	}
+}
+
+func Test_TrackedFunction_AverageConcurrency(t *testing.T) {
+	start := time.Now()
+	function := &trackedFunction{changed: start, windowStart: start}
+
+	// two requests in flight for the first second, one for the next
+	function.accumulate(start)
+	function.inFlight, function.requests = 2, 2
+	function.accumulate(start.Add(time.Second))
+	function.inFlight = 1
+
+	concurrency, rps := function.takeWindow(start.Add(2 * time.Second))
+	if math.Abs(concurrency-1.5) > 1e-9 || math.Abs(rps-1) > 1e-9 {
+		t.Errorf("Want concurrency 1.5 and 1 rps, got %v and %v", concurrency, rps)
+	}
+
+	if function.requestSeconds != 0 || function.requests != 0 || !function.windowStart.Equal(start.Add(2*time.Second)) {
+		t.Errorf("Want a new window started, got %+v", function)
+	}
+}
+
+func Test_ScalingSettings_ZeroDuration(t *testing.T) {
+	settings, err := newScalingSettings(&map[string]string{scaleZeroDurationLabel: "15m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if settings.zeroDuration != 15*time.Minute || settings.enabled {
+		t.Errorf("Want scale to zero after 15m without enabling autoscaling, got %+v", settings)
+	}
+
+	for _, raw := range []string{"0", "-5", "soon"} {
+		_, err := newScalingSettings(&map[string]string{scaleZeroDurationLabel: raw})
+		if _, ok := err.(*ValidationError); !ok {
+			t.Errorf("Want validation error for %s, got %v", raw, err)
+		}
+	}
+}
+
+func Test_TrackedFunction_IdleFor(t *testing.T) {
+	now := time.Now()
+	function := &trackedFunction{lastRequest: now.Add(-time.Minute)}
+	if idle := function.idleFor(now); idle != time.Minute {
+		t.Errorf("Want idle for 1m, got %s", idle)
+	}
+
+	function.inFlight = 1
+	if idle := function.idleFor(now); idle != 0 {
+		t.Errorf("Want a function with requests in flight not idle, got %s", idle)
+	}
+}
+
+func Test_Wake(t *testing.T) {
+	namespace := &Namespace{Name: "default", isDefault: true}
+	autoscaler := NewRequestAutoscaler(10*time.Millisecond, nil)
+
+	autoscaler.RequestStarted(namespace, "echo")
+	function := autoscaler.functions[rolloutKey(namespace, "echo")]
+
+	function.replicas = 2
+	if err := autoscaler.Wake(namespace, "echo"); err != nil {
+		t.Errorf("Want a function with replicas not woken, got %v", err)
+	}
+
+	// a wake already in progress is waited on rather than started again
+	function.replicas = 0
+	function.wake = &wakeCall{done: make(chan struct{})}
+	err := autoscaler.Wake(namespace, "echo")
+	if _, ok := err.(*WakeTimeoutError); !ok {
+		t.Errorf("Want wake timeout error, got %v", err)
+	}
+
+	function.wake.err = errors.New("scaling failed")
+	close(function.wake.done)
+	if err := autoscaler.Wake(namespace, "echo"); err == nil || err.Error() != "scaling failed" {
+		t.Errorf("Want the error of the wake, got %v", err)
+	}
+}
+
+func Test_Wake_UnknownReplicas(t *testing.T) {
+	withStubbedAPI(t, func(api *stubAPI) {
+		namespace := DefaultNamespace()
+		api.on("DescribeServices", func(input interface{}) (interface{}, error) {
+			name := aws.StringValue(input.(*ecs.DescribeServicesInput).Services[0])
+			if name != namespace.ServiceNameFromFunctionName("echo") {
+				return &ecs.DescribeServicesOutput{}, nil
+			}
+
+			return &ecs.DescribeServicesOutput{Services: []*ecs.Service{{
+				ServiceName:    aws.String(name),
+				Status:         aws.String("ACTIVE"),
+				DesiredCount:   aws.Int64(0),
+				TaskDefinition: aws.String(name + ":1"),
+			}}}, nil
+		})
+		api.on("DescribeTaskDefinition", func(input interface{}) (interface{}, error) {
+			family := namespace.ServiceNameFromFunctionName("echo")
+			return &ecs.DescribeTaskDefinitionOutput{TaskDefinition: &ecs.TaskDefinition{
+				Family: aws.String(family),
+				ContainerDefinitions: []*ecs.ContainerDefinition{
+					{Name: aws.String(family), DockerLabels: namespace.ownershipLabels("echo")},
+				},
+			}}, nil
+		})
+
+		autoscaler := NewRequestAutoscaler(time.Millisecond, nil)
+		learned := func(name string) *trackedFunction {
+			for i := 0; i < 100; i++ {
+				autoscaler.lock.Lock()
+				function, found := autoscaler.functions[rolloutKey(namespace, name)]
+				learning := found && function.learning
+				autoscaler.lock.Unlock()
+
+				if !learning {
+					return function
+				}
+
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			t.Fatalf("Want the replicas of %s read", name)
+			return nil
+		}
+
+		// after a restart requests are forwarded while the replicas are read, rather than waiting for a wake
+		autoscaler.RequestStarted(namespace, "echo")
+		if err := autoscaler.Wake(namespace, "echo"); err != nil {
+			t.Errorf("Want a function with unknown replicas forwarded, got %v", err)
+		}
+		autoscaler.RequestFinished(namespace, "echo")
+
+		if function := learned("echo"); function == nil || function.replicas != 0 {
+			t.Errorf("Want echo known to be scaled to zero, got %+v", function)
+		}
+
+		autoscaler.RequestStarted(namespace, "missing")
+		if err := autoscaler.Wake(namespace, "missing"); err != nil {
+			t.Errorf("Want a function with unknown replicas forwarded, got %v", err)
+		}
+		learned("missing")
+		autoscaler.RequestFinished(namespace, "missing")
+
+		if _, found := autoscaler.functions[rolloutKey(namespace, "missing")]; found {
+			t.Error("Want a function which is not found no longer tracked")
+		}
+	})
+}
@@ -0,0 +1,333 @@
+package aws
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	scaleMinLabel           = "com.openfaas.scale.min"
+	scaleMaxLabel           = "com.openfaas.scale.max"
+	scaleTypeLabel          = "com.openfaas.scale.type"
+	scaleTargetLabel        = "com.openfaas.scale.target"
+	scaleResourceLabelLabel = "com.openfaas.scale.resource-label"
+	scaleUpCooldownLabel    = "com.openfaas.scale.up-cooldown"
+	scaleDownCooldownLabel  = "com.openfaas.scale.down-cooldown"
+	scaleZeroDurationLabel  = "com.openfaas.scale.zero-duration"
+
+	// Metrics a function can be scaled on
+	scaleTypeCPU      = "cpu"
+	scaleTypeMemory   = "memory"
+	scaleTypeRequests = "requests"
+	// scaleTypeConcurrency scales on the requests in flight through the proxy, using the RequestAutoscaler rather
+	// than Application Auto Scaling
+	scaleTypeConcurrency = "concurrency"
+
+	defaultScaleMax          = 20
+	defaultConcurrencyTarget = 10
+	defaultScaleUpCooldown   = 30 * time.Second
+	defaultScaleDownCooldown = 2 * time.Minute
+)
+
+// scaleMetrics maps a com.openfaas.scale.type to the predefined metric tracked and its default target value
+var scaleMetrics = map[string]struct {
+	metric string
+	target float64
+}{
+	scaleTypeCPU:      {"ECSServiceAverageCPUUtilization", 70},
+	scaleTypeMemory:   {"ECSServiceAverageMemoryUtilization", 70},
+	scaleTypeRequests: {"ALBRequestCountPerTarget", 100},
+}
+
+var scalingClient scalingAPI
+
+// scalingSettings is the autoscaling of a function, read from the com.openfaas.scale.* labels. Autoscaling is enabled
+// by the com.openfaas.scale.max or com.openfaas.scale.type label.
+type scalingSettings struct {
+	enabled       bool
+	min           int64
+	max           int64
+	scaleType     string
+	target        float64
+	resourceLabel string
+	// upCooldown and downCooldown are the least time between the scaling decisions of the RequestAutoscaler
+	upCooldown   time.Duration
+	downCooldown time.Duration
+	// zeroDuration is how long the function must go without requests before it is scaled to zero, zero disables
+	// scaling to zero
+	zeroDuration time.Duration
+}
+
+// applicationAutoScaling returns true when the function is scaled by Application Auto Scaling
+func (s *scalingSettings) applicationAutoScaling() bool {
+	return s.enabled && s.scaleType != scaleTypeConcurrency
+}
+
+// newScalingSettings reads the autoscaling settings from the labels
+func newScalingSettings(labels *map[string]string) (*scalingSettings, error) {
+	settings := &scalingSettings{
+		min:          aws.Int64Value(getMinReplicaCount(labels)),
+		max:          defaultScaleMax,
+		scaleType:    scaleTypeCPU,
+		upCooldown:   defaultScaleUpCooldown,
+		downCooldown: defaultScaleDownCooldown,
+	}
+
+	if labels == nil {
+		return settings, nil
+	}
+
+	if raw, exists := (*labels)[scaleMaxLabel]; exists {
+		max, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+		if err != nil || max < settings.min {
+			return nil, newValidationError("label %s must be a number no less than %s, got %s",
+				scaleMaxLabel, scaleMinLabel, raw)
+		}
+
+		settings.enabled = true
+		settings.max = max
+	}
+
+	if raw, exists := (*labels)[scaleTypeLabel]; exists {
+		if _, known := scaleMetrics[raw]; !known && raw != scaleTypeConcurrency {
+			return nil, newValidationError("label %s must be one of %s, %s, %s or %s, got %s",
+				scaleTypeLabel, scaleTypeCPU, scaleTypeMemory, scaleTypeRequests, scaleTypeConcurrency, raw)
+		}
+
+		settings.enabled = true
+		settings.scaleType = raw
+	}
+
+	settings.target = scaleMetrics[settings.scaleType].target
+	if settings.scaleType == scaleTypeConcurrency {
+		settings.target = defaultConcurrencyTarget
+	}
+	if raw, exists := (*labels)[scaleTargetLabel]; exists {
+		target, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+		if err != nil || target <= 0 {
+			return nil, newValidationError("label %s must be a positive number, got %s", scaleTargetLabel, raw)
+		}
+
+		settings.target = target
+	}
+
+	cooldowns := []struct {
+		label string
+		value *time.Duration
+	}{
+		{scaleUpCooldownLabel, &settings.upCooldown},
+		{scaleDownCooldownLabel, &settings.downCooldown},
+	}
+
+	for _, cooldown := range cooldowns {
+		raw, exists := (*labels)[cooldown.label]
+		if !exists {
+			continue
+		}
+
+		seconds, err := parseHealthValue(raw, true)
+		if err != nil || seconds < 0 {
+			return nil, newValidationError("label %s must be a number of seconds or a duration, got %s", cooldown.label, raw)
+		}
+
+		*cooldown.value = time.Duration(seconds) * time.Second
+	}
+
+	if raw, exists := (*labels)[scaleZeroDurationLabel]; exists {
+		seconds, err := parseHealthValue(raw, true)
+		if err != nil || seconds <= 0 {
+			return nil, newValidationError("label %s must be a positive number of seconds or a duration, got %s",
+				scaleZeroDurationLabel, raw)
+		}
+
+		settings.zeroDuration = time.Duration(seconds) * time.Second
+	}
+
+	// application auto scaling keeps at least the minimum replicas, so it would undo scaling to zero
+	if settings.zeroDuration > 0 && settings.applicationAutoScaling() {
+		return nil, newValidationError("label %s can not be used with label %s=%s, only with %s=%s or without autoscaling",
+			scaleZeroDurationLabel, scaleTypeLabel, settings.scaleType, scaleTypeLabel, scaleTypeConcurrency)
+	}
+
+	// a schedule sets the desired count itself, scale.max only bounds it, and Application Auto Scaling would undo it
+	if _, scheduled := (*labels)[scaleScheduleLabel]; scheduled {
+		if _, typed := (*labels)[scaleTypeLabel]; !typed {
+			settings.enabled = false
+		} else if settings.applicationAutoScaling() {
+			return nil, newValidationError("label %s can not be used with label %s=%s, only with %s=%s or without autoscaling",
+				scaleScheduleLabel, scaleTypeLabel, settings.scaleType, scaleTypeLabel, scaleTypeConcurrency)
+		}
+	}
+
+	settings.resourceLabel = (*labels)[scaleResourceLabelLabel]
+	if settings.enabled && settings.scaleType == scaleTypeRequests && len(settings.resourceLabel) == 0 {
+		return nil, newValidationError("label %s is required to scale on %s, it identifies the load balancer target group",
+			scaleResourceLabelLabel, scaleTypeRequests)
+	}
+
+	return settings, nil
+}
+
+// functionScalableTarget returns the scalable target of the function service
+func functionScalableTarget(namespace *Namespace, functionName string) *scalableTarget {
+	return &scalableTarget{
+		ServiceNamespace: aws.String(scalingServiceNamespace),
+		ResourceId: aws.String(fmt.Sprintf("service/%s/%s",
+			aws.StringValue(namespace.ClusterID()), namespace.ServiceNameFromFunctionName(functionName))),
+		ScalableDimension: aws.String(scalingDimension),
+	}
+}
+
+// policy returns the target tracking policy of the settings for the service
+func (s *scalingSettings) policy(serviceName string, target *scalableTarget) *scalingPolicy {
+	metric := &predefinedMetricSpecification{PredefinedMetricType: aws.String(scaleMetrics[s.scaleType].metric)}
+	if s.scaleType == scaleTypeRequests {
+		metric.ResourceLabel = aws.String(s.resourceLabel)
+	}
+
+	return &scalingPolicy{
+		PolicyName:        aws.String(serviceName + "-target-tracking"),
+		ServiceNamespace:  target.ServiceNamespace,
+		ResourceId:        target.ResourceId,
+		ScalableDimension: target.ScalableDimension,
+		PolicyType:        aws.String("TargetTrackingScaling"),
+		TargetTrackingScalingPolicyConfiguration: &targetTrackingConfiguration{
+			TargetValue:                   aws.Float64(s.target),
+			PredefinedMetricSpecification: metric,
+		},
+	}
+}
+
+// configureAutoscaling registers the function service as a scalable target with a target tracking policy, or removes
+// its autoscaling when it is not scaled by Application Auto Scaling. The undo function restores the autoscaling the
+// service had before.
+func configureAutoscaling(namespace *Namespace, functionName string, settings *scalingSettings) (undoFunc, error) {
+	target := functionScalableTarget(namespace, functionName)
+	previous, previousPolicies, err := describeAutoscaling(target)
+	if err != nil {
+		return nil, err
+	}
+
+	undo := func() error { return restoreAutoscaling(target, previous, previousPolicies) }
+
+	if !settings.applicationAutoScaling() {
+		if previous == nil {
+			return nil, nil
+		}
+
+		log.Infof("Removing autoscaling of %s", functionName)
+		if err := scalingClient.DeregisterScalableTarget(target); err != nil && !isScalingObjectNotFound(err) {
+			return nil, fmt.Errorf("error removing autoscaling of %s. %v", functionName, err)
+		}
+
+		return undo, nil
+	}
+
+	registration := scalableTargetID(target)
+	registration.MinCapacity = aws.Int64(settings.min)
+	registration.MaxCapacity = aws.Int64(settings.max)
+	if err := scalingClient.RegisterScalableTarget(registration); err != nil {
+		return nil, fmt.Errorf("error registering %s as a scalable target. %v", functionName, err)
+	}
+
+	policy := settings.policy(namespace.ServiceNameFromFunctionName(functionName), target)
+	if err := scalingClient.PutScalingPolicy(policy); err != nil {
+		restoreErr := restoreAutoscaling(target, previous, previousPolicies)
+		if restoreErr != nil {
+			log.Errorf("Error restoring autoscaling of %s. %v", functionName, restoreErr)
+		}
+
+		return nil, fmt.Errorf("error putting scaling policy of %s. %v", functionName, err)
+	}
+
+	// policies set by an earlier deploy of a different type are replaced
+	for _, item := range previousPolicies {
+		if aws.StringValue(item.PolicyName) == aws.StringValue(policy.PolicyName) {
+			continue
+		}
+
+		if err := scalingClient.DeleteScalingPolicy(item); err != nil && !isScalingObjectNotFound(err) {
+			log.Warnf("Error deleting scaling policy %s of %s. %v", aws.StringValue(item.PolicyName), functionName, err)
+		}
+	}
+
+	log.Infof("Autoscaling %s between %d and %d replicas on %s, target %v",
+		functionName, settings.min, settings.max, settings.scaleType, settings.target)
+
+	return undo, nil
+}
+
+// removeAutoscaling deregisters the function service as a scalable target, which also deletes its policies
+func removeAutoscaling(namespace *Namespace, functionName string) error {
+	err := scalingClient.DeregisterScalableTarget(functionScalableTarget(namespace, functionName))
+	if err != nil && !isScalingObjectNotFound(err) {
+		return fmt.Errorf("error removing autoscaling of %s. %v", functionName, err)
+	}
+
+	return nil
+}
+
+// describeAutoscaling returns the scalable target and its policies, or nil when the target is not registered
+func describeAutoscaling(target *scalableTarget) (*scalableTarget, []*scalingPolicy, error) {
+	existing, err := scalingClient.DescribeScalableTarget(target)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error describing scalable target %s. %v", aws.StringValue(target.ResourceId), err)
+	}
+
+	if existing == nil {
+		return nil, nil, nil
+	}
+
+	policies, err := scalingClient.DescribeScalingPolicies(target)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error describing scaling policies of %s. %v", aws.StringValue(target.ResourceId), err)
+	}
+
+	return existing, policies, nil
+}
+
+// restoreAutoscaling puts the scalable target back to how it was, deregistering it when it did not exist
+func restoreAutoscaling(target *scalableTarget, previous *scalableTarget, policies []*scalingPolicy) error {
+	if previous == nil {
+		err := scalingClient.DeregisterScalableTarget(target)
+		if err != nil && !isScalingObjectNotFound(err) {
+			return err
+		}
+
+		return nil
+	}
+
+	if err := scalingClient.RegisterScalableTarget(previous); err != nil {
+		return err
+	}
+
+	current, err := scalingClient.DescribeScalingPolicies(target)
+	if err != nil {
+		return err
+	}
+
+	keep := map[string]bool{}
+	for _, item := range policies {
+		keep[aws.StringValue(item.PolicyName)] = true
+		if err := scalingClient.PutScalingPolicy(item); err != nil {
+			return err
+		}
+	}
+
+	for _, item := range current {
+		if keep[aws.StringValue(item.PolicyName)] {
+			continue
+		}
+
+		if err := scalingClient.DeleteScalingPolicy(item); err != nil && !isScalingObjectNotFound(err) {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,213 @@
+package aws
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// localScaling stands in for the Application Auto Scaling api, keeping scalable targets and policies in memory
+type localScaling struct {
+	targets  map[string]*scalableTarget
+	policies map[string]map[string]*scalingPolicy
+}
+
+func newLocalScaling() *localScaling {
+	return &localScaling{targets: map[string]*scalableTarget{}, policies: map[string]map[string]*scalingPolicy{}}
+}
+
+func (l *localScaling) RegisterScalableTarget(target *scalableTarget) error {
+	id := aws.StringValue(target.ResourceId)
+	existing, found := l.targets[id]
+	if !found {
+		l.targets[id] = target
+		return nil
+	}
+
+	if target.MinCapacity != nil {
+		existing.MinCapacity = target.MinCapacity
+	}
+
+	if target.MaxCapacity != nil {
+		existing.MaxCapacity = target.MaxCapacity
+	}
+
+	return nil
+}
+
+func (l *localScaling) DeregisterScalableTarget(target *scalableTarget) error {
+	id := aws.StringValue(target.ResourceId)
+	if _, found := l.targets[id]; !found {
+		return awserr.New(scalingErrCodeObjectNotFound, "no scalable target "+id, nil)
+	}
+
+	delete(l.targets, id)
+	delete(l.policies, id)
+	return nil
+}
+
+func (l *localScaling) DescribeScalableTarget(target *scalableTarget) (*scalableTarget, error) {
+	existing, found := l.targets[aws.StringValue(target.ResourceId)]
+	if !found {
+		return nil, nil
+	}
+
+	copied := *existing
+	return &copied, nil
+}
+
+func (l *localScaling) PutScalingPolicy(policy *scalingPolicy) error {
+	id := aws.StringValue(policy.ResourceId)
+	if _, found := l.targets[id]; !found {
+		return awserr.New(scalingErrCodeObjectNotFound, "no scalable target "+id, nil)
+	}
+
+	if l.policies[id] == nil {
+		l.policies[id] = map[string]*scalingPolicy{}
+	}
+
+	l.policies[id][aws.StringValue(policy.PolicyName)] = policy
+	return nil
+}
+
+func (l *localScaling) DeleteScalingPolicy(policy *scalingPolicy) error {
+	id := aws.StringValue(policy.ResourceId)
+	if _, found := l.policies[id][aws.StringValue(policy.PolicyName)]; !found {
+		return awserr.New(scalingErrCodeObjectNotFound, "no policy "+aws.StringValue(policy.PolicyName), nil)
+	}
+
+	delete(l.policies[id], aws.StringValue(policy.PolicyName))
+	return nil
+}
+
+func (l *localScaling) DescribeScalingPolicies(target *scalableTarget) ([]*scalingPolicy, error) {
+	var result []*scalingPolicy
+	for _, item := range l.policies[aws.StringValue(target.ResourceId)] {
+		result = append(result, item)
+	}
+
+	return result, nil
+}
+
+// withLocalScaling runs the test against the in memory Application Auto Scaling stand-in
+func withLocalScaling(t *testing.T, test func(local *localScaling)) {
+	previous := scalingClient
+	local := newLocalScaling()
+	scalingClient = local
+	defer func() { scalingClient = previous }()
+
+	test(local)
+}
+
+func Test_ScalingSettings_Labels(t *testing.T) {
+	settings, err := newScalingSettings(&map[string]string{
+		scaleMinLabel:    "2",
+		scaleMaxLabel:    "8",
+		scaleTypeLabel:   scaleTypeMemory,
+		scaleTargetLabel: "60",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !settings.enabled || settings.min != 2 || settings.max != 8 || settings.target != 60 {
+		t.Errorf("Unexpected settings %+v", settings)
+	}
+
+	settings, err = newScalingSettings(&map[string]string{scaleMinLabel: "2"})
+	if err != nil || settings.enabled {
+		t.Errorf("Want autoscaling disabled without %s or %s, got %+v %v", scaleMaxLabel, scaleTypeLabel, settings, err)
+	}
+}
+
+func Test_ScalingSettings_Invalid(t *testing.T) {
+	for _, labels := range []map[string]string{
+		{scaleMinLabel: "5", scaleMaxLabel: "2"},
+		{scaleTypeLabel: "disk"},
+		{scaleTypeLabel: scaleTypeCPU, scaleTargetLabel: "-1"},
+		{scaleTypeLabel: scaleTypeRequests},
+		{scaleMaxLabel: "5", scaleZeroDurationLabel: "10m"},
+		{scaleTypeLabel: scaleTypeMemory, scaleZeroDurationLabel: "10m"},
+	} {
+		_, err := newScalingSettings(&labels)
+		if _, ok := err.(*ValidationError); !ok {
+			t.Errorf("Want validation error for %v, got %v", labels, err)
+		}
+	}
+}
+
+func Test_ConfigureAutoscaling(t *testing.T) {
+	withLocalScaling(t, func(local *localScaling) {
+		namespace := &Namespace{Name: "default", isDefault: true}
+		target := functionScalableTarget(namespace, "echo")
+		id := aws.StringValue(target.ResourceId)
+
+		settings, _ := newScalingSettings(&map[string]string{scaleMaxLabel: "5"})
+		if _, err := configureAutoscaling(namespace, "echo", settings); err != nil {
+			t.Fatal(err)
+		}
+
+		if aws.Int64Value(local.targets[id].MaxCapacity) != 5 || len(local.policies[id]) != 1 {
+			t.Fatalf("Want a target with max 5 and one policy, got %v %v", local.targets[id], local.policies[id])
+		}
+
+		settings, _ = newScalingSettings(&map[string]string{scaleMaxLabel: "10", scaleTypeLabel: scaleTypeMemory})
+		undo, err := configureAutoscaling(namespace, "echo", settings)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, policy := range local.policies[id] {
+			metric := policy.TargetTrackingScalingPolicyConfiguration.PredefinedMetricSpecification.PredefinedMetricType
+			if aws.StringValue(metric) != scaleMetrics[scaleTypeMemory].metric {
+				t.Errorf("Want the policy to track memory, got %s", aws.StringValue(metric))
+			}
+		}
+
+		if err := undo(); err != nil {
+			t.Fatal(err)
+		}
+
+		if aws.Int64Value(local.targets[id].MaxCapacity) != 5 {
+			t.Errorf("Want undo to restore max 5, got %d", aws.Int64Value(local.targets[id].MaxCapacity))
+		}
+
+		settings, _ = newScalingSettings(nil)
+		if _, err := configureAutoscaling(namespace, "echo", settings); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, found := local.targets[id]; found {
+			t.Error("Want autoscaling removed when it is no longer enabled")
+		}
+	})
+}
+
+func Test_ApplicationAutoScaling_BuildsJSONRequest(t *testing.T) {
+	client := newApplicationAutoScaling(session.Must(session.NewSession()), aws.NewConfig().WithRegion("us-east-1"))
+	target := functionScalableTarget(&Namespace{Name: "default", isDefault: true}, "echo")
+	target.MinCapacity = aws.Int64(1)
+
+	req := client.NewRequest(&request.Operation{Name: "RegisterScalableTarget", HTTPMethod: "POST", HTTPPath: "/"}, target, &struct{}{})
+	if err := req.Build(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := req.HTTPRequest.Header.Get("X-Amz-Target"); got != "AnyScaleFrontendService.RegisterScalableTarget" {
+		t.Errorf("Unexpected target %s", got)
+	}
+
+	if got := req.HTTPRequest.URL.Host; got != "autoscaling.us-east-1.amazonaws.com" {
+		t.Errorf("Unexpected endpoint %s", got)
+	}
+
+	body, _ := ioutil.ReadAll(req.GetBody())
+	want := `{"ServiceNamespace":"ecs","ResourceId":"service/openfaas/openfaas-echo","ScalableDimension":"ecs:service:DesiredCount","MinCapacity":1}`
+	if string(body) != want {
+		t.Errorf("Want body %s, got %s", want, string(body))
+	}
+}
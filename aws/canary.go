@@ -0,0 +1,699 @@
+package aws
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/servicediscovery"
+	"github.com/ewilde/faas-fargate/types"
+	"github.com/openfaas/faas/gateway/requests"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// canarySuffix is added to the function name to name the ECS service, route 53 auto-naming service, role and
+	// registry auth secret of its canary. Function names may not end with it.
+	canarySuffix = "-canary"
+	// canaryWeightLabel sets the percentage of requests routed to a canary when it is deployed
+	canaryWeightLabel   = "com.openfaas.canary.weight"
+	defaultCanaryWeight = 10
+	// canaryShadowLabel sets the percentage of requests to the function mirrored to a canary when it is deployed
+	canaryShadowLabel = "com.openfaas.canary.shadow"
+	// canaryWeightMarker and canaryShadowMarker precede the weight and shadow percentage in the description of the
+	// canary route 53 auto-naming service, which is where they are kept so every faas-fargate replica routes the same
+	// way
+	canaryWeightMarker = "weight="
+	canaryShadowMarker = "shadow="
+	// canaryRouteRefreshInterval is how often the routing of every canary is read in the background
+	canaryRouteRefreshInterval = 5 * time.Second
+
+	// historyActionPromote records a canary revision replacing the stable revision
+	historyActionPromote = "promote"
+)
+
+// CanaryStatus describes the canary of a function and the share of requests routed to it
+type CanaryStatus struct {
+	Function string `json:"function"`
+	// Weight is the percentage of requests routed to the canary
+	Weight int64 `json:"weight"`
+	// Shadow is the percentage of requests to the function mirrored to the canary, whose responses are discarded
+	Shadow int64 `json:"shadow"`
+	// Stable is the task definition the function service is running
+	Stable string `json:"stable"`
+	// Canary is the task definition the canary service is running
+	Canary  string `json:"canary"`
+	Desired int64  `json:"desired"`
+	Running int64  `json:"running"`
+}
+
+// canaryRouting is how requests to a function are shared with its canary
+type canaryRouting struct {
+	weight int64
+	shadow int64
+}
+
+// Route is where a request to a function is sent
+type Route struct {
+	// HostName is the private dns name of the function or of its canary
+	HostName string
+	// ShadowHostName, when set, is sent a copy of the request whose response is discarded
+	ShadowHostName string
+}
+
+var canaryRoutesLock = &sync.Mutex{}
+
+// canaryRoutes holds the routing of the functions with a canary by rolloutKey, functions without one are not kept
+var canaryRoutes = map[string]canaryRouting{}
+
+// canaryName returns the name the canary of the function is deployed under
+func canaryName(functionName string) string {
+	return functionName + canarySuffix
+}
+
+// DeployCanary registers a task definition revision for the request and runs it as the canary of the function,
+// alongside the stable service. The canary gets the weight and shadow percentage of the com.openfaas.canary.weight
+// and com.openfaas.canary.shadow labels. The revision uses a role and registry auth secret of its own, so the
+// function keeps its access until the canary is promoted. Returns nil if the function is not found.
+func DeployCanary(
+	namespace *Namespace,
+	request requests.CreateFunctionRequest,
+	config *types.DeployHandlerConfig) (*CanaryStatus, error) {
+
+	routing, err := canaryRoutingFromLabels(request.Labels)
+	if err != nil {
+		return nil, err
+	}
+
+	stable, err := describeFunctionService(namespace, request.Service)
+	if err != nil || stable == nil {
+		return nil, err
+	}
+
+	d := newDeployment(request.Service)
+	taskDefinition, err := createTaskRevision(d, namespace, request, canaryName(request.Service), config)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := describeCanaryService(namespace, request.Service)
+	if err != nil {
+		return nil, d.rollback(stepService, err)
+	}
+
+	canaryRequest := request
+	canaryRequest.Service = canaryName(request.Service)
+
+	if existing != nil {
+		err = d.run(stepService, func() (undoFunc, error) {
+			_, err := ecsClient.UpdateService(updateServiceInput(namespace, existing, taskDefinition.TaskDefinition.TaskDefinitionArn, canaryRequest))
+			if err != nil {
+				return nil, fmt.Errorf("error updating canary service %s. %v", aws.StringValue(existing.ServiceName), err)
+			}
+
+			return func() error {
+				_, err := ecsClient.UpdateService(&ecs.UpdateServiceInput{
+					Cluster:        namespace.ClusterID(),
+					Service:        existing.ServiceArn,
+					DesiredCount:   existing.DesiredCount,
+					TaskDefinition: existing.TaskDefinition,
+				})
+				return err
+			}, nil
+		})
+	} else {
+		var registryArn string
+		err = d.run(stepServiceDiscovery, func() (undo undoFunc, err error) {
+			registryArn, undo, err = ensureServiceRegistrationExists(namespace, canaryRequest.Service, config.VpcID)
+			return undo, err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		err = d.run(stepService, func() (undoFunc, error) {
+			service, err := createECSService(namespace, taskDefinition.TaskDefinition, canaryRequest, config, registryArn)
+			if err != nil {
+				return nil, err
+			}
+
+			return func() error { return deleteECSService(namespace, service.ServiceArn) }, nil
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.run(stepCanaryWeight, func() (undoFunc, error) {
+		return nil, setCanaryRouting(namespace, request.Service, func(current *canaryRouting) { *current = routing })
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("Deployed canary of %s running %s with weight %d, shadow %d", request.Service,
+		aws.StringValue(taskDefinition.TaskDefinition.TaskDefinitionArn), routing.weight, routing.shadow)
+
+	return GetCanary(namespace, request.Service)
+}
+
+// GetCanary returns the canary of the function, or nil if it has none
+func GetCanary(namespace *Namespace, functionName string) (*CanaryStatus, error) {
+	stable, err := describeFunctionService(namespace, functionName)
+	if err != nil || stable == nil {
+		return nil, err
+	}
+
+	canary, err := describeCanaryService(namespace, functionName)
+	if err != nil || canary == nil {
+		return nil, err
+	}
+
+	routing, err := lookupCanaryRouting(namespace, functionName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CanaryStatus{
+		Function: functionName,
+		Weight:   routing.weight,
+		Shadow:   routing.shadow,
+		Stable:   aws.StringValue(stable.TaskDefinition),
+		Canary:   aws.StringValue(canary.TaskDefinition),
+		Desired:  aws.Int64Value(canary.DesiredCount),
+		Running:  aws.Int64Value(canary.RunningCount),
+	}, nil
+}
+
+// SetCanaryWeight sets the percentage of requests routed to the canary of the function
+func SetCanaryWeight(namespace *Namespace, functionName string, weight int64) error {
+	if weight < 0 || weight > 100 {
+		return newValidationError("canary weight must be between 0 and 100, got %d", weight)
+	}
+
+	return setCanaryRouting(namespace, functionName, func(routing *canaryRouting) { routing.weight = weight })
+}
+
+// SetCanaryShadow sets the percentage of requests to the function mirrored to its canary
+func SetCanaryShadow(namespace *Namespace, functionName string, percent int64) error {
+	if percent < 0 || percent > 100 {
+		return newValidationError("canary shadow percentage must be between 0 and 100, got %d", percent)
+	}
+
+	return setCanaryRouting(namespace, functionName, func(routing *canaryRouting) { routing.shadow = percent })
+}
+
+// setCanaryRouting applies the change to the routing kept in the description of the canary route 53 auto-naming
+// service
+func setCanaryRouting(namespace *Namespace, functionName string, change func(routing *canaryRouting)) error {
+	registration, err := findCanaryRegistration(namespace, functionName)
+	if err != nil {
+		return err
+	}
+
+	if registration == nil {
+		return newValidationError("function %s has no canary", functionName)
+	}
+
+	routing := parseCanaryDescription(aws.StringValue(registration.Description))
+	change(&routing)
+
+	_, err = discoveryClient.UpdateService(&servicediscovery.UpdateServiceInput{
+		Id: registration.Id,
+		Service: &servicediscovery.ServiceChange{
+			Description: aws.String(canaryDescription(namespace, functionName, routing)),
+			DnsConfig: &servicediscovery.DnsConfigChange{
+				DnsRecords: []*servicediscovery.DnsRecord{{Type: aws.String("A"), TTL: aws.Int64(10)}},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error setting canary routing of %s. %v", functionName, err)
+	}
+
+	cacheCanaryRouting(namespace, functionName, routing)
+	return nil
+}
+
+// PromoteCanary registers the canary revision again with the role and registry credentials of the function, points
+// the function service at it and removes the canary. Returns nil if the function has no canary.
+func PromoteCanary(namespace *Namespace, functionName string, config *types.DeployHandlerConfig) (*CanaryStatus, error) {
+	status, err := GetCanary(namespace, functionName)
+	if err != nil || status == nil {
+		return nil, err
+	}
+
+	output, err := ecsClient.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{TaskDefinition: aws.String(status.Canary)})
+	if err != nil {
+		return nil, fmt.Errorf("error describing task definition %s. %v", status.Canary, err)
+	}
+
+	d := newDeployment(functionName)
+	promoted, err := promoteTaskRevision(d, namespace, functionName, output.TaskDefinition)
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.run(stepService, func() (undoFunc, error) {
+		_, err := ecsClient.UpdateService(&ecs.UpdateServiceInput{
+			Cluster:        namespace.ClusterID(),
+			Service:        aws.String(namespace.ServiceNameFromFunctionName(functionName)),
+			TaskDefinition: promoted.TaskDefinitionArn,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error promoting canary of %s to %s. %v",
+				functionName, aws.StringValue(promoted.TaskDefinitionArn), err)
+		}
+
+		return nil, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	status.Canary = aws.StringValue(promoted.TaskDefinitionArn)
+	log.Infof("Promoted canary of %s, replacing %s with %s", functionName, status.Stable, status.Canary)
+
+	event := historyEvent{Time: time.Now().UTC(), Action: historyActionPromote, From: status.Stable, To: status.Canary}
+	if err := recordHistoryEvent(namespace, functionName, event); err != nil {
+		log.Warnf("Error recording promotion of %s. %v", functionName, err)
+	}
+
+	if err := deleteCanary(namespace, functionName); err != nil {
+		return nil, err
+	}
+
+	status.Weight = 0
+	status.Shadow = 0
+	return status, nil
+}
+
+// AbortCanary routes every request back to the function service and removes the canary. Returns nil if the function
+// has no canary.
+func AbortCanary(namespace *Namespace, functionName string, config *types.DeployHandlerConfig) (*CanaryStatus, error) {
+	status, err := GetCanary(namespace, functionName)
+	if err != nil || status == nil {
+		return nil, err
+	}
+
+	if err := deleteCanary(namespace, functionName); err != nil {
+		return nil, err
+	}
+
+	log.Infof("Aborted canary of %s running %s", functionName, status.Canary)
+
+	status.Weight = 0
+	status.Shadow = 0
+	return status, nil
+}
+
+// deleteCanary stops routing to the canary of the function then deletes its service, route 53 auto-naming service,
+// role and registry auth secret
+func deleteCanary(namespace *Namespace, functionName string) error {
+	cacheCanaryRouting(namespace, functionName, canaryRouting{})
+
+	canary, err := describeCanaryService(namespace, functionName)
+	if err != nil {
+		return err
+	}
+
+	if canary != nil {
+		if err := deleteECSService(namespace, canary.ServiceArn); err != nil {
+			return err
+		}
+	}
+
+	registration, err := findCanaryRegistration(namespace, functionName)
+	if err != nil {
+		return err
+	}
+
+	if registration != nil {
+		err := deleteServiceRegistrationByID(aws.StringValue(registration.Name), aws.StringValue(registration.Id))
+		if err != nil {
+			return err
+		}
+	}
+
+	return deleteCanaryAccess(namespace, functionName)
+}
+
+// isCanaryRevision returns true if the task definition revision was registered for the canary of the function, so
+// uses the role of the canary
+func isCanaryRevision(namespace *Namespace, functionName string, taskDefinition *ecs.TaskDefinition) bool {
+	parts := strings.Split(aws.StringValue(taskDefinition.TaskRoleArn), "/")
+	return parts[len(parts)-1] == namespace.ServiceNameFromFunctionName(canaryName(functionName))
+}
+
+// deleteCanaryAccess removes the role and registry auth secret created for the canary of the function
+func deleteCanaryAccess(namespace *Namespace, functionName string) error {
+	roleName := namespace.ServiceNameFromFunctionName(canaryName(functionName))
+	_, err := iamClient.GetRole(&iam.GetRoleInput{RoleName: aws.String(roleName)})
+	if checkForErrorAllowEntityNotExists(err) != nil {
+		return fmt.Errorf("error finding canary role %s. %v", roleName, err)
+	}
+
+	if err == nil {
+		if err := deleteRole(namespace, canaryName(functionName)); err != nil {
+			return err
+		}
+	}
+
+	return deleteRegistryAuthSecret(namespace, canaryName(functionName))
+}
+
+// promoteTaskRevision registers the canary revision as a revision of the function using the role and registry auth
+// secret of the function, which are given the access of the canary
+func promoteTaskRevision(
+	d *deployment,
+	namespace *Namespace,
+	functionName string,
+	canary *ecs.TaskDefinition) (*ecs.TaskDefinition, error) {
+
+	container := FunctionContainer(canary)
+	if container == nil {
+		return nil, fmt.Errorf("task definition %s has no function container", aws.StringValue(canary.TaskDefinitionArn))
+	}
+
+	name := aws.StringValue(canary.Family)
+	policy := NewPolicyBuilder()
+	if err := buildLogPolicyStatement(policy, logGroupName(namespace, functionName)); err != nil {
+		return nil, err
+	}
+
+	if secrets := revisionSecrets(canary); len(secrets) > 0 {
+		if err := buildSecretsPolicyStatement(policy, functionName, secrets); err != nil {
+			return nil, err
+		}
+	}
+
+	labels := map[string]*string{}
+	for key, value := range container.DockerLabels {
+		labels[key] = value
+	}
+	labels[registeredAtLabel] = aws.String(time.Now().UTC().Format(time.RFC3339))
+	delete(labels, registryAuthVersionLabel)
+
+	repositoryCredentials := map[string]string{}
+	if version, found := container.DockerLabels[registryAuthVersionLabel]; found {
+		credentials, err := readRegistryAuthSecret(namespace, canaryName(functionName), aws.StringValue(version))
+		if err != nil {
+			return nil, err
+		}
+
+		var secretArn, functionVersion string
+		err = d.run(stepRegistryAuth, func() (undo undoFunc, err error) {
+			secretArn, functionVersion, undo, err = ensureRegistryAuthSecret(namespace, functionName, credentials)
+			return undo, err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		buildRegistryAuthPolicyStatement(policy, secretArn)
+		repositoryCredentials[name] = secretArn
+		labels[registryAuthVersionLabel] = aws.String(functionVersion)
+	}
+
+	var roleArn string
+	err := d.run(stepRole, func() (undo undoFunc, err error) {
+		roleArn, undo, err = createRoleWithPolicy(namespace, functionName, policy.String())
+		return undo, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	input := &ecs.RegisterTaskDefinitionInput{
+		Family:                  canary.Family,
+		Cpu:                     canary.Cpu,
+		Memory:                  canary.Memory,
+		NetworkMode:             canary.NetworkMode,
+		RequiresCompatibilities: canary.RequiresCompatibilities,
+		TaskRoleArn:             aws.String(roleArn),
+		ExecutionRoleArn:        aws.String(roleArn),
+	}
+
+	for _, item := range canary.ContainerDefinitions {
+		definition := *item
+		if item == container {
+			definition.DockerLabels = labels
+		}
+
+		input.ContainerDefinitions = append(input.ContainerDefinitions, &definition)
+	}
+
+	var output *ecs.RegisterTaskDefinitionOutput
+	err = d.run(stepTaskDefinition, func() (undoFunc, error) {
+		output, err = registerTaskDefinition(input, repositoryCredentials)
+		if err != nil {
+			return nil, fmt.Errorf("error registering task definition %s. %v", name, err)
+		}
+
+		return func() error { return deregisterTaskDefinition(output.TaskDefinition.TaskDefinitionArn) }, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return output.TaskDefinition, nil
+}
+
+// describeCanaryService returns the ECS service running the canary of the function, or nil if there is none
+func describeCanaryService(namespace *Namespace, functionName string) (*ecs.Service, error) {
+	details, err := ecsClient.DescribeServices(&ecs.DescribeServicesInput{
+		Cluster:  namespace.ClusterID(),
+		Services: []*string{aws.String(namespace.ServiceNameFromFunctionName(canaryName(functionName)))},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error describing canary service of %s. %v", functionName, err)
+	}
+
+	for _, item := range details.Services {
+		if aws.StringValue(item.Status) != "ACTIVE" {
+			continue
+		}
+
+		task, err := ecsClient.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{TaskDefinition: item.TaskDefinition})
+		if err != nil {
+			return nil, err
+		}
+
+		// the canary runs a revision of the function, so its task definition is labelled with the function name
+		if namespace.isOwnedFunction(FunctionContainer(task.TaskDefinition), functionName) {
+			return item, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// findCanaryRegistration returns the route 53 auto-naming service of the canary of the function, or nil if there is
+// none. A service with the canary name that is not marked as owned by the canary, such as the service of a function
+// deployed with that name before the suffix was reserved, is ignored.
+func findCanaryRegistration(namespace *Namespace, functionName string) (*servicediscovery.ServiceSummary, error) {
+	namespaceID, found, err := lookupDNSNamespace(namespace)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	registration, err := findServiceRegistration(namespaceID, namespace.DiscoveryNameFromFunctionName(canaryName(functionName)))
+	if err != nil {
+		return nil, fmt.Errorf("error finding canary route 53 auto-naming service of %s. %v", functionName, err)
+	}
+
+	if registration == nil || !isOwnedRegistration(namespace, aws.StringValue(registration.Description), canaryName(functionName)) {
+		return nil, nil
+	}
+
+	return registration, nil
+}
+
+// lookupCanaryRouting reads the routing of the canary of the function, nothing is routed when there is no canary
+func lookupCanaryRouting(namespace *Namespace, functionName string) (canaryRouting, error) {
+	registration, err := findCanaryRegistration(namespace, functionName)
+	if err != nil || registration == nil {
+		return canaryRouting{}, err
+	}
+
+	return parseCanaryDescription(aws.StringValue(registration.Description)), nil
+}
+
+// canaryDescription returns the description of the canary route 53 auto-naming service, keeping the owner marker the
+// service was created with
+func canaryDescription(namespace *Namespace, functionName string, routing canaryRouting) string {
+	return fmt.Sprintf("Openfaas canary of %s, %s%s, %s%d, %s%d",
+		functionName, registrationOwnerMarker, registrationOwner(namespace, canaryName(functionName)),
+		canaryWeightMarker, routing.weight, canaryShadowMarker, routing.shadow)
+}
+
+// parseCanaryDescription reads the routing from the description of a canary route 53 auto-naming service
+func parseCanaryDescription(description string) canaryRouting {
+	routing := canaryRouting{}
+	for _, item := range strings.Split(description, ", ") {
+		for marker, value := range map[string]*int64{canaryWeightMarker: &routing.weight, canaryShadowMarker: &routing.shadow} {
+			if !strings.HasPrefix(item, marker) {
+				continue
+			}
+
+			percent, err := strconv.ParseInt(strings.TrimPrefix(item, marker), 10, 64)
+			if err == nil && percent >= 0 && percent <= 100 {
+				*value = percent
+			}
+		}
+	}
+
+	return routing
+}
+
+// canaryRoutingFromLabels reads the com.openfaas.canary.weight and com.openfaas.canary.shadow labels
+func canaryRoutingFromLabels(labels *map[string]string) (canaryRouting, error) {
+	routing := canaryRouting{weight: defaultCanaryWeight}
+	if labels == nil {
+		return routing, nil
+	}
+
+	for label, value := range map[string]*int64{canaryWeightLabel: &routing.weight, canaryShadowLabel: &routing.shadow} {
+		raw, exists := (*labels)[label]
+		if !exists {
+			continue
+		}
+
+		percent, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return canaryRouting{}, newValidationError("label %s must be between 0 and 100, got %s", label, raw)
+		}
+
+		*value = percent
+	}
+
+	return routing, nil
+}
+
+// cacheCanaryRouting applies a routing change made by this replica straight away, other replicas pick it up on their
+// next refresh
+func cacheCanaryRouting(namespace *Namespace, functionName string, routing canaryRouting) {
+	canaryRoutesLock.Lock()
+	defer canaryRoutesLock.Unlock()
+
+	key := rolloutKey(namespace, functionName)
+	if routing == (canaryRouting{}) {
+		delete(canaryRoutes, key)
+		return
+	}
+
+	canaryRoutes[key] = routing
+}
+
+// cachedCanaryRouting returns the routing of the canary of the function, nothing is routed to a canary of a function
+// not found by the last refresh
+func cachedCanaryRouting(namespace *Namespace, functionName string) canaryRouting {
+	canaryRoutesLock.Lock()
+	defer canaryRoutesLock.Unlock()
+
+	return canaryRoutes[rolloutKey(namespace, functionName)]
+}
+
+// StartCanaryRouting reads the routing of every canary in the background, so routing a request never waits on route
+// 53 auto-naming
+func StartCanaryRouting() {
+	go func() {
+		refreshCanaryRoutes()
+
+		ticker := time.NewTicker(canaryRouteRefreshInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			refreshCanaryRoutes()
+		}
+	}()
+}
+
+// refreshCanaryRoutes replaces the cached routing with that of the canaries found in every namespace, forgetting
+// functions whose canary was removed. A namespace that can not be read keeps its routing until the next refresh.
+func refreshCanaryRoutes() {
+	canaryRoutesLock.Lock()
+	previous := canaryRoutes
+	canaryRoutesLock.Unlock()
+
+	routes := map[string]canaryRouting{}
+	for _, name := range Namespaces() {
+		namespace, err := GetNamespace(name)
+		if err == nil {
+			var found map[string]canaryRouting
+			found, err = listCanaryRoutes(namespace)
+			for key, routing := range found {
+				routes[key] = routing
+			}
+		}
+
+		if err != nil {
+			log.Warnf("Error reading canary routing in namespace %s. %v", name, err)
+			for key, routing := range previous {
+				if strings.HasPrefix(key, name+"/") {
+					routes[key] = routing
+				}
+			}
+		}
+	}
+
+	canaryRoutesLock.Lock()
+	canaryRoutes = routes
+	canaryRoutesLock.Unlock()
+}
+
+// listCanaryRoutes returns the routing of the canaries in the namespace which route or mirror requests, by rolloutKey
+func listCanaryRoutes(namespace *Namespace) (map[string]canaryRouting, error) {
+	registrations, err := serviceRegistrations(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := namespace.DiscoveryNameFromFunctionName("")
+	routes := map[string]canaryRouting{}
+	for name, registration := range registrations {
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, canarySuffix) {
+			continue
+		}
+
+		functionName := strings.TrimSuffix(strings.TrimPrefix(name, prefix), canarySuffix)
+		description := aws.StringValue(registration.Description)
+		if len(functionName) == 0 || !isOwnedRegistration(namespace, description, canaryName(functionName)) {
+			continue
+		}
+
+		if routing := parseCanaryDescription(description); routing != (canaryRouting{}) {
+			routes[rolloutKey(namespace, functionName)] = routing
+		}
+	}
+
+	return routes, nil
+}
+
+// RouteRequest returns where a request to the function is sent. The canary receives the share of requests given by
+// its weight, and a copy of the shadow percentage of the requests sent to the function.
+func RouteRequest(namespace *Namespace, functionName string) Route {
+	return newRoute(namespace, functionName, cachedCanaryRouting(namespace, functionName), rand.Int63n(100), rand.Int63n(100))
+}
+
+// newRoute routes to the canary when weightRoll, a number from 0 to 99, falls below the weight and otherwise mirrors
+// to the canary when shadowRoll falls below the shadow percentage
+func newRoute(namespace *Namespace, functionName string, routing canaryRouting, weightRoll int64, shadowRoll int64) Route {
+	canary := namespace.HostNameFromFunctionName(canaryName(functionName))
+	if weightRoll < routing.weight {
+		return Route{HostName: canary}
+	}
+
+	route := Route{HostName: namespace.HostNameFromFunctionName(functionName)}
+	if shadowRoll < routing.shadow {
+		route.ShadowHostName = canary
+	}
+
+	return route
+}
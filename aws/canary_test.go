@@ -0,0 +1,122 @@
+package aws
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/ewilde/faas-fargate/types"
+	"github.com/openfaas/faas/gateway/requests"
+)
+
+func Test_CanaryRoutingFromLabels(t *testing.T) {
+	routing, err := canaryRoutingFromLabels(&map[string]string{canaryWeightLabel: "25", canaryShadowLabel: "50"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if routing.weight != 25 || routing.shadow != 50 {
+		t.Errorf("Want weight 25 shadow 50, got %+v", routing)
+	}
+
+	routing, err = canaryRoutingFromLabels(nil)
+	if err != nil || routing.weight != defaultCanaryWeight || routing.shadow != 0 {
+		t.Errorf("Want default weight %d and no shadow, got %+v %v", defaultCanaryWeight, routing, err)
+	}
+
+	if _, err := canaryRoutingFromLabels(&map[string]string{canaryShadowLabel: "101"}); err == nil {
+		t.Error("Want error for shadow percentage above 100")
+	}
+}
+
+func Test_ParseCanaryDescription(t *testing.T) {
+	namespace := &Namespace{Name: "default", isDefault: true}
+	want := canaryRouting{weight: 40, shadow: 5}
+	description := canaryDescription(namespace, "echo", want)
+	if routing := parseCanaryDescription(description); routing != want {
+		t.Errorf("Want %+v, got %+v", want, routing)
+	}
+
+	if !isOwnedRegistration(namespace, description, canaryName("echo")) {
+		t.Errorf("Want the canary description to keep the canary owner marker, got %s", description)
+	}
+
+	if routing := parseCanaryDescription("Openfaas auto-naming service for echo-canary"); routing != (canaryRouting{}) {
+		t.Errorf("Want no routing before it is set, got %+v", routing)
+	}
+}
+
+func Test_NewRoute(t *testing.T) {
+	namespace := &Namespace{Name: "default", isDefault: true}
+	stable := namespace.HostNameFromFunctionName("echo")
+	canary := namespace.HostNameFromFunctionName("echo-canary")
+
+	canaryRequests := 0
+	for roll := int64(0); roll < 100; roll++ {
+		if newRoute(namespace, "echo", canaryRouting{weight: 10}, roll, 99).HostName == canary {
+			canaryRequests++
+		}
+	}
+
+	if canaryRequests != 10 {
+		t.Errorf("Want 10 of 100 requests routed to the canary, got %d", canaryRequests)
+	}
+
+	if route := newRoute(namespace, "echo", canaryRouting{}, 0, 0); route != (Route{HostName: stable}) {
+		t.Errorf("Want only %s with no canary routing, got %+v", stable, route)
+	}
+
+	route := newRoute(namespace, "echo", canaryRouting{weight: 10, shadow: 20}, 50, 5)
+	if route.HostName != stable || route.ShadowHostName != canary {
+		t.Errorf("Want %s mirrored to %s, got %+v", stable, canary, route)
+	}
+
+	route = newRoute(namespace, "echo", canaryRouting{weight: 10, shadow: 20}, 5, 5)
+	if route.HostName != canary || len(route.ShadowHostName) > 0 {
+		t.Errorf("Want requests routed to the canary not mirrored, got %+v", route)
+	}
+}
+
+func Test_RenderTaskDefinition_RejectsCanarySuffix(t *testing.T) {
+	config := &types.DeployHandlerConfig{Region: "eu-west-1"}
+	request := requests.CreateFunctionRequest{Service: "echo-canary", Image: "functions/alpine"}
+
+	_, err := renderTaskDefinition(DefaultNamespace(), request, config)
+	if _, ok := err.(*ValidationError); !ok {
+		t.Errorf("Want a validation error for a function named like a canary, got %v", err)
+	}
+}
+
+func Test_IsCanaryRevision(t *testing.T) {
+	namespace := &Namespace{Name: "default", isDefault: true}
+	canary := &ecs.TaskDefinition{TaskRoleArn: aws.String("arn:aws:iam::123456789012:role/openfaas/default/default/openfaas-echo-canary")}
+	if !isCanaryRevision(namespace, "echo", canary) {
+		t.Errorf("Want a revision using the canary role to be a canary revision")
+	}
+
+	stable := &ecs.TaskDefinition{TaskRoleArn: aws.String("arn:aws:iam::123456789012:role/openfaas/default/default/openfaas-echo")}
+	if isCanaryRevision(namespace, "echo", stable) {
+		t.Errorf("Want a revision using the function role not to be a canary revision")
+	}
+}
+
+func Test_CacheCanaryRouting_ForgetsRemovedCanaries(t *testing.T) {
+	namespace := &Namespace{Name: "default", isDefault: true}
+	cacheCanaryRouting(namespace, "echo", canaryRouting{weight: 20})
+	if routing := cachedCanaryRouting(namespace, "echo"); routing.weight != 20 {
+		t.Errorf("Want weight 20, got %+v", routing)
+	}
+
+	cacheCanaryRouting(namespace, "echo", canaryRouting{})
+	if _, found := canaryRoutes[rolloutKey(namespace, "echo")]; found {
+		t.Errorf("Want a function without a canary not to be kept")
+	}
+
+	if routing := cachedCanaryRouting(namespace, "unknown"); routing != (canaryRouting{}) {
+		t.Errorf("Want nothing routed to the canary of an unknown function, got %+v", routing)
+	}
+
+	if len(canaryRoutes) != 0 {
+		t.Errorf("Want looking up an unknown function not to cache it, got %v", canaryRoutes)
+	}
+}
@@ -25,27 +25,51 @@ func buildLogPolicyStatement(
 	return nil
 }
 
-func createLogGroup(functionName string) (string, error) {
-	name := ServiceNameFromFunctionName(functionName)
+// logGroupName returns the name of the log group of the function
+func logGroupName(namespace *Namespace, functionName string) string {
+	return namespace.ServiceNameFromFunctionName(functionName)
+}
+
+// createLogGroup creates the log group of the function if it does not exist, returning an undo function which
+// deletes it if it was created. An existing log group tagged as another function's is a ConflictError.
+func createLogGroup(namespace *Namespace, functionName string) (undoFunc, error) {
+	name := logGroupName(namespace, functionName)
 	_, err := cloudwatchClient.CreateLogGroup(&cloudwatchlogs.CreateLogGroupInput{
 		LogGroupName: aws.String(name),
+		Tags:         namespace.ownershipLabels(functionName),
 	})
 
 	if err != nil {
 		if awsErr, ok := err.(awserr.Error); ok {
 			if awsErr.Code() == cloudwatchlogs.ErrCodeResourceAlreadyExistsException {
-				return name, nil
+				return nil, checkLogGroupOwner(namespace, functionName)
 			}
 		}
 
-		return "", fmt.Errorf("error creating log group for %s. %v", functionName, err)
+		return nil, fmt.Errorf("error creating log group for %s. %v", functionName, err)
+	}
+
+	return func() error { return deleteLogGroup(namespace, functionName) }, nil
+}
+
+// checkLogGroupOwner returns a ConflictError if the log group of the function belongs to another function, whose
+// name in another installation or namespace gives the same log group name
+func checkLogGroupOwner(namespace *Namespace, functionName string) error {
+	name := logGroupName(namespace, functionName)
+	tags, err := cloudwatchClient.ListTagsLogGroup(&cloudwatchlogs.ListTagsLogGroupInput{LogGroupName: aws.String(name)})
+	if err != nil {
+		return fmt.Errorf("error listing tags of log group %s. %v", name, err)
 	}
 
-	return name, nil
+	if !namespace.isOwnedResource(tags.Tags, functionName) {
+		return newConflictError("log group %s belongs to a function of another installation or namespace", name)
+	}
+
+	return nil
 }
 
-func deleteLogGroup(functionName string) error {
-	name := ServiceNameFromFunctionName(functionName)
+func deleteLogGroup(namespace *Namespace, functionName string) error {
+	name := logGroupName(namespace, functionName)
 	_, err := cloudwatchClient.DeleteLogGroup(&cloudwatchlogs.DeleteLogGroupInput{
 		LogGroupName: aws.String(name),
 	})
@@ -0,0 +1,188 @@
+package aws
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSearchLimit is how far ahead the next time of a cron expression is looked for
+const cronSearchLimit = 5 * 365 * 24 * time.Hour
+
+var cronMonthNames = map[string]int{
+	"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6,
+	"JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12,
+}
+
+var cronWeekdayNames = map[string]int{
+	"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6,
+}
+
+// cronExpression is a standard five field cron expression: minute, hour, day of month, month and day of week. Each
+// field is a bit set of the values it matches.
+type cronExpression struct {
+	raw      string
+	minutes  uint64
+	hours    uint64
+	days     uint64
+	months   uint64
+	weekdays uint64
+	// a day matches either the day of month or the day of week unless one of them is *
+	anyDay     bool
+	anyWeekday bool
+}
+
+// parseCron parses a cron expression such as 0 8 * * MON-FRI
+func parseCron(raw string) (*cronExpression, error) {
+	fields := strings.Fields(raw)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields, got %d", raw, len(fields))
+	}
+
+	expression := &cronExpression{
+		raw:        strings.Join(fields, " "),
+		anyDay:     strings.HasPrefix(fields[2], "*"),
+		anyWeekday: strings.HasPrefix(fields[4], "*"),
+	}
+
+	specs := []struct {
+		name  string
+		min   int
+		max   int
+		names map[string]int
+		value *uint64
+	}{
+		{"minute", 0, 59, nil, &expression.minutes},
+		{"hour", 0, 23, nil, &expression.hours},
+		{"day of month", 1, 31, nil, &expression.days},
+		{"month", 1, 12, cronMonthNames, &expression.months},
+		{"day of week", 0, 7, cronWeekdayNames, &expression.weekdays},
+	}
+
+	for i, spec := range specs {
+		bits, err := parseCronField(fields[i], spec.min, spec.max, spec.names)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s in cron expression %q. %v", spec.name, raw, err)
+		}
+
+		*spec.value = bits
+	}
+
+	// 7 is also sunday
+	if expression.weekdays&(1<<7) != 0 {
+		expression.weekdays |= 1
+	}
+
+	return expression, nil
+}
+
+// parseCronField parses a comma separated list of values, ranges and steps i.e. 1,5-10,*/15
+func parseCronField(raw string, min int, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(raw, ",") {
+		step := 1
+		hasStep := false
+		if i := strings.Index(part, "/"); i >= 0 {
+			value, err := strconv.Atoi(part[i+1:])
+			if err != nil || value <= 0 {
+				return 0, fmt.Errorf("step %q must be a positive number", part[i+1:])
+			}
+
+			step, hasStep, part = value, true, part[:i]
+		}
+
+		var low, high int
+		switch i := strings.Index(part, "-"); {
+		case part == "*":
+			low, high = min, max
+		case i >= 0:
+			var err error
+			if low, err = cronValue(part[:i], names); err != nil {
+				return 0, err
+			}
+
+			if high, err = cronValue(part[i+1:], names); err != nil {
+				return 0, err
+			}
+		default:
+			var err error
+			if low, err = cronValue(part, names); err != nil {
+				return 0, err
+			}
+
+			high = low
+			if hasStep {
+				high = max
+			}
+		}
+
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("%q must be between %d and %d", part, min, max)
+		}
+
+		for value := low; value <= high; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+
+	return bits, nil
+}
+
+func cronValue(raw string, names map[string]int) (int, error) {
+	if value, found := names[strings.ToUpper(raw)]; found {
+		return value, nil
+	}
+
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", raw)
+	}
+
+	return value, nil
+}
+
+func cronMatches(bits uint64, value int) bool {
+	return bits&(1<<uint(value)) != 0
+}
+
+func (c *cronExpression) dayMatches(t time.Time) bool {
+	day := cronMatches(c.days, t.Day())
+	weekday := cronMatches(c.weekdays, int(t.Weekday()))
+	if c.anyDay || c.anyWeekday {
+		return day && weekday
+	}
+
+	return day || weekday
+}
+
+// next returns the first time after the given time that the expression matches, in the location of the given time.
+// Returns the zero time if it never matches.
+func (c *cronExpression) next(after time.Time) time.Time {
+	location := after.Location()
+	t := after.Truncate(time.Second).Add(time.Duration(60-after.Second()) * time.Second)
+	limit := t.Add(cronSearchLimit)
+
+	for t.Before(limit) {
+		previous := t
+		switch {
+		case !cronMatches(c.months, int(t.Month())):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, location)
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, location)
+		case !cronMatches(c.hours, t.Hour()):
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+		case !cronMatches(c.minutes, t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+
+		// daylight saving changes at midnight can move a date backwards
+		if !t.After(previous) {
+			t = previous.Add(time.Hour)
+		}
+	}
+
+	return time.Time{}
+}
@@ -0,0 +1,67 @@
+package aws
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_ParseCron_Invalid(t *testing.T) {
+	for _, raw := range []string{
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * FOO *",
+		"*/0 * * * *",
+		"5-1 * * * *",
+	} {
+		if _, err := parseCron(raw); err == nil {
+			t.Errorf("Want error parsing %q", raw)
+		}
+	}
+}
+
+func Test_CronExpression_Next(t *testing.T) {
+	// a wednesday
+	from := time.Date(2018, time.June, 13, 9, 30, 15, 0, time.UTC)
+
+	cases := []struct {
+		raw  string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2018, time.June, 13, 9, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2018, time.June, 13, 9, 45, 0, 0, time.UTC)},
+		{"0 8 * * MON-FRI", time.Date(2018, time.June, 14, 8, 0, 0, 0, time.UTC)},
+		{"0 19 * * *", time.Date(2018, time.June, 13, 19, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2018, time.June, 17, 0, 0, 0, 0, time.UTC)},
+		{"30 6 1 JAN *", time.Date(2019, time.January, 1, 6, 30, 0, 0, time.UTC)},
+		{"0 12 1 * MON", time.Date(2018, time.June, 18, 12, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+
+	for _, c := range cases {
+		expression, err := parseCron(c.raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := expression.next(from); !got.Equal(c.want) {
+			t.Errorf("%q: want %s, got %s", c.raw, c.want, got)
+		}
+	}
+}
+
+func Test_CronExpression_Next_TimeZone(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	expression, _ := parseCron("0 8 * * *")
+
+	// the clocks go forward on 25 March 2018, 08:00 is then 07:00 UTC
+	got := expression.next(time.Date(2018, time.March, 25, 0, 0, 0, 0, time.UTC).In(london))
+	if want := time.Date(2018, time.March, 25, 7, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("Want %s, got %s", want, got.UTC())
+	}
+}
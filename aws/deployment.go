@@ -0,0 +1,143 @@
+package aws
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/ewilde/faas-fargate/types"
+	"github.com/openfaas/faas/gateway/requests"
+	log "github.com/sirupsen/logrus"
+)
+
+// Deployment steps, named in a DeployError when they fail
+const (
+	stepLogGroup         = "log-group"
+	stepRegistryAuth     = "registry-auth"
+	stepRole             = "iam-role"
+	stepTaskDefinition   = "task-definition"
+	stepServiceDiscovery = "service-discovery"
+	stepService          = "ecs-service"
+	stepAutoscaling      = "autoscaling"
+	stepCanaryWeight     = "canary-weight"
+)
+
+// undoFunc compensates for a deployment step, removing or restoring what the step changed
+type undoFunc func() error
+
+// deployment runs the steps of deploying a function, remembering how to undo each step that completed so a failure
+// part way through does not leave resources behind
+type deployment struct {
+	functionName string
+	completed    []string
+	undo         []undoFunc
+}
+
+func newDeployment(functionName string) *deployment {
+	return &deployment{functionName: functionName}
+}
+
+// run performs the step. When the step changed something it returns an undo function, or nil if there is nothing to
+// undo such as when a resource already existed. If the step fails everything completed so far is undone and a
+// DeployError naming the step is returned.
+func (d *deployment) run(step string, fn func() (undoFunc, error)) error {
+	undo, err := fn()
+	if err != nil {
+		return d.rollback(step, err)
+	}
+
+	d.completed = append(d.completed, step)
+	d.undo = append(d.undo, undo)
+	return nil
+}
+
+// rollback undoes the completed steps in reverse order
+func (d *deployment) rollback(step string, err error) error {
+	deployErr := &DeployError{Function: d.functionName, Step: step, Err: err}
+	log.Errorf("Deploying function %s failed at step %s, rolling back. %v", d.functionName, step, err)
+
+	for i := len(d.completed) - 1; i >= 0; i-- {
+		if d.undo[i] == nil {
+			continue
+		}
+
+		if undoErr := d.undo[i](); undoErr != nil {
+			log.Errorf("Error rolling back step %s of function %s. %v", d.completed[i], d.functionName, undoErr)
+			deployErr.RollbackErrors = append(deployErr.RollbackErrors, fmt.Sprintf("%s: %v", d.completed[i], undoErr))
+			continue
+		}
+
+		deployErr.RolledBack = append(deployErr.RolledBack, d.completed[i])
+	}
+
+	d.completed = nil
+	d.undo = nil
+	return deployErr
+}
+
+// DeployError is returned when a step of deploying a function fails, after the steps already completed have been
+// rolled back
+type DeployError struct {
+	Function string `json:"function"`
+	// Step that failed
+	Step string `json:"step"`
+	// Err is the reason the step failed
+	Err error `json:"-"`
+	// RolledBack steps that were undone
+	RolledBack []string `json:"rolledBack"`
+	// RollbackErrors for steps that could not be undone, these resources need cleaning up by hand
+	RollbackErrors []string `json:"rollbackErrors,omitempty"`
+}
+
+func (e *DeployError) Error() string {
+	message := fmt.Sprintf("deploying function %s failed at step %s. %v", e.Function, e.Step, e.Err)
+	if len(e.RollbackErrors) > 0 {
+		message = fmt.Sprintf("%s. rollback failed for: %s", message, strings.Join(e.RollbackErrors, ", "))
+	}
+
+	return message
+}
+
+// DeployFunction creates a new task revision for the function and creates or updates the ECS service running it. A
+// failure rolls back the resources created or changed by this deploy and returns a DeployError. When the circuit
+// breaker is enabled the rollout is watched in the background after this returns.
+func DeployFunction(
+	namespace *Namespace,
+	request requests.CreateFunctionRequest,
+	config *types.DeployHandlerConfig) (*ecs.Service, error) {
+
+	d := newDeployment(request.Service)
+	taskDefinition, err := createTaskRevision(d, namespace, request, request.Service, config)
+	if err != nil {
+		return nil, err
+	}
+
+	service, err := updateOrCreateECSService(d, namespace, taskDefinition.TaskDefinition, request, config)
+	if err != nil {
+		return nil, err
+	}
+
+	scaling, _ := newScalingSettings(request.Labels)
+	err = d.run(stepAutoscaling, func() (undoFunc, error) {
+		return configureAutoscaling(namespace, request.Service, scaling)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	settings, _ := newRolloutSettings(request.Labels)
+	watchRollout(namespace, request.Service, service, settings)
+
+	if err := pruneTaskRevisions(namespace, request.Service, config.RevisionHistoryLimit, service); err != nil {
+		log.Warnf("Error pruning task revisions of %s. %v", request.Service, err)
+	}
+
+	if len(request.RegistryAuth) == 0 {
+		// only remove credentials a previous revision used once the new revision is deployed
+		if err := deleteRegistryAuthSecret(namespace, request.Service); err != nil {
+			log.Warnf("Error removing unused registry auth for %s. %v", request.Service, err)
+		}
+	}
+
+	return service, nil
+}
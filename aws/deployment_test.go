@@ -0,0 +1,59 @@
+package aws
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func Test_Deployment_RollsBackCompletedStepsInReverseOrder(t *testing.T) {
+	var undone []string
+	undo := func(step string) undoFunc {
+		return func() error {
+			undone = append(undone, step)
+			return nil
+		}
+	}
+
+	d := newDeployment("figlet")
+	d.run(stepLogGroup, func() (undoFunc, error) { return undo(stepLogGroup), nil })
+	d.run(stepRole, func() (undoFunc, error) { return nil, nil })
+	d.run(stepTaskDefinition, func() (undoFunc, error) { return undo(stepTaskDefinition), nil })
+	err := d.run(stepService, func() (undoFunc, error) { return undo(stepService), errors.New("boom") })
+
+	deployErr, ok := err.(*DeployError)
+	if !ok {
+		t.Fatalf("Want a DeployError, got %v", err)
+	}
+
+	if deployErr.Step != stepService || deployErr.Function != "figlet" {
+		t.Errorf("Want step %s of figlet to fail, got %s of %s", stepService, deployErr.Step, deployErr.Function)
+	}
+
+	want := []string{stepTaskDefinition, stepLogGroup}
+	if !reflect.DeepEqual(undone, want) {
+		t.Errorf("Want %v undone, got %v", want, undone)
+	}
+
+	if !reflect.DeepEqual(deployErr.RolledBack, want) {
+		t.Errorf("Want %v rolled back, got %v", want, deployErr.RolledBack)
+	}
+}
+
+func Test_Deployment_ReportsStepsThatCouldNotBeUndone(t *testing.T) {
+	d := newDeployment("figlet")
+	d.run(stepLogGroup, func() (undoFunc, error) {
+		return func() error { return errors.New("access denied") }, nil
+	})
+
+	err := d.run(stepRole, func() (undoFunc, error) { return nil, errors.New("boom") })
+	deployErr := err.(*DeployError)
+	if len(deployErr.RolledBack) != 0 {
+		t.Errorf("Want nothing rolled back, got %v", deployErr.RolledBack)
+	}
+
+	want := []string{"log-group: access denied"}
+	if !reflect.DeepEqual(deployErr.RollbackErrors, want) {
+		t.Errorf("Want %v, got %v", want, deployErr.RollbackErrors)
+	}
+}
@@ -0,0 +1,307 @@
+package aws
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/ewilde/faas-fargate/types"
+	"github.com/openfaas/faas/gateway/requests"
+)
+
+// Parts of a function a deploy can change
+const (
+	changeImage        = "image"
+	changeEnv          = "env"
+	changeResources    = "resources"
+	changeSecrets      = "secrets"
+	changeLabels       = "labels"
+	changeHealthCheck  = "health-check"
+	changeLogging      = "logging"
+	changeRolePolicy   = "role-policy"
+	changeRegistryAuth = "registry-auth"
+)
+
+// FunctionDiff describes how a deploy request differs from the function that is running
+type FunctionDiff struct {
+	// Exists is false when the function has no service yet
+	Exists bool `json:"exists"`
+	// Changes lists the parts of the function the request changes
+	Changes []string `json:"changes"`
+	// Details are the current and desired values of each changed part, except registry auth whose values are secret
+	Details []FieldChange `json:"details,omitempty"`
+}
+
+// FieldChange is the current and desired value of a part of the function a deploy changes
+type FieldChange struct {
+	Part    string      `json:"part"`
+	Current interface{} `json:"current"`
+	Desired interface{} `json:"desired"`
+}
+
+// add records a changed part with its values
+func (d *FunctionDiff) add(change FieldChange) {
+	d.Changes = append(d.Changes, change.Part)
+	d.Details = append(d.Details, change)
+}
+
+// Unchanged returns true when the function exists and the request would not change it
+func (d *FunctionDiff) Unchanged() bool {
+	return d.Exists && len(d.Changes) == 0
+}
+
+// DiffFunction compares the task definition and role policy the request renders to with those of the running
+// function, without changing anything
+func DiffFunction(
+	namespace *Namespace,
+	request requests.CreateFunctionRequest,
+	config *types.DeployHandlerConfig) (*FunctionDiff, error) {
+
+	rendered, err := renderTaskDefinition(namespace, request, config)
+	if err != nil {
+		return nil, err
+	}
+
+	service, err := describeFunctionService(namespace, request.Service)
+	if err != nil {
+		return nil, err
+	}
+
+	return diffRenderedFunction(namespace, request, rendered, service)
+}
+
+// diffRenderedFunction compares the rendered task definition with the one the service is running. The registry
+// auth policy statement is added to the rendered policy when the registry auth secret exists.
+func diffRenderedFunction(
+	namespace *Namespace,
+	request requests.CreateFunctionRequest,
+	rendered *renderedTaskDefinition,
+	service *ecs.Service) (*FunctionDiff, error) {
+
+	diff := &FunctionDiff{Exists: service != nil}
+	registryAuthChange := false
+	if rendered.credentials != nil {
+		secretArn, changed, err := registryAuthChanged(namespace, request.Service, rendered.credentials)
+		if err != nil {
+			return nil, err
+		}
+
+		if changed {
+			registryAuthChange = true
+		}
+
+		if len(secretArn) > 0 {
+			rendered.registryAuthArn = secretArn
+			buildRegistryAuthPolicyStatement(rendered.policy, secretArn)
+		}
+	}
+
+	if service == nil {
+		if registryAuthChange {
+			diff.Changes = append(diff.Changes, changeRegistryAuth)
+		}
+
+		return diff, nil
+	}
+
+	current, err := ecsClient.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{TaskDefinition: service.TaskDefinition})
+	if err != nil {
+		return nil, fmt.Errorf("error describing task definition %s. %v", aws.StringValue(service.TaskDefinition), err)
+	}
+
+	for _, change := range taskDefinitionChanges(rendered.input, current.TaskDefinition) {
+		diff.add(change)
+	}
+
+	if registryAuthChange {
+		diff.Changes = append(diff.Changes, changeRegistryAuth)
+	}
+
+	roleName := namespace.ServiceNameFromFunctionName(request.Service)
+	policy, _, err := getRolePolicy(roleName, fmt.Sprintf("%s-policy", roleName))
+	if err != nil {
+		return nil, err
+	}
+
+	if policy != rendered.policy.String() {
+		diff.add(FieldChange{Part: changeRolePolicy, Current: rawJSON(policy), Desired: rawJSON(rendered.policy.String())})
+	}
+
+	return diff, nil
+}
+
+// rawJSON returns the document to be written as json rather than as a string, or nil when it is empty
+func rawJSON(document string) interface{} {
+	if len(document) == 0 {
+		return nil
+	}
+
+	return json.RawMessage(document)
+}
+
+// containerSpec is the part of a container definition a deploy controls, in a form that can be compared
+type containerSpec struct {
+	Image       string            `json:"image"`
+	Env         map[string]string `json:"env"`
+	CPU         int64             `json:"cpu"`
+	Memory      int64             `json:"memory"`
+	Labels      map[string]string `json:"labels"`
+	HealthCheck string            `json:"healthCheck,omitempty"`
+	VolumesFrom []string          `json:"volumesFrom,omitempty"`
+	Logging     map[string]string `json:"logging"`
+}
+
+// taskResources are the cpu and memory of the task and of its function container
+type taskResources struct {
+	TaskCPU        string `json:"taskCpu"`
+	TaskMemory     string `json:"taskMemory"`
+	FunctionCPU    int64  `json:"functionCpu"`
+	FunctionMemory int64  `json:"functionMemory"`
+}
+
+// taskSecrets are the sidecars providing the secrets of the function and the containers it reads volumes from
+type taskSecrets struct {
+	Sidecars    map[string]containerSpec `json:"sidecars"`
+	VolumesFrom []string                 `json:"volumesFrom"`
+}
+
+func newContainerSpec(container *ecs.ContainerDefinition) containerSpec {
+	spec := containerSpec{
+		Env:     map[string]string{},
+		Labels:  map[string]string{},
+		Logging: map[string]string{},
+	}
+
+	if container == nil {
+		return spec
+	}
+
+	spec.Image = aws.StringValue(container.Image)
+	spec.CPU = aws.Int64Value(container.Cpu)
+	spec.Memory = aws.Int64Value(container.Memory)
+
+	for _, item := range container.Environment {
+		spec.Env[aws.StringValue(item.Name)] = aws.StringValue(item.Value)
+	}
+
+	for name, value := range container.DockerLabels {
+		// registry auth changes are found by comparing the secret value, see registryAuthChanged
+		if name != registeredAtLabel && name != registryAuthVersionLabel {
+			spec.Labels[name] = aws.StringValue(value)
+		}
+	}
+
+	if container.HealthCheck != nil {
+		healthCheck, _ := json.Marshal(container.HealthCheck)
+		spec.HealthCheck = string(healthCheck)
+	}
+
+	for _, item := range container.VolumesFrom {
+		spec.VolumesFrom = append(spec.VolumesFrom, aws.StringValue(item.SourceContainer))
+	}
+	sort.Strings(spec.VolumesFrom)
+
+	if container.LogConfiguration != nil {
+		spec.Logging["driver"] = aws.StringValue(container.LogConfiguration.LogDriver)
+		for name, value := range container.LogConfiguration.Options {
+			spec.Logging[name] = aws.StringValue(value)
+		}
+	}
+
+	return spec
+}
+
+// taskDefinitionChanges returns the parts of the function that differ between the desired and current task
+// definition, with their values
+func taskDefinitionChanges(desired *ecs.RegisterTaskDefinitionInput, current *ecs.TaskDefinition) []FieldChange {
+	desiredDefinition := &ecs.TaskDefinition{Family: desired.Family, ContainerDefinitions: desired.ContainerDefinitions}
+	want := newContainerSpec(FunctionContainer(desiredDefinition))
+	got := newContainerSpec(FunctionContainer(current))
+
+	var changes []FieldChange
+	if want.Image != got.Image {
+		changes = append(changes, FieldChange{Part: changeImage, Current: got.Image, Desired: want.Image})
+	}
+
+	if !reflect.DeepEqual(want.Env, got.Env) {
+		changes = append(changes, FieldChange{Part: changeEnv, Current: got.Env, Desired: want.Env})
+	}
+
+	wantResources := taskResources{aws.StringValue(desired.Cpu), aws.StringValue(desired.Memory), want.CPU, want.Memory}
+	gotResources := taskResources{aws.StringValue(current.Cpu), aws.StringValue(current.Memory), got.CPU, got.Memory}
+	if wantResources != gotResources {
+		changes = append(changes, FieldChange{Part: changeResources, Current: gotResources, Desired: wantResources})
+	}
+
+	wantSecrets := taskSecrets{Sidecars: sidecarSpecs(desiredDefinition), VolumesFrom: want.VolumesFrom}
+	gotSecrets := taskSecrets{Sidecars: sidecarSpecs(current), VolumesFrom: got.VolumesFrom}
+	if !reflect.DeepEqual(wantSecrets, gotSecrets) {
+		changes = append(changes, FieldChange{Part: changeSecrets, Current: gotSecrets, Desired: wantSecrets})
+	}
+
+	if !reflect.DeepEqual(want.Labels, got.Labels) {
+		changes = append(changes, FieldChange{Part: changeLabels, Current: got.Labels, Desired: want.Labels})
+	}
+
+	if want.HealthCheck != got.HealthCheck {
+		changes = append(changes, FieldChange{Part: changeHealthCheck, Current: rawJSON(got.HealthCheck),
+			Desired: rawJSON(want.HealthCheck)})
+	}
+
+	if !reflect.DeepEqual(want.Logging, got.Logging) {
+		changes = append(changes, FieldChange{Part: changeLogging, Current: got.Logging, Desired: want.Logging})
+	}
+
+	return changes
+}
+
+// sidecarSpecs returns the containers other than the function container, keyed by name
+func sidecarSpecs(taskDefinition *ecs.TaskDefinition) map[string]containerSpec {
+	result := map[string]containerSpec{}
+	function := FunctionContainer(taskDefinition)
+	for _, item := range taskDefinition.ContainerDefinitions {
+		if item != function {
+			result[aws.StringValue(item.Name)] = newContainerSpec(item)
+		}
+	}
+
+	return result
+}
+
+// registryAuthChanged returns the arn of the registry auth secret of the function, if there is one, and whether its
+// value differs from the credentials
+func registryAuthChanged(
+	namespace *Namespace,
+	functionName string,
+	credentials *registryCredentials) (string, bool, error) {
+
+	name := registryAuthSecretName(namespace, functionName)
+	existing, err := secretsClient.DescribeSecret(&secretsmanager.DescribeSecretInput{SecretId: aws.String(name)})
+	if err != nil {
+		if isSecretNotFound(err) {
+			return "", true, nil
+		}
+
+		return "", false, fmt.Errorf("error describing registry auth secret %s. %v", name, err)
+	}
+
+	if existing.DeletedDate != nil {
+		return aws.StringValue(existing.ARN), true, nil
+	}
+
+	value, err := secretsClient.GetSecretValue(&secretsmanager.GetSecretValueInput{SecretId: existing.ARN})
+	if err != nil {
+		return "", false, fmt.Errorf("error reading registry auth secret %s. %v", name, err)
+	}
+
+	current := registryCredentials{}
+	if err := json.Unmarshal([]byte(aws.StringValue(value.SecretString)), &current); err != nil {
+		return aws.StringValue(existing.ARN), true, nil
+	}
+
+	return aws.StringValue(existing.ARN), current != *credentials, nil
+}
@@ -0,0 +1,79 @@
+package aws
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/ewilde/faas-fargate/types"
+	"github.com/openfaas/faas/gateway/requests"
+)
+
+func Test_TaskDefinitionChanges(t *testing.T) {
+	config := &types.DeployHandlerConfig{Region: "eu-west-1", EnableFunctionReadinessProbe: true}
+	request := requests.CreateFunctionRequest{
+		Service:    "figlet",
+		Image:      "functions/figlet:0.1",
+		EnvProcess: "figlet",
+	}
+
+	current := testRenderedTaskDefinition(t, request, config)
+	current.ContainerDefinitions[0].DockerLabels[registeredAtLabel] = aws.String("2018-07-01T12:00:00Z")
+
+	unchanged, err := renderTaskDefinition(DefaultNamespace(), request, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if changes := taskDefinitionChanges(unchanged.input, current); len(changes) != 0 {
+		t.Errorf("Want no changes, got %v", changes)
+	}
+
+	request.Image = "functions/figlet:0.2"
+	request.EnvVars = map[string]string{"output": "verbose"}
+	request.Limits = &requests.FunctionResources{Memory: "1Gi"}
+	request.Labels = &map[string]string{"team": "blue"}
+	changed, err := renderTaskDefinition(DefaultNamespace(), request, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{changeImage, changeEnv, changeResources, changeLabels}
+	changes := taskDefinitionChanges(changed.input, current)
+
+	var parts []string
+	for _, change := range changes {
+		parts = append(parts, change.Part)
+	}
+
+	if !reflect.DeepEqual(parts, want) {
+		t.Fatalf("Want %v, got %v", want, parts)
+	}
+
+	if changes[0].Current != "functions/figlet:0.1" || changes[0].Desired != "functions/figlet:0.2" {
+		t.Errorf("Want the image changed from 0.1 to 0.2, got %+v", changes[0])
+	}
+
+	if env := changes[1].Desired.(map[string]string); env["output"] != "verbose" {
+		t.Errorf("Want the desired env to set output, got %+v", changes[1])
+	}
+}
+
+func testRenderedTaskDefinition(
+	t *testing.T,
+	request requests.CreateFunctionRequest,
+	config *types.DeployHandlerConfig) *ecs.TaskDefinition {
+
+	rendered, err := renderTaskDefinition(DefaultNamespace(), request, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &ecs.TaskDefinition{
+		Family:               rendered.input.Family,
+		Cpu:                  rendered.input.Cpu,
+		Memory:               rendered.input.Memory,
+		ContainerDefinitions: rendered.input.ContainerDefinitions,
+	}
+}
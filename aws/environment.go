@@ -0,0 +1,62 @@
+package aws
+
+import (
+	"regexp"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/openfaas/faas/gateway/requests"
+)
+
+// envProcessName is the environment variable the OpenFaaS watchdog reads to find the function process
+const envProcessName = "fprocess"
+
+var envVarNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// functionEnvironment merges the provider defaults, the function env vars and the function process, in that order
+// of precedence, into a container environment sorted by name.
+func functionEnvironment(
+	request requests.CreateFunctionRequest,
+	defaults map[string]string) ([]*ecs.KeyValuePair, error) {
+
+	merged := map[string]string{}
+	for name, value := range defaults {
+		merged[name] = value
+	}
+
+	for name, value := range request.EnvVars {
+		if !envVarNamePattern.MatchString(name) {
+			return nil, newValidationError("invalid environment variable name %q for function %s", name, request.Service)
+		}
+
+		merged[name] = value
+	}
+
+	if len(request.EnvProcess) > 0 {
+		merged[envProcessName] = request.EnvProcess
+	}
+
+	var names []string
+	for name := range merged {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var result []*ecs.KeyValuePair
+	for _, name := range names {
+		result = append(result, &ecs.KeyValuePair{Name: aws.String(name), Value: aws.String(merged[name])})
+	}
+
+	return result, nil
+}
+
+// envProcessFromContainer returns the function process configured on the container, or an empty string
+func envProcessFromContainer(container *ecs.ContainerDefinition) string {
+	if container == nil {
+		return ""
+	}
+
+	value, _ := KeyValuePairGetValue(envProcessName, container.Environment)
+	return aws.StringValue(value)
+}
@@ -0,0 +1,72 @@
+package aws
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/openfaas/faas/gateway/requests"
+)
+
+func Test_FunctionEnvironment_Merges_Defaults_EnvVars_And_EnvProcess(t *testing.T) {
+	environment, err := functionEnvironment(requests.CreateFunctionRequest{
+		Service:    "wordcount",
+		EnvProcess: "wc",
+		EnvVars:    map[string]string{"write_debug": "true", "fprocess": "cat"},
+	}, map[string]string{"write_debug": "false", "gateway_url": "http://gateway:8080"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"fprocess": "wc", "gateway_url": "http://gateway:8080", "write_debug": "true"}
+	if len(environment) != len(want) {
+		t.Fatalf("Want %d variables, got %d", len(want), len(environment))
+	}
+
+	for name, value := range want {
+		got, found := KeyValuePairGetValue(name, environment)
+		if !found || aws.StringValue(got) != value {
+			t.Errorf("%s: want %s, got %s", name, value, aws.StringValue(got))
+		}
+	}
+
+	if aws.StringValue(environment[0].Name) != "fprocess" {
+		t.Errorf("Want environment sorted by name, got %s first", aws.StringValue(environment[0].Name))
+	}
+}
+
+func Test_FunctionEnvironment_InvalidName(t *testing.T) {
+	_, err := functionEnvironment(requests.CreateFunctionRequest{
+		Service: "wordcount",
+		EnvVars: map[string]string{"1-bad name": "value"},
+	}, nil)
+
+	if _, ok := err.(*ValidationError); !ok {
+		t.Errorf("Want ValidationError, got %v", err)
+	}
+}
+
+func Test_EnvProcessFromContainer(t *testing.T) {
+	container := &ecs.ContainerDefinition{
+		Environment: []*ecs.KeyValuePair{{Name: aws.String("fprocess"), Value: aws.String("wc")}},
+	}
+
+	if envProcess := envProcessFromContainer(container); envProcess != "wc" {
+		t.Errorf("Want wc, got %s", envProcess)
+	}
+}
+
+func Test_FunctionContainer_SkipsSidecar(t *testing.T) {
+	container := FunctionContainer(&ecs.TaskDefinition{
+		Family: aws.String("openfaas-hellogoworld"),
+		ContainerDefinitions: []*ecs.ContainerDefinition{
+			{Name: aws.String("openfaas-hellogoworld-kms")},
+			{Name: aws.String("openfaas-hellogoworld")},
+		},
+	})
+
+	if aws.StringValue(container.Name) != "openfaas-hellogoworld" {
+		t.Errorf("Want openfaas-hellogoworld, got %s", aws.StringValue(container.Name))
+	}
+}
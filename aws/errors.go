@@ -0,0 +1,29 @@
+package aws
+
+import "fmt"
+
+// ValidationError is returned when a function request contains values that can not be deployed
+type ValidationError struct {
+	message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.message
+}
+
+func newValidationError(format string, a ...interface{}) error {
+	return &ValidationError{message: fmt.Sprintf(format, a...)}
+}
+
+// ConflictError is returned when a request can not be carried out in the current state of the function
+type ConflictError struct {
+	message string
+}
+
+func (e *ConflictError) Error() string {
+	return e.message
+}
+
+func newConflictError(format string, a ...interface{}) error {
+	return &ConflictError{message: fmt.Sprintf(format, a...)}
+}
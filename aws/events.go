@@ -0,0 +1,127 @@
+package aws
+
+import (
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// maxStoppedTasks is the number of most recently stopped tasks reported for a function
+const maxStoppedTasks = 10
+
+// FunctionEvents explains what ECS has been doing with a function, used to debug failed deployments
+type FunctionEvents struct {
+	Events       []FunctionEvent   `json:"events"`
+	StoppedTasks []StoppedTask     `json:"stoppedTasks"`
+	Deployments  []DeploymentState `json:"deployments"`
+}
+
+// FunctionEvent is a message ECS recorded against the function service
+type FunctionEvent struct {
+	CreatedAt time.Time `json:"createdAt"`
+	Message   string    `json:"message"`
+}
+
+// StoppedTask is a task of the function which ECS stopped, and why
+type StoppedTask struct {
+	TaskID         string             `json:"taskId"`
+	TaskDefinition string             `json:"taskDefinition"`
+	StoppedAt      *time.Time         `json:"stoppedAt,omitempty"`
+	StoppedReason  string             `json:"stoppedReason"`
+	Containers     []StoppedContainer `json:"containers"`
+}
+
+// StoppedContainer is a container of a stopped task with its exit code and reason, if any
+type StoppedContainer struct {
+	Name     string `json:"name"`
+	ExitCode *int64 `json:"exitCode,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// DeploymentState is the progress of rolling out a task definition for the function
+type DeploymentState struct {
+	ID             string     `json:"id"`
+	Status         string     `json:"status"`
+	TaskDefinition string     `json:"taskDefinition"`
+	Desired        int64      `json:"desired"`
+	Running        int64      `json:"running"`
+	Pending        int64      `json:"pending"`
+	CreatedAt      *time.Time `json:"createdAt,omitempty"`
+	UpdatedAt      *time.Time `json:"updatedAt,omitempty"`
+}
+
+// GetFunctionEvents returns the service events, recently stopped tasks and deployments of the function, or nil
+// if no function is found
+func GetFunctionEvents(namespace *Namespace, functionName string) (*FunctionEvents, error) {
+	service, err := describeFunctionService(namespace, functionName)
+	if err != nil || service == nil {
+		return nil, err
+	}
+
+	tasks, err := getServiceTasks(namespace, service.ServiceName, ecs.DesiredStatusStopped)
+	if err != nil {
+		return nil, err
+	}
+
+	return newFunctionEvents(service, tasks), nil
+}
+
+func newFunctionEvents(service *ecs.Service, stoppedTasks []*ecs.Task) *FunctionEvents {
+	result := &FunctionEvents{
+		Events:       []FunctionEvent{},
+		StoppedTasks: []StoppedTask{},
+		Deployments:  []DeploymentState{},
+	}
+
+	for _, event := range service.Events {
+		result.Events = append(result.Events, FunctionEvent{
+			CreatedAt: aws.TimeValue(event.CreatedAt),
+			Message:   aws.StringValue(event.Message),
+		})
+	}
+
+	sort.Slice(stoppedTasks, func(i, j int) bool {
+		return aws.TimeValue(stoppedTasks[i].StoppedAt).After(aws.TimeValue(stoppedTasks[j].StoppedAt))
+	})
+
+	for _, task := range stoppedTasks {
+		if len(result.StoppedTasks) == maxStoppedTasks {
+			break
+		}
+
+		stopped := StoppedTask{
+			TaskID:         TaskIDFromArn(task.TaskArn),
+			TaskDefinition: aws.StringValue(task.TaskDefinitionArn),
+			StoppedAt:      task.StoppedAt,
+			StoppedReason:  aws.StringValue(task.StoppedReason),
+			Containers:     []StoppedContainer{},
+		}
+
+		for _, container := range task.Containers {
+			stopped.Containers = append(stopped.Containers, StoppedContainer{
+				Name:     aws.StringValue(container.Name),
+				ExitCode: container.ExitCode,
+				Reason:   aws.StringValue(container.Reason),
+			})
+		}
+
+		result.StoppedTasks = append(result.StoppedTasks, stopped)
+	}
+
+	for _, deployment := range service.Deployments {
+		result.Deployments = append(result.Deployments, DeploymentState{
+			ID:             aws.StringValue(deployment.Id),
+			Status:         aws.StringValue(deployment.Status),
+			TaskDefinition: aws.StringValue(deployment.TaskDefinition),
+			Desired:        aws.Int64Value(deployment.DesiredCount),
+			Running:        aws.Int64Value(deployment.RunningCount),
+			Pending:        aws.Int64Value(deployment.PendingCount),
+			CreatedAt:      deployment.CreatedAt,
+			UpdatedAt:      deployment.UpdatedAt,
+		})
+	}
+
+	return result
+}
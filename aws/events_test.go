@@ -0,0 +1,52 @@
+package aws
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func Test_NewFunctionEvents(t *testing.T) {
+	now := time.Now()
+	service := &ecs.Service{
+		Events: []*ecs.ServiceEvent{
+			{CreatedAt: aws.Time(now), Message: aws.String("(service openfaas-figlet) has started 1 tasks")},
+		},
+		Deployments: []*ecs.Deployment{
+			{Id: aws.String("ecs-svc/1"), Status: aws.String("PRIMARY"), DesiredCount: aws.Int64(1)},
+		},
+	}
+
+	var tasks []*ecs.Task
+	for i := 0; i < maxStoppedTasks+2; i++ {
+		tasks = append(tasks, &ecs.Task{
+			TaskArn:       aws.String("arn:aws:ecs:eu-west-1:122668425727:task/" + string(rune('a'+i))),
+			StoppedAt:     aws.Time(now.Add(time.Duration(i) * time.Minute)),
+			StoppedReason: aws.String("Essential container in task exited"),
+			Containers: []*ecs.Container{
+				{Name: aws.String("openfaas-figlet"), ExitCode: aws.Int64(137), Reason: aws.String("OutOfMemoryError")},
+			},
+		})
+	}
+
+	events := newFunctionEvents(service, tasks)
+
+	if len(events.Events) != 1 || len(events.Deployments) != 1 {
+		t.Errorf("Want 1 event and 1 deployment, got %d and %d", len(events.Events), len(events.Deployments))
+	}
+
+	if len(events.StoppedTasks) != maxStoppedTasks {
+		t.Fatalf("Want %d stopped tasks, got %d", maxStoppedTasks, len(events.StoppedTasks))
+	}
+
+	latest := events.StoppedTasks[0]
+	if latest.TaskID != string(rune('a'+maxStoppedTasks+1)) {
+		t.Errorf("Want most recently stopped task first, got %s", latest.TaskID)
+	}
+
+	if aws.Int64Value(latest.Containers[0].ExitCode) != 137 || latest.Containers[0].Reason != "OutOfMemoryError" {
+		t.Errorf("Unexpected container %+v", latest.Containers[0])
+	}
+}
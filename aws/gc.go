@@ -0,0 +1,515 @@
+package aws
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/servicediscovery"
+	log "github.com/sirupsen/logrus"
+)
+
+// Kinds of resource the garbage collector removes
+const (
+	resourceLogGroup         = "log-group"
+	resourceRole             = "iam-role"
+	resourceTaskDefinition   = "task-definition"
+	resourceServiceDiscovery = "service-discovery"
+	resourceRegistryAuth     = "registry-auth"
+)
+
+// GarbageCollector removes resources owned by this installation which belong to functions that no longer have an
+// ECS service. A resource is only removed once it has been orphaned for the grace period, so resources created by a
+// deploy which is still in progress are left alone. Unless removal is enabled the collector only logs what it finds.
+type GarbageCollector struct {
+	gracePeriod   time.Duration
+	removeOrphans bool
+	lock          *sync.Mutex
+	firstSeen     map[string]time.Time
+}
+
+// OrphanedResource is a resource of a function which no longer exists
+type OrphanedResource struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Function  string `json:"function"`
+	// FirstSeen is when the collector first found the resource orphaned
+	FirstSeen time.Time `json:"firstSeen"`
+	// RemoveAfter is when the grace period of the resource ends
+	RemoveAfter time.Time `json:"removeAfter"`
+	Removed     bool      `json:"removed"`
+	Error       string    `json:"error,omitempty"`
+
+	remove func() error
+}
+
+// GarbageReport lists the orphaned resources found by a collection
+type GarbageReport struct {
+	DryRun      bool               `json:"dryRun"`
+	GracePeriod string             `json:"gracePeriod"`
+	Resources   []OrphanedResource `json:"resources"`
+}
+
+// NewGarbageCollector creates a garbage collector finding resources orphaned for longer than the grace period, which
+// are removed when removeOrphans is set and otherwise only reported
+func NewGarbageCollector(gracePeriod time.Duration, removeOrphans bool) *GarbageCollector {
+	return &GarbageCollector{
+		gracePeriod:   gracePeriod,
+		removeOrphans: removeOrphans,
+		lock:          &sync.Mutex{},
+		firstSeen:     map[string]time.Time{},
+	}
+}
+
+// Start collects garbage every interval in the background
+func (g *GarbageCollector) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			report, err := g.Collect(!g.removeOrphans)
+			if err != nil {
+				log.Errorf("Error collecting garbage. %v", err)
+				continue
+			}
+
+			if report.DryRun {
+				for _, item := range report.Resources {
+					log.Infof("Found orphaned %s %s of function %s in namespace %s, removal is disabled",
+						item.Kind, item.Name, item.Function, item.Namespace)
+				}
+			}
+		}
+	}()
+}
+
+// Collect finds orphaned resources in every namespace and removes those orphaned for longer than the grace period.
+// A dry run only reports what would be removed.
+func (g *GarbageCollector) Collect(dryRun bool) (*GarbageReport, error) {
+	var orphans []OrphanedResource
+	for _, name := range Namespaces() {
+		namespace, err := GetNamespace(name)
+		if err != nil {
+			return nil, err
+		}
+
+		found, err := findOrphanedResources(namespace)
+		if err != nil {
+			return nil, fmt.Errorf("error finding orphaned resources in namespace %s. %v", name, err)
+		}
+
+		orphans = append(orphans, found...)
+	}
+
+	report := &GarbageReport{DryRun: dryRun, GracePeriod: g.gracePeriod.String()}
+	for _, item := range g.track(orphans, time.Now()) {
+		if !dryRun && !item.RemoveAfter.After(time.Now()) {
+			log.Infof("Removing orphaned %s %s of function %s in namespace %s, orphaned since %s",
+				item.Kind, item.Name, item.Function, item.Namespace, item.FirstSeen.Format(time.RFC3339))
+
+			if err := item.remove(); err != nil {
+				log.Errorf("Error removing orphaned %s %s. %v", item.Kind, item.Name, err)
+				item.Error = err.Error()
+			} else {
+				item.Removed = true
+				g.forget(item)
+			}
+		}
+
+		report.Resources = append(report.Resources, item)
+	}
+
+	return report, nil
+}
+
+// track records when each orphan was first seen, forgetting resources which are no longer orphaned. Dry runs and
+// collections which only report record them too, so the grace period has already run when removal is enabled.
+func (g *GarbageCollector) track(orphans []OrphanedResource, now time.Time) []OrphanedResource {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	firstSeen := map[string]time.Time{}
+	for i := range orphans {
+		key := orphans[i].key()
+		seen, found := g.firstSeen[key]
+		if !found {
+			seen = now
+		}
+
+		firstSeen[key] = seen
+		orphans[i].FirstSeen = seen
+		orphans[i].RemoveAfter = seen.Add(g.gracePeriod)
+	}
+
+	g.firstSeen = firstSeen
+
+	sort.Slice(orphans, func(i, j int) bool { return orphans[i].key() < orphans[j].key() })
+	return orphans
+}
+
+func (g *GarbageCollector) forget(orphan OrphanedResource) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	delete(g.firstSeen, orphan.key())
+}
+
+func (o OrphanedResource) key() string {
+	return fmt.Sprintf("%s/%s/%s", o.Namespace, o.Kind, o.Name)
+}
+
+// findOrphanedResources returns the resources of functions in the namespace that have no ECS service
+func findOrphanedResources(namespace *Namespace) ([]OrphanedResource, error) {
+	live, err := liveFunctions(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	finders := []func(*Namespace, map[string]bool) ([]OrphanedResource, error){
+		orphanedLogGroups,
+		orphanedRoles,
+		orphanedTaskDefinitions,
+		orphanedServiceRegistrations,
+		orphanedRegistryAuthSecrets,
+	}
+
+	var result []OrphanedResource
+	for _, finder := range finders {
+		found, err := finder(namespace, live)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, found...)
+	}
+
+	return result, nil
+}
+
+// liveFunctions returns the names of functions with an ECS service in the namespace, whoever owns the service
+func liveFunctions(namespace *Namespace) (map[string]bool, error) {
+	services, err := getServices(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	live := map[string]bool{}
+	for _, item := range services {
+		if namespace.IsFaasService(item) {
+			live[namespace.ServiceNameForDisplay(ServiceNameFromArn(item))] = true
+		}
+	}
+
+	return live, nil
+}
+
+// functionFromResourceName returns the function a resource named after its service belongs to, or false when the
+// name does not have the service prefix of the namespace
+func functionFromResourceName(namespace *Namespace, name string, suffix string) (string, bool) {
+	prefix := namespace.servicePrefix()
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+		return "", false
+	}
+
+	functionName := strings.TrimSuffix(strings.TrimPrefix(name, prefix), suffix)
+	return functionName, len(functionName) > 0
+}
+
+func orphanedLogGroups(namespace *Namespace, live map[string]bool) ([]OrphanedResource, error) {
+	var result []OrphanedResource
+	var listErr error
+	err := cloudwatchClient.DescribeLogGroupsPages(
+		&cloudwatchlogs.DescribeLogGroupsInput{LogGroupNamePrefix: aws.String(namespace.servicePrefix())},
+		func(output *cloudwatchlogs.DescribeLogGroupsOutput, lastPage bool) bool {
+			for _, item := range output.LogGroups {
+				name := aws.StringValue(item.LogGroupName)
+				functionName, ok := functionFromResourceName(namespace, name, "")
+				if !ok || live[functionName] {
+					continue
+				}
+
+				tags, err := cloudwatchClient.ListTagsLogGroup(&cloudwatchlogs.ListTagsLogGroupInput{LogGroupName: item.LogGroupName})
+				if err != nil {
+					listErr = fmt.Errorf("error listing tags of log group %s. %v", name, err)
+					return false
+				}
+
+				if !namespace.isOwnedResource(tags.Tags, functionName) {
+					continue
+				}
+
+				result = append(result, OrphanedResource{
+					Kind:      resourceLogGroup,
+					Name:      name,
+					Namespace: namespace.Name,
+					Function:  functionName,
+					remove:    func() error { return deleteLogGroup(namespace, functionName) },
+				})
+			}
+
+			return true
+		})
+
+	if err != nil {
+		return nil, fmt.Errorf("error listing log groups. %v", err)
+	}
+
+	return result, listErr
+}
+
+// orphanedRoles finds roles created under the role path of the installation and namespace. Roles created before
+// role paths were used can not be told apart from roles of other installations, so are left alone.
+func orphanedRoles(namespace *Namespace, live map[string]bool) ([]OrphanedResource, error) {
+	var result []OrphanedResource
+	err := iamClient.ListRolesPages(
+		&iam.ListRolesInput{PathPrefix: aws.String(rolePath(namespace))},
+		func(output *iam.ListRolesOutput, lastPage bool) bool {
+			for _, item := range output.Roles {
+				name := aws.StringValue(item.RoleName)
+				functionName, ok := functionFromResourceName(namespace, name, "")
+				if !ok || live[functionName] || aws.StringValue(item.Path) != rolePath(namespace) {
+					continue
+				}
+
+				result = append(result, OrphanedResource{
+					Kind:      resourceRole,
+					Name:      name,
+					Namespace: namespace.Name,
+					Function:  functionName,
+					remove:    func() error { return deleteRoleAndPolicies(name) },
+				})
+			}
+
+			return true
+		})
+
+	if err != nil {
+		return nil, fmt.Errorf("error listing roles. %v", err)
+	}
+
+	return result, nil
+}
+
+// deleteRoleAndPolicies deletes the role with any inline policies it has
+func deleteRoleAndPolicies(roleName string) error {
+	policies, err := iamClient.ListRolePolicies(&iam.ListRolePoliciesInput{RoleName: aws.String(roleName)})
+	if err != nil {
+		return fmt.Errorf("could not list policies of role %s. %v", roleName, err)
+	}
+
+	for _, policyName := range policies.PolicyNames {
+		_, err := iamClient.DeleteRolePolicy(&iam.DeleteRolePolicyInput{PolicyName: policyName, RoleName: aws.String(roleName)})
+		if err != nil {
+			return fmt.Errorf("could not delete policy %s of role %s. %v", aws.StringValue(policyName), roleName, err)
+		}
+	}
+
+	return deleteRoleWithoutPolicy(roleName)
+}
+
+func orphanedTaskDefinitions(namespace *Namespace, live map[string]bool) ([]OrphanedResource, error) {
+	var families []string
+	err := ecsClient.ListTaskDefinitionFamiliesPages(
+		&ecs.ListTaskDefinitionFamiliesInput{
+			FamilyPrefix: aws.String(namespace.servicePrefix()),
+			Status:       aws.String(ecs.TaskDefinitionFamilyStatusActive),
+		},
+		func(output *ecs.ListTaskDefinitionFamiliesOutput, lastPage bool) bool {
+			families = append(families, aws.StringValueSlice(output.Families)...)
+			return true
+		})
+
+	if err != nil {
+		return nil, fmt.Errorf("error listing task definition families. %v", err)
+	}
+
+	var result []OrphanedResource
+	for _, family := range families {
+		functionName, ok := functionFromResourceName(namespace, family, "")
+		if !ok || live[functionName] {
+			continue
+		}
+
+		latest, err := ecsClient.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{TaskDefinition: aws.String(family)})
+		if err != nil {
+			return nil, fmt.Errorf("error describing task definition %s. %v", family, err)
+		}
+
+		if !namespace.isOwnedFunction(FunctionContainer(latest.TaskDefinition), functionName) {
+			continue
+		}
+
+		family := family
+		result = append(result, OrphanedResource{
+			Kind:      resourceTaskDefinition,
+			Name:      family,
+			Namespace: namespace.Name,
+			Function:  functionName,
+			remove:    func() error { return deregisterTaskDefinitionFamily(family) },
+		})
+	}
+
+	return result, nil
+}
+
+// deregisterTaskDefinitionFamily deregisters every active revision of the task definition family
+func deregisterTaskDefinitionFamily(family string) error {
+	var arns []*string
+	err := ecsClient.ListTaskDefinitionsPages(
+		&ecs.ListTaskDefinitionsInput{
+			FamilyPrefix: aws.String(family),
+			Status:       aws.String(ecs.TaskDefinitionStatusActive),
+		},
+		func(output *ecs.ListTaskDefinitionsOutput, lastPage bool) bool {
+			arns = append(arns, output.TaskDefinitionArns...)
+			return true
+		})
+
+	if err != nil {
+		return fmt.Errorf("error listing task definitions of %s. %v", family, err)
+	}
+
+	for _, arn := range arns {
+		if err := deregisterTaskDefinition(arn); err != nil {
+			return fmt.Errorf("error deregistering task definition %s. %v", aws.StringValue(arn), err)
+		}
+	}
+
+	return nil
+}
+
+// orphanedServiceRegistrations finds route 53 auto-naming services of functions with no ECS service. Only services
+// whose description carries the owner marker of this installation are collected, and those still used by any ECS
+// service in the cluster are left alone.
+func orphanedServiceRegistrations(namespace *Namespace, live map[string]bool) ([]OrphanedResource, error) {
+	namespaceID, found, err := lookupDNSNamespace(namespace)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	used, err := usedServiceRegistrations(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	discoveryPrefix := namespace.DiscoveryNameFromFunctionName("")
+
+	var result []OrphanedResource
+	err = discoveryClient.ListServicesPages(
+		&servicediscovery.ListServicesInput{
+			Filters: []*servicediscovery.ServiceFilter{
+				{
+					Name:   aws.String("NAMESPACE_ID"),
+					Values: []*string{namespaceID},
+				},
+			},
+		},
+		func(output *servicediscovery.ListServicesOutput, lastPage bool) bool {
+			for _, item := range output.Services {
+				name := aws.StringValue(item.Name)
+				functionName := strings.TrimPrefix(name, discoveryPrefix)
+				if !strings.HasPrefix(name, discoveryPrefix) || len(functionName) == 0 ||
+					live[functionName] || used[aws.StringValue(item.Arn)] ||
+					!isOwnedRegistration(namespace, aws.StringValue(item.Description), functionName) {
+					continue
+				}
+
+				id := aws.StringValue(item.Id)
+				result = append(result, OrphanedResource{
+					Kind:      resourceServiceDiscovery,
+					Name:      name,
+					Namespace: namespace.Name,
+					Function:  functionName,
+					remove:    func() error { return deleteServiceRegistrationByID(name, id) },
+				})
+			}
+
+			return true
+		})
+
+	if err != nil {
+		return nil, fmt.Errorf("error listing route 53 auto-naming services. %v", err)
+	}
+
+	return result, nil
+}
+
+// usedServiceRegistrations returns the arns of the route 53 auto-naming services used by ECS services in the
+// cluster of the namespace
+func usedServiceRegistrations(namespace *Namespace) (map[string]bool, error) {
+	services, err := getServices(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	used := map[string]bool{}
+	for len(services) > 0 {
+		describe := services
+		if len(services) > 10 {
+			describe = services[0:10]
+		}
+		services = services[len(describe):]
+
+		details, err := ecsClient.DescribeServices(&ecs.DescribeServicesInput{Cluster: namespace.ClusterID(), Services: describe})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, service := range details.Services {
+			for _, registry := range service.ServiceRegistries {
+				used[aws.StringValue(registry.RegistryArn)] = true
+			}
+		}
+	}
+
+	return used, nil
+}
+
+func orphanedRegistryAuthSecrets(namespace *Namespace, live map[string]bool) ([]OrphanedResource, error) {
+	var result []OrphanedResource
+	err := secretsClient.ListSecretsPages(
+		&secretsmanager.ListSecretsInput{},
+		func(output *secretsmanager.ListSecretsOutput, lastPage bool) bool {
+			for _, item := range output.SecretList {
+				name := aws.StringValue(item.Name)
+				functionName, ok := functionFromResourceName(namespace, name, registryAuthSuffix)
+				if !ok || live[functionName] || item.DeletedDate != nil {
+					continue
+				}
+
+				tags := map[string]*string{}
+				for _, tag := range item.Tags {
+					tags[aws.StringValue(tag.Key)] = tag.Value
+				}
+
+				if !namespace.isOwnedResource(tags, functionName) {
+					continue
+				}
+
+				result = append(result, OrphanedResource{
+					Kind:      resourceRegistryAuth,
+					Name:      name,
+					Namespace: namespace.Name,
+					Function:  functionName,
+					remove:    func() error { return deleteRegistryAuthSecret(namespace, functionName) },
+				})
+			}
+
+			return true
+		})
+
+	if err != nil {
+		return nil, fmt.Errorf("error listing secrets. %v", err)
+	}
+
+	return result, nil
+}
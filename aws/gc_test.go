@@ -0,0 +1,81 @@
+package aws
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func Test_GarbageCollector_TrackKeepsFirstSeen(t *testing.T) {
+	g := NewGarbageCollector(time.Hour, true)
+	start := time.Date(2018, 7, 1, 12, 0, 0, 0, time.UTC)
+	orphan := OrphanedResource{Kind: resourceLogGroup, Name: "openfaas-figlet", Namespace: "default"}
+
+	first := g.track([]OrphanedResource{orphan}, start)
+	if !first[0].RemoveAfter.Equal(start.Add(time.Hour)) {
+		t.Errorf("Want remove after %s, got %s", start.Add(time.Hour), first[0].RemoveAfter)
+	}
+
+	later := g.track([]OrphanedResource{orphan}, start.Add(2*time.Hour))
+	if !later[0].FirstSeen.Equal(start) {
+		t.Errorf("Want first seen %s, got %s", start, later[0].FirstSeen)
+	}
+}
+
+func Test_GarbageCollector_TrackForgetsResourcesNoLongerOrphaned(t *testing.T) {
+	g := NewGarbageCollector(time.Hour, true)
+	start := time.Date(2018, 7, 1, 12, 0, 0, 0, time.UTC)
+	orphan := OrphanedResource{Kind: resourceRole, Name: "openfaas-figlet", Namespace: "default"}
+
+	g.track([]OrphanedResource{orphan}, start)
+	g.track(nil, start.Add(time.Minute))
+
+	again := g.track([]OrphanedResource{orphan}, start.Add(2*time.Hour))
+	if !again[0].FirstSeen.Equal(start.Add(2 * time.Hour)) {
+		t.Errorf("Want the grace period to restart, got first seen %s", again[0].FirstSeen)
+	}
+}
+
+func Test_GarbageCollector_ReportOnlyTracks(t *testing.T) {
+	g := NewGarbageCollector(time.Hour, false)
+	start := time.Date(2018, 7, 1, 12, 0, 0, 0, time.UTC)
+	orphan := OrphanedResource{Kind: resourceRole, Name: "openfaas-figlet", Namespace: "default"}
+
+	g.track([]OrphanedResource{orphan}, start)
+	later := g.track([]OrphanedResource{orphan}, start.Add(2*time.Hour))
+	if !later[0].FirstSeen.Equal(start) || !later[0].RemoveAfter.Equal(start.Add(time.Hour)) {
+		t.Errorf("Want the orphan recorded while removal is disabled, got first seen %s", later[0].FirstSeen)
+	}
+}
+
+func Test_FunctionFromResourceName(t *testing.T) {
+	namespace := DefaultNamespace()
+	if name, ok := functionFromResourceName(namespace, "openfaas-figlet-registry-auth", registryAuthSuffix); !ok || name != "figlet" {
+		t.Errorf("Want figlet, got %s %v", name, ok)
+	}
+
+	if _, ok := functionFromResourceName(namespace, "gateway-figlet", ""); ok {
+		t.Errorf("Want gateway-figlet not to belong to a function")
+	}
+
+	if _, ok := functionFromResourceName(namespace, "openfaas-", ""); ok {
+		t.Errorf("Want a resource named only by the prefix not to belong to a function")
+	}
+}
+
+func Test_IsOwnedRegistration(t *testing.T) {
+	namespace := DefaultNamespace()
+	description := aws.StringValue(serviceRegistrationInput(namespace, "figlet", aws.String("ns-1")).Description)
+	if !isOwnedRegistration(namespace, description, "figlet") {
+		t.Errorf("Want the registration created for figlet to be owned, description %s", description)
+	}
+
+	if isOwnedRegistration(namespace, description, "nodeinfo") {
+		t.Errorf("Want the registration of figlet not to be owned by nodeinfo")
+	}
+
+	if isOwnedRegistration(namespace, "Openfaas auto-naming service for figlet", "figlet") {
+		t.Errorf("Want a registration without the owner marker not to be owned")
+	}
+}
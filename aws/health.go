@@ -0,0 +1,106 @@
+package aws
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+const (
+	// healthCheckURL is the OpenFaaS watchdog health endpoint as seen from inside the function container
+	healthCheckURL = "http://localhost:8080/_/health"
+
+	healthCheckLabel       = "com.openfaas.health.check"
+	healthIntervalLabel    = "com.openfaas.health.interval"
+	healthTimeoutLabel     = "com.openfaas.health.timeout"
+	healthRetriesLabel     = "com.openfaas.health.retries"
+	healthStartPeriodLabel = "com.openfaas.health.start-period"
+)
+
+// healthCheckEnabled returns true if the function gets a container health check. The com.openfaas.health.check label
+// turns it on or off for the function, otherwise the provider wide enable_function_readiness_probe decides.
+func healthCheckEnabled(labels *map[string]string, enabledByDefault bool) (bool, error) {
+	if labels == nil {
+		return enabledByDefault, nil
+	}
+
+	raw, exists := (*labels)[healthCheckLabel]
+	if !exists {
+		return enabledByDefault, nil
+	}
+
+	enabled, err := strconv.ParseBool(strings.TrimSpace(raw))
+	if err != nil {
+		return false, newValidationError("label %s must be true or false, got %s", healthCheckLabel, raw)
+	}
+
+	return enabled, nil
+}
+
+// functionHealthCheck returns the container health check probing the watchdog. It defaults to a 10s interval, 5s
+// timeout, 3 retries and a 5s start period, shorter than the ECS defaults so new tasks become healthy quickly. Each can
+// be overridden using com.openfaas.health.* labels. The check runs wget through sh inside the function container.
+func functionHealthCheck(labels *map[string]string) (*ecs.HealthCheck, error) {
+	healthCheck := &ecs.HealthCheck{
+		Command: []*string{
+			aws.String("CMD-SHELL"),
+			aws.String("wget --quiet --tries=1 --spider " + healthCheckURL + " || exit 1"),
+		},
+		Interval:    aws.Int64(10),
+		Timeout:     aws.Int64(5),
+		Retries:     aws.Int64(3),
+		StartPeriod: aws.Int64(5),
+	}
+
+	if labels == nil {
+		return healthCheck, nil
+	}
+
+	settings := []struct {
+		label    string
+		min, max int64
+		value    **int64
+		seconds  bool
+	}{
+		{healthIntervalLabel, 5, 300, &healthCheck.Interval, true},
+		{healthTimeoutLabel, 2, 60, &healthCheck.Timeout, true},
+		{healthRetriesLabel, 1, 10, &healthCheck.Retries, false},
+		{healthStartPeriodLabel, 0, 300, &healthCheck.StartPeriod, true},
+	}
+
+	for _, setting := range settings {
+		raw, exists := (*labels)[setting.label]
+		if !exists {
+			continue
+		}
+
+		value, err := parseHealthValue(raw, setting.seconds)
+		if err != nil || value < setting.min || value > setting.max {
+			return nil, newValidationError("label %s must be between %d and %d, got %s",
+				setting.label, setting.min, setting.max, raw)
+		}
+
+		*setting.value = aws.Int64(value)
+	}
+
+	return healthCheck, nil
+}
+
+// parseHealthValue parses a whole number or, for time based settings, a duration i.e. 30s or 1m
+func parseHealthValue(raw string, seconds bool) (int64, error) {
+	raw = strings.TrimSpace(raw)
+	value, err := strconv.ParseInt(raw, 10, 64)
+	if err == nil || !seconds {
+		return value, err
+	}
+
+	duration, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(duration / time.Second), nil
+}
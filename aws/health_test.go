@@ -0,0 +1,89 @@
+package aws
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func Test_FunctionHealthCheck_Defaults(t *testing.T) {
+	healthCheck, err := functionHealthCheck(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if aws.Int64Value(healthCheck.Interval) != 10 || aws.Int64Value(healthCheck.Retries) != 3 {
+		t.Errorf("Unexpected defaults %s", healthCheck.String())
+	}
+
+	if aws.StringValue(healthCheck.Command[0]) != "CMD-SHELL" {
+		t.Errorf("Want CMD-SHELL, got %s", aws.StringValue(healthCheck.Command[0]))
+	}
+}
+
+func Test_FunctionHealthCheck_Labels(t *testing.T) {
+	healthCheck, err := functionHealthCheck(&map[string]string{
+		healthIntervalLabel:    "1m",
+		healthTimeoutLabel:     "10",
+		healthRetriesLabel:     "5",
+		healthStartPeriodLabel: "30s",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if aws.Int64Value(healthCheck.Interval) != 60 {
+		t.Errorf("Want interval 60, got %d", aws.Int64Value(healthCheck.Interval))
+	}
+
+	if aws.Int64Value(healthCheck.Timeout) != 10 {
+		t.Errorf("Want timeout 10, got %d", aws.Int64Value(healthCheck.Timeout))
+	}
+
+	if aws.Int64Value(healthCheck.Retries) != 5 {
+		t.Errorf("Want retries 5, got %d", aws.Int64Value(healthCheck.Retries))
+	}
+
+	if aws.Int64Value(healthCheck.StartPeriod) != 30 {
+		t.Errorf("Want start period 30, got %d", aws.Int64Value(healthCheck.StartPeriod))
+	}
+}
+
+func Test_FunctionHealthCheck_OutOfRange(t *testing.T) {
+	for label, value := range map[string]string{
+		healthIntervalLabel: "1",
+		healthTimeoutLabel:  "2m",
+		healthRetriesLabel:  "10s",
+	} {
+		_, err := functionHealthCheck(&map[string]string{label: value})
+		if _, ok := err.(*ValidationError); !ok {
+			t.Errorf("%s=%s: want ValidationError, got %v", label, value, err)
+		}
+	}
+}
+
+func Test_HealthCheckEnabled(t *testing.T) {
+	cases := []struct {
+		labels           *map[string]string
+		enabledByDefault bool
+		want             bool
+	}{
+		{nil, false, false},
+		{nil, true, true},
+		{&map[string]string{healthCheckLabel: "true"}, false, true},
+		{&map[string]string{healthCheckLabel: "false"}, true, false},
+		{&map[string]string{healthIntervalLabel: "30s"}, false, false},
+	}
+
+	for _, c := range cases {
+		enabled, err := healthCheckEnabled(c.labels, c.enabledByDefault)
+		if err != nil || enabled != c.want {
+			t.Errorf("%v with default %t: want %t, got %t %v", c.labels, c.enabledByDefault, c.want, enabled, err)
+		}
+	}
+
+	_, err := healthCheckEnabled(&map[string]string{healthCheckLabel: "sometimes"}, false)
+	if _, ok := err.(*ValidationError); !ok {
+		t.Errorf("Want ValidationError, got %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package aws
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+// historyStreamName is the log stream, in the function log group, recording changes made to the function that do
+// not register a new task definition revision
+const historyStreamName = "faas-fargate-history"
+
+// historyActionRollback records the service being pointed back at an earlier revision
+const historyActionRollback = "rollback"
+
+// historyEvent is a change recorded in the function history
+type historyEvent struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+}
+
+// recordHistoryEvent appends the event to the history stream of the function
+func recordHistoryEvent(namespace *Namespace, functionName string, event historyEvent) error {
+	logGroup := logGroupName(namespace, functionName)
+	message, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	token, err := historySequenceToken(logGroup)
+	if err != nil {
+		return err
+	}
+
+	_, err = cloudwatchClient.PutLogEvents(&cloudwatchlogs.PutLogEventsInput{
+		LogGroupName:  aws.String(logGroup),
+		LogStreamName: aws.String(historyStreamName),
+		SequenceToken: token,
+		LogEvents: []*cloudwatchlogs.InputLogEvent{
+			{
+				Message:   aws.String(string(message)),
+				Timestamp: aws.Int64(event.Time.UnixNano() / int64(time.Millisecond)),
+			},
+		},
+	})
+
+	if err != nil {
+		return fmt.Errorf("error recording history of %s. %v", functionName, err)
+	}
+
+	return nil
+}
+
+// historySequenceToken returns the token needed to append to the history stream, creating the stream if needed
+func historySequenceToken(logGroup string) (*string, error) {
+	streams, err := cloudwatchClient.DescribeLogStreams(&cloudwatchlogs.DescribeLogStreamsInput{
+		LogGroupName:        aws.String(logGroup),
+		LogStreamNamePrefix: aws.String(historyStreamName),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error describing history stream of %s. %v", logGroup, err)
+	}
+
+	for _, item := range streams.LogStreams {
+		if aws.StringValue(item.LogStreamName) == historyStreamName {
+			return item.UploadSequenceToken, nil
+		}
+	}
+
+	_, err = cloudwatchClient.CreateLogStream(&cloudwatchlogs.CreateLogStreamInput{
+		LogGroupName:  aws.String(logGroup),
+		LogStreamName: aws.String(historyStreamName),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating history stream of %s. %v", logGroup, err)
+	}
+
+	return nil, nil
+}
+
+// readHistoryEvents returns the events recorded in the history stream of the function, oldest first
+func readHistoryEvents(namespace *Namespace, functionName string) ([]historyEvent, error) {
+	logGroup := logGroupName(namespace, functionName)
+
+	var result []historyEvent
+	var next *string
+	for {
+		output, err := cloudwatchClient.GetLogEvents(&cloudwatchlogs.GetLogEventsInput{
+			LogGroupName:  aws.String(logGroup),
+			LogStreamName: aws.String(historyStreamName),
+			StartFromHead: aws.Bool(true),
+			NextToken:     next,
+		})
+		if err != nil {
+			if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == cloudwatchlogs.ErrCodeResourceNotFoundException {
+				return result, nil
+			}
+
+			return nil, fmt.Errorf("error reading history of %s. %v", functionName, err)
+		}
+
+		for _, item := range output.Events {
+			event := historyEvent{}
+			if err := json.Unmarshal([]byte(aws.StringValue(item.Message)), &event); err == nil {
+				result = append(result, event)
+			}
+		}
+
+		// the forward token is returned unchanged once the end of the stream is reached
+		if len(output.Events) == 0 || aws.StringValue(output.NextForwardToken) == aws.StringValue(next) {
+			return result, nil
+		}
+
+		next = output.NextForwardToken
+	}
+}
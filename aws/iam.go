@@ -2,12 +2,14 @@ package aws
 
 import (
 	"fmt"
+	"net/url"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws/awserr"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/iam"
+	log "github.com/sirupsen/logrus"
 )
 
 // PolicyBuilder is used to build IAM policies
@@ -53,46 +55,120 @@ const statementTemplate = `{
         "Resource": [%s]
     }`
 
-func createRoleWithPolicy(functionName string, policyDocument string) (string, error) {
-	roleName := ServiceNameFromFunctionName(functionName)
+// createRoleWithPolicy creates the function role, if it does not exist, and sets its policy. The undo function
+// returned deletes a role that was created, or restores the previous policy of an existing role. An existing role
+// created by another installation or namespace is a ConflictError.
+func createRoleWithPolicy(namespace *Namespace, functionName string, policyDocument string) (string, undoFunc, error) {
+	roleName := namespace.ServiceNameFromFunctionName(functionName)
+	policyName := fmt.Sprintf("%s-policy", roleName)
 
 	existing, err := iamClient.GetRole(&iam.GetRoleInput{
 		RoleName: aws.String(roleName),
 	})
 	if checkForErrorAllowEntityNotExists(err) != nil {
-		return "", err
+		return "", nil, err
+	}
+
+	if existing.Role != nil && !isOwnedRole(namespace, existing.Role) {
+		return "", nil, newConflictError("role %s belongs to a function of another installation or namespace, "+
+			"its path is %s", roleName, aws.StringValue(existing.Role.Path))
 	}
 
 	var roleArn *string
+	var undo undoFunc
 	if existing.Role == nil {
 		output, err := iamClient.CreateRole(&iam.CreateRoleInput{
 			RoleName:                 aws.String(roleName),
+			Path:                     aws.String(rolePath(namespace)),
+			Description:              aws.String(fmt.Sprintf("Openfaas function role for %s", functionName)),
 			AssumeRolePolicyDocument: aws.String(assumeRolePolicy),
 		})
 
 		if err != nil {
-			return "", fmt.Errorf("could not create role %s. %v", roleName, err)
+			return "", nil, fmt.Errorf("could not create role %s. %v", roleName, err)
 		}
 
 		roleArn = output.Role.Arn
+		undo = func() error { return deleteRole(namespace, functionName) }
 	} else {
 		roleArn = existing.Role.Arn
+		undo, err = restoreRolePolicy(roleName, policyName)
+		if err != nil {
+			return "", nil, err
+		}
 	}
 
 	_, err = iamClient.PutRolePolicy(&iam.PutRolePolicyInput{
-		PolicyName:     aws.String(fmt.Sprintf("%s-policy", roleName)),
+		PolicyName:     aws.String(policyName),
 		RoleName:       aws.String(roleName),
 		PolicyDocument: aws.String(policyDocument),
 	})
 	if err != nil {
-		return "", fmt.Errorf("could not create role policy %s\n%s\n. %v", roleName, policyDocument, err)
+		if existing.Role == nil {
+			if deleteErr := deleteRoleWithoutPolicy(roleName); deleteErr != nil {
+				log.Errorln(deleteErr)
+			}
+		}
+
+		return "", nil, fmt.Errorf("could not create role policy %s\n%s\n. %v", roleName, policyDocument, err)
+	}
+
+	return aws.StringValue(roleArn), undo, nil
+}
+
+// restoreRolePolicy returns a function which puts back the current role policy, or deletes the policy if the role
+// does not have one yet
+func restoreRolePolicy(roleName string, policyName string) (undoFunc, error) {
+	document, found, err := getRolePolicy(roleName, policyName)
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return func() error {
+			_, err := iamClient.DeleteRolePolicy(&iam.DeleteRolePolicyInput{
+				PolicyName: aws.String(policyName),
+				RoleName:   aws.String(roleName),
+			})
+			return err
+		}, nil
+	}
+
+	return func() error {
+		_, err := iamClient.PutRolePolicy(&iam.PutRolePolicyInput{
+			PolicyName:     aws.String(policyName),
+			RoleName:       aws.String(roleName),
+			PolicyDocument: aws.String(document),
+		})
+		return err
+	}, nil
+}
+
+// getRolePolicy returns the inline policy document of the role, or false if the role or policy does not exist
+func getRolePolicy(roleName string, policyName string) (string, bool, error) {
+	output, err := iamClient.GetRolePolicy(&iam.GetRolePolicyInput{
+		PolicyName: aws.String(policyName),
+		RoleName:   aws.String(roleName),
+	})
+	if checkForErrorAllowEntityNotExists(err) != nil {
+		return "", false, fmt.Errorf("could not get role policy %s. %v", roleName, err)
+	}
+
+	if output.PolicyDocument == nil {
+		return "", false, nil
+	}
+
+	// the policy document is returned url encoded
+	document, err := url.QueryUnescape(aws.StringValue(output.PolicyDocument))
+	if err != nil {
+		return "", false, fmt.Errorf("could not decode role policy %s. %v", roleName, err)
 	}
 
-	return aws.StringValue(roleArn), nil
+	return document, true, nil
 }
 
-func deleteRole(name string) error {
-	roleName := ServiceNameFromFunctionName(name)
+func deleteRole(namespace *Namespace, name string) error {
+	roleName := namespace.ServiceNameFromFunctionName(name)
 
 	_, err := iamClient.DeleteRolePolicy(&iam.DeleteRolePolicyInput{
 		PolicyName: aws.String(fmt.Sprintf("%s-policy", roleName)),
@@ -102,7 +178,11 @@ func deleteRole(name string) error {
 		return fmt.Errorf("could not delete role policy for role %s. %v", roleName, err)
 	}
 
-	_, err = iamClient.DeleteRole(&iam.DeleteRoleInput{
+	return deleteRoleWithoutPolicy(roleName)
+}
+
+func deleteRoleWithoutPolicy(roleName string) error {
+	_, err := iamClient.DeleteRole(&iam.DeleteRoleInput{
 		RoleName: aws.String(roleName),
 	})
 	if err != nil {
@@ -112,6 +192,23 @@ func deleteRole(name string) error {
 	return nil
 }
 
+// rolePath returns the path roles of this installation and namespace are created under, the IAM api we use does not
+// support tagging roles
+func rolePath(namespace *Namespace) string {
+	return fmt.Sprintf("/openfaas/%s/%s/", installationID, namespace.Name)
+}
+
+// isOwnedRole returns true if the role was created under the path of this installation and namespace. Roles created
+// before paths were used belong to the default installation and namespace.
+func isOwnedRole(namespace *Namespace, role *iam.Role) bool {
+	path := aws.StringValue(role.Path)
+	if path == "/" {
+		return installationID == defaultInstallationID && namespace.isDefault
+	}
+
+	return path == rolePath(namespace)
+}
+
 func checkForErrorAllowEntityNotExists(err error) error {
 	if err != nil {
 		if awsErr, ok := err.(awserr.Error); ok {
@@ -58,7 +58,7 @@ func Test_PolicyBuilder_Multiple_Action_Multiple_Statements(t *testing.T) {
 func Test_CreateRole(t *testing.T) {
 	PreTest(t)
 
-	createRoleWithPolicy("hellogoworld", `{
+	createRoleWithPolicy(DefaultNamespace(), "hellogoworld", `{
     "Version": "2012-10-17",
     "Statement": [
         {
@@ -0,0 +1,247 @@
+package aws
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/ewilde/faas-fargate/system"
+)
+
+const (
+	// defaultInstallationID identifies a faas-fargate installation that has not been given an installation_id.
+	// Resources created before installations were identified belong to it.
+	defaultInstallationID = "default"
+
+	// ownershipLabelPrefix is reserved for labels and tags faas-fargate uses to identify the resources it owns
+	ownershipLabelPrefix = "com.openfaas.fargate."
+	// installationLabel identifies the installation which owns a resource
+	installationLabel = ownershipLabelPrefix + "installation"
+	// namespaceLabel identifies the function namespace a resource belongs to
+	namespaceLabel = ownershipLabelPrefix + "namespace"
+	// functionLabel identifies the function a resource belongs to
+	functionLabel = ownershipLabelPrefix + "function"
+
+	// secretPrefix is used by secrets that functions read using the kms-template sidecar
+	secretPrefix = "openfaas-"
+)
+
+var installationID string
+
+var namespacesLock = &sync.RWMutex{}
+var defaultNamespace *Namespace
+var namespaces map[string]*Namespace
+
+func init() {
+	installationID = system.GetEnv("installation_id", defaultInstallationID)
+	ConfigureNamespaces("default", nil)
+}
+
+// Namespace groups functions, isolating them from functions in other namespaces using resource names, a private dns
+// namespace and optionally a separate ECS cluster.
+type Namespace struct {
+	// Name of the namespace
+	Name string
+	// Cluster is the ECS cluster functions in the namespace run in, when empty the cluster_name cluster is used
+	Cluster string
+
+	isDefault bool
+}
+
+// ConfigureNamespaces sets the default namespace and the other namespaces functions may be deployed to, each mapped
+// to the ECS cluster its functions run in.
+func ConfigureNamespaces(defaultName string, others map[string]string) {
+	namespacesLock.Lock()
+	defer namespacesLock.Unlock()
+
+	defaultNamespace = &Namespace{Name: defaultName, isDefault: true}
+	namespaces = map[string]*Namespace{defaultName: defaultNamespace}
+	for name, cluster := range others {
+		if name == defaultName {
+			defaultNamespace.Cluster = cluster
+			continue
+		}
+
+		namespaces[name] = &Namespace{Name: name, Cluster: cluster}
+	}
+}
+
+// GetNamespace returns the named namespace, or the default namespace when the name is empty
+func GetNamespace(name string) (*Namespace, error) {
+	namespacesLock.RLock()
+	defer namespacesLock.RUnlock()
+
+	if len(name) == 0 {
+		return defaultNamespace, nil
+	}
+
+	namespace, found := namespaces[name]
+	if !found {
+		return nil, newValidationError("namespace %s is not configured", name)
+	}
+
+	return namespace, nil
+}
+
+// DefaultNamespace returns the namespace functions are deployed to when no namespace is requested
+func DefaultNamespace() *Namespace {
+	namespacesLock.RLock()
+	defer namespacesLock.RUnlock()
+
+	return defaultNamespace
+}
+
+// Namespaces returns the names of all configured namespaces
+func Namespaces() []string {
+	namespacesLock.RLock()
+	defer namespacesLock.RUnlock()
+
+	var result []string
+	for name := range namespaces {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+// InstallationID returns the id of this faas-fargate installation
+func InstallationID() string {
+	return installationID
+}
+
+// ClusterID returns the ECS cluster functions in the namespace run in
+func (n *Namespace) ClusterID() *string {
+	if len(n.Cluster) > 0 {
+		return aws.String(n.Cluster)
+	}
+
+	return ClusterID()
+}
+
+// servicePrefix returns the prefix of resource names owned by this installation in the namespace. The default
+// namespace of the default installation keeps the original openfaas- prefix.
+func (n *Namespace) servicePrefix() string {
+	prefix := servicePrefixForInstallation(installationID)
+	if n.isDefault {
+		return prefix
+	}
+
+	return fmt.Sprintf("%s%s-", prefix, n.Name)
+}
+
+// checkFunctionName returns a ValidationError if the resource names of the function would be those of a function in
+// another configured namespace, e.g. function dev-figlet of the default namespace and figlet of the dev namespace are
+// both named openfaas-dev-figlet. The name is reserved for the namespace with the longer prefix.
+func (n *Namespace) checkFunctionName(functionName string) error {
+	namespacesLock.RLock()
+	defer namespacesLock.RUnlock()
+
+	prefix := n.servicePrefix()
+	name := n.ServiceNameFromFunctionName(functionName)
+	for _, other := range namespaces {
+		otherPrefix := other.servicePrefix()
+		if len(otherPrefix) > len(prefix) && strings.HasPrefix(otherPrefix, prefix) && strings.HasPrefix(name, otherPrefix) {
+			return newValidationError("function name %s must not start with %s, it would share its resource names "+
+				"with function %s of namespace %s", functionName, strings.TrimPrefix(otherPrefix, prefix),
+				strings.TrimPrefix(name, otherPrefix), other.Name)
+		}
+	}
+
+	return nil
+}
+
+// ServiceNameForDisplay returns the service name shown to the user
+func (n *Namespace) ServiceNameForDisplay(name *string) string {
+	return strings.TrimPrefix(*name, n.servicePrefix())
+}
+
+// ServiceNameFromFunctionName returns the aws faargate service name based on the OpenFaaS function name
+func (n *Namespace) ServiceNameFromFunctionName(functionName string) string {
+	return n.servicePrefix() + functionName
+}
+
+// DNSNamespace returns the private dns namespace the functions in the namespace are registered in
+func (n *Namespace) DNSNamespace() string {
+	if n.isDefault {
+		return dnsNamespace
+	}
+
+	return fmt.Sprintf("%s.%s", n.Name, dnsNamespace)
+}
+
+// DiscoveryNameFromFunctionName returns the route 53 auto-naming service name for the function
+func (n *Namespace) DiscoveryNameFromFunctionName(functionName string) string {
+	if installationID == defaultInstallationID {
+		return functionName
+	}
+
+	return fmt.Sprintf("%s-%s", installationID, functionName)
+}
+
+// HostNameFromFunctionName returns the private dns name functions replicas are registered under
+func (n *Namespace) HostNameFromFunctionName(functionName string) string {
+	return fmt.Sprintf("%s.%s", n.DiscoveryNameFromFunctionName(functionName), n.DNSNamespace())
+}
+
+// IsFaasService returns true if the service name has the prefix used by this installation in the namespace.
+// Ownership must still be confirmed using the labels of the service task definition, see isOwnedFunction.
+func (n *Namespace) IsFaasService(arn *string) bool {
+	return strings.HasPrefix(aws.StringValue(ServiceNameFromArn(arn)), n.servicePrefix())
+}
+
+// ownershipLabels returns the labels identifying resources of the function as owned by this installation
+func (n *Namespace) ownershipLabels(functionName string) map[string]*string {
+	return map[string]*string{
+		installationLabel: aws.String(installationID),
+		namespaceLabel:    aws.String(n.Name),
+		functionLabel:     aws.String(functionName),
+	}
+}
+
+// isOwnedFunction returns true if the function container belongs to the named function of this installation in
+// the namespace. Containers registered before ownership labels existed belong to the default installation and
+// namespace.
+func (n *Namespace) isOwnedFunction(container *ecs.ContainerDefinition, functionName string) bool {
+	if container == nil {
+		return false
+	}
+
+	return n.isOwnedResource(container.DockerLabels, functionName)
+}
+
+// isOwnedResource returns true if the labels, or tags, of a resource identify it as belonging to the named function
+// of this installation in the namespace
+func (n *Namespace) isOwnedResource(labels map[string]*string, functionName string) bool {
+	installation, found := labels[installationLabel]
+	if !found {
+		return installationID == defaultInstallationID && n.isDefault
+	}
+
+	namespace, found := labels[namespaceLabel]
+	if !found {
+		namespace = aws.String(DefaultNamespace().Name)
+	}
+
+	return aws.StringValue(installation) == installationID &&
+		aws.StringValue(namespace) == n.Name &&
+		aws.StringValue(labels[functionLabel]) == functionName
+}
+
+// servicePrefixForInstallation returns the prefix of resource names owned by the installation. The default
+// installation keeps the original openfaas- prefix, others become openfaas-<installation id>-.
+func servicePrefixForInstallation(id string) string {
+	if id == defaultInstallationID {
+		return "openfaas-"
+	}
+
+	return fmt.Sprintf("openfaas-%s-", id)
+}
+
+// ServiceNameFromArn calculated the service name from the service arn
+func ServiceNameFromArn(arn *string) *string {
+	return aws.String(strings.Split(*arn, "/")[1])
+}
@@ -0,0 +1,373 @@
+package aws
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/servicediscovery"
+	"github.com/ewilde/faas-fargate/types"
+	"github.com/openfaas/faas/gateway/requests"
+)
+
+func Test_ServicePrefixForInstallation(t *testing.T) {
+	if prefix := servicePrefixForInstallation(defaultInstallationID); prefix != "openfaas-" {
+		t.Errorf("Want openfaas-, got %s", prefix)
+	}
+
+	if prefix := servicePrefixForInstallation("staging"); prefix != "openfaas-staging-" {
+		t.Errorf("Want openfaas-staging-, got %s", prefix)
+	}
+}
+
+func Test_IsFaasService_MatchesPrefixOfServiceName(t *testing.T) {
+	namespace := DefaultNamespace()
+	if !namespace.IsFaasService(aws.String("arn:aws:ecs:eu-west-1:122668425727:service/openfaas-figlet")) {
+		t.Errorf("Want openfaas-figlet to be a faas service")
+	}
+
+	if namespace.IsFaasService(aws.String("arn:aws:ecs:eu-west-1:122668425727:service/gateway-openfaas-figlet")) {
+		t.Errorf("Want gateway-openfaas-figlet not to be a faas service")
+	}
+}
+
+func Test_IsOwnedFunction(t *testing.T) {
+	namespace := DefaultNamespace()
+	owned := &ecs.ContainerDefinition{DockerLabels: namespace.ownershipLabels("hello")}
+	if !namespace.isOwnedFunction(owned, "hello") {
+		t.Errorf("Want hello to be owned")
+	}
+
+	if namespace.isOwnedFunction(owned, "hello-world") {
+		t.Errorf("Want hello-world not to match the labels of hello")
+	}
+
+	other := &ecs.ContainerDefinition{DockerLabels: map[string]*string{
+		installationLabel: aws.String("another-installation"),
+		namespaceLabel:    aws.String(namespace.Name),
+		functionLabel:     aws.String("hello"),
+	}}
+	if namespace.isOwnedFunction(other, "hello") {
+		t.Errorf("Want a function of another installation not to be owned")
+	}
+
+	legacy := &ecs.ContainerDefinition{}
+	if !namespace.isOwnedFunction(legacy, "hello") {
+		t.Errorf("Want functions without ownership labels to belong to the default installation")
+	}
+}
+
+func Test_Namespaces(t *testing.T) {
+	defer ConfigureNamespaces("default", nil)
+	ConfigureNamespaces("default", map[string]string{"dev": "", "staging": "openfaas-staging"})
+
+	names := Namespaces()
+	if len(names) != 3 || names[0] != "default" || names[1] != "dev" || names[2] != "staging" {
+		t.Errorf("Want default, dev and staging, got %v", names)
+	}
+
+	defaultNs, err := GetNamespace("")
+	if err != nil || defaultNs != DefaultNamespace() {
+		t.Errorf("Want the default namespace for an empty name, got %v %v", defaultNs, err)
+	}
+
+	if _, err := GetNamespace("prod"); err == nil {
+		t.Errorf("Want an error for a namespace which is not configured")
+	}
+
+	dev, _ := GetNamespace("dev")
+	if name := dev.ServiceNameFromFunctionName("figlet"); name != "openfaas-dev-figlet" {
+		t.Errorf("Want openfaas-dev-figlet, got %s", name)
+	}
+
+	if host := dev.HostNameFromFunctionName("figlet"); host != "figlet.dev.openfaas.local" {
+		t.Errorf("Want figlet.dev.openfaas.local, got %s", host)
+	}
+
+	if cluster := aws.StringValue(dev.ClusterID()); cluster != aws.StringValue(ClusterID()) {
+		t.Errorf("Want the default cluster, got %s", cluster)
+	}
+
+	staging, _ := GetNamespace("staging")
+	if cluster := aws.StringValue(staging.ClusterID()); cluster != "openfaas-staging" {
+		t.Errorf("Want openfaas-staging, got %s", cluster)
+	}
+
+	devFunction := &ecs.ContainerDefinition{DockerLabels: dev.ownershipLabels("figlet")}
+	if DefaultNamespace().isOwnedFunction(devFunction, "figlet") {
+		t.Errorf("Want a function of the dev namespace not to be owned by the default namespace")
+	}
+}
+
+func Test_DiscoveryNameFromFunctionName(t *testing.T) {
+	namespace := DefaultNamespace()
+	if name := namespace.DiscoveryNameFromFunctionName("figlet"); name != "figlet" {
+		t.Errorf("Want figlet, got %s", name)
+	}
+
+	if host := namespace.HostNameFromFunctionName("figlet"); host != "figlet.openfaas.local" {
+		t.Errorf("Want figlet.openfaas.local, got %s", host)
+	}
+}
+
+// stagingFooLabels are the ownership labels of function staging-foo of the default installation, whose resources are
+// named like those of function foo of installation staging
+var stagingFooLabels = map[string]*string{
+	installationLabel: aws.String(defaultInstallationID),
+	namespaceLabel:    aws.String("default"),
+	functionLabel:     aws.String("staging-foo"),
+}
+
+func Test_CreateTaskRevision_NameOfAnotherInstallation(t *testing.T) {
+	withStubbedAPI(t, func(api *stubAPI) {
+		api.on("DescribeTaskDefinition", func(input interface{}) (interface{}, error) {
+			family := aws.StringValue(input.(*ecs.DescribeTaskDefinitionInput).TaskDefinition)
+			if family != "openfaas-staging-foo" {
+				t.Errorf("Want family openfaas-staging-foo, got %s", family)
+			}
+
+			return &ecs.DescribeTaskDefinitionOutput{TaskDefinition: &ecs.TaskDefinition{
+				Family: aws.String(family),
+				ContainerDefinitions: []*ecs.ContainerDefinition{
+					{Name: aws.String(family), DockerLabels: stagingFooLabels},
+				},
+			}}, nil
+		})
+
+		withInstallation("staging", func() {
+			request := requests.CreateFunctionRequest{Service: "foo", Image: "functions/alpine"}
+			_, err := CreateTaskRevision(DefaultNamespace(), request, &types.DeployHandlerConfig{Region: "eu-west-1"})
+			if _, ok := err.(*ConflictError); !ok {
+				t.Errorf("Want a conflict error deploying foo of installation staging, got %v", err)
+			}
+		})
+
+		for _, operation := range []string{"CreateLogGroup", "CreateRole", "PutRolePolicy", "RegisterTaskDefinition"} {
+			if api.called(operation) {
+				t.Errorf("Want nothing changed, got a call to %s", operation)
+			}
+		}
+	})
+}
+
+func Test_CreateRoleWithPolicy_RoleOfAnotherInstallation(t *testing.T) {
+	withStubbedAPI(t, func(api *stubAPI) {
+		api.on("GetRole", func(input interface{}) (interface{}, error) {
+			return &iam.GetRoleOutput{Role: &iam.Role{
+				RoleName: input.(*iam.GetRoleInput).RoleName,
+				Arn:      aws.String("arn:aws:iam::123456789012:role/openfaas/default/default/openfaas-staging-foo"),
+				Path:     aws.String("/openfaas/default/default/"),
+			}}, nil
+		})
+
+		withInstallation("staging", func() {
+			_, _, err := createRoleWithPolicy(DefaultNamespace(), "foo", NewPolicyBuilder().String())
+			if _, ok := err.(*ConflictError); !ok {
+				t.Errorf("Want a conflict error for the role of staging-foo, got %v", err)
+			}
+		})
+
+		if api.called("PutRolePolicy") {
+			t.Error("Want the policy of the role of another installation left alone")
+		}
+	})
+}
+
+func Test_IsOwnedRole(t *testing.T) {
+	namespace := DefaultNamespace()
+	if !isOwnedRole(namespace, &iam.Role{Path: aws.String("/")}) {
+		t.Error("Want roles created before paths were used to belong to the default installation")
+	}
+
+	if !isOwnedRole(namespace, &iam.Role{Path: aws.String(rolePath(namespace))}) {
+		t.Error("Want a role under the path of the installation and namespace to be owned")
+	}
+
+	withInstallation("staging", func() {
+		if isOwnedRole(namespace, &iam.Role{Path: aws.String("/")}) {
+			t.Error("Want roles created before paths were used not to belong to installation staging")
+		}
+	})
+}
+
+func Test_CreateLogGroup_LogGroupOfAnotherInstallation(t *testing.T) {
+	withStubbedAPI(t, func(api *stubAPI) {
+		api.on("CreateLogGroup", func(input interface{}) (interface{}, error) {
+			return nil, awserr.New(cloudwatchlogs.ErrCodeResourceAlreadyExistsException, "exists", nil)
+		})
+		api.on("ListTagsLogGroup", func(input interface{}) (interface{}, error) {
+			return &cloudwatchlogs.ListTagsLogGroupOutput{Tags: stagingFooLabels}, nil
+		})
+
+		withInstallation("staging", func() {
+			if _, err := createLogGroup(DefaultNamespace(), "foo"); err == nil {
+				t.Error("Want a conflict error for the log group of staging-foo")
+			} else if _, ok := err.(*ConflictError); !ok {
+				t.Errorf("Want a conflict error for the log group of staging-foo, got %v", err)
+			}
+		})
+
+		if _, err := createLogGroup(DefaultNamespace(), "staging-foo"); err != nil {
+			t.Errorf("Want the existing log group of staging-foo reused, got %v", err)
+		}
+	})
+}
+
+func Test_EnsureRegistryAuthSecret_SecretOfAnotherInstallation(t *testing.T) {
+	withStubbedAPI(t, func(api *stubAPI) {
+		api.on("DescribeSecret", func(input interface{}) (interface{}, error) {
+			var tags []*secretsmanager.Tag
+			for key, value := range stagingFooLabels {
+				tags = append(tags, &secretsmanager.Tag{Key: aws.String(key), Value: value})
+			}
+
+			return &secretsmanager.DescribeSecretOutput{Name: input.(*secretsmanager.DescribeSecretInput).SecretId, Tags: tags}, nil
+		})
+
+		withInstallation("staging", func() {
+			credentials := &registryCredentials{Username: "user", Password: "password"}
+			_, _, _, err := ensureRegistryAuthSecret(DefaultNamespace(), "foo", credentials)
+			if _, ok := err.(*ConflictError); !ok {
+				t.Errorf("Want a conflict error for the registry auth secret of staging-foo, got %v", err)
+			}
+		})
+	})
+}
+
+func Test_EnsureServiceRegistrationExists_RegistrationOfAnotherInstallation(t *testing.T) {
+	withStubbedAPI(t, func(api *stubAPI) {
+		api.on("ListNamespaces", func(input interface{}) (interface{}, error) {
+			return &servicediscovery.ListNamespacesOutput{Namespaces: []*servicediscovery.NamespaceSummary{
+				{Id: aws.String("ns-local"), Name: aws.String(dnsNamespace)},
+			}}, nil
+		})
+		api.on("ListServices", func(input interface{}) (interface{}, error) {
+			return &servicediscovery.ListServicesOutput{Services: []*servicediscovery.ServiceSummary{
+				{
+					Id:          aws.String("srv-staging-foo"),
+					Arn:         aws.String("arn:aws:servicediscovery:us-east-1:123456789012:service/srv-staging-foo"),
+					Name:        aws.String("staging-foo"),
+					Description: aws.String("Openfaas auto-naming service for staging-foo, owner=default/default/staging-foo"),
+				},
+			}}, nil
+		})
+
+		withInstallation("staging", func() {
+			_, _, err := ensureServiceRegistrationExists(DefaultNamespace(), "foo", "vpc-1")
+			if _, ok := err.(*ConflictError); !ok {
+				t.Errorf("Want a conflict error for the registration of staging-foo, got %v", err)
+			}
+		})
+
+		if api.called("CreateService") {
+			t.Error("Want no registration created")
+		}
+
+		arn, _, err := ensureServiceRegistrationExists(DefaultNamespace(), "staging-foo", "vpc-1")
+		if err != nil || arn != "arn:aws:servicediscovery:us-east-1:123456789012:service/srv-staging-foo" {
+			t.Errorf("Want the registration of staging-foo reused, got %s %v", arn, err)
+		}
+	})
+}
+
+func Test_CheckFunctionName_NameOfAnotherNamespace(t *testing.T) {
+	defer ConfigureNamespaces("default", nil)
+	ConfigureNamespaces("default", map[string]string{"dev": "", "dev-eu": ""})
+
+	dev, _ := GetNamespace("dev")
+	devEU, _ := GetNamespace("dev-eu")
+	for _, item := range []struct {
+		namespace *Namespace
+		name      string
+	}{
+		{DefaultNamespace(), "dev-figlet"},
+		{DefaultNamespace(), "dev-eu-figlet"},
+		{dev, "eu-figlet"},
+	} {
+		if _, ok := item.namespace.checkFunctionName(item.name).(*ValidationError); !ok {
+			t.Errorf("Want a validation error for %s in namespace %s", item.name, item.namespace.Name)
+		}
+	}
+
+	for _, item := range []struct {
+		namespace *Namespace
+		name      string
+	}{
+		{DefaultNamespace(), "figlet"},
+		{DefaultNamespace(), "developer"},
+		{dev, "figlet"},
+		{devEU, "figlet"},
+	} {
+		if err := item.namespace.checkFunctionName(item.name); err != nil {
+			t.Errorf("Want %s allowed in namespace %s, got %v", item.name, item.namespace.Name, err)
+		}
+	}
+}
+
+func Test_DeleteTaskRevision_OwnedRevisions(t *testing.T) {
+	withStubbedAPI(t, func(api *stubAPI) {
+		namespace := DefaultNamespace()
+		arn := func(family string, revision int) string {
+			return fmt.Sprintf("arn:aws:ecs:us-east-1:123456789012:task-definition/%s:%d", family, revision)
+		}
+
+		owned := functionDockerLabels(namespace, "echo", nil)
+		revisions := map[string]map[string]*string{
+			arn("openfaas-echo", 1):     owned,
+			arn("openfaas-echo", 2):     stagingFooLabels,
+			arn("openfaas-echo", 3):     owned,
+			arn("openfaas-echo-two", 1): functionDockerLabels(namespace, "echo-two", nil),
+		}
+
+		api.on("ListTaskDefinitions", func(input interface{}) (interface{}, error) {
+			var arns []*string
+			for arn := range revisions {
+				arns = append(arns, aws.String(arn))
+			}
+
+			return &ecs.ListTaskDefinitionsOutput{TaskDefinitionArns: arns}, nil
+		})
+		api.on("DescribeTaskDefinition", func(input interface{}) (interface{}, error) {
+			arn := aws.StringValue(input.(*ecs.DescribeTaskDefinitionInput).TaskDefinition)
+			family, _ := parseTaskDefinitionArn(arn)
+			return &ecs.DescribeTaskDefinitionOutput{TaskDefinition: &ecs.TaskDefinition{
+				Family:               aws.String(family),
+				TaskDefinitionArn:    aws.String(arn),
+				ContainerDefinitions: []*ecs.ContainerDefinition{{Name: aws.String(family), DockerLabels: revisions[arn]}},
+			}}, nil
+		})
+
+		deregistered := map[string]bool{}
+		api.on("DeregisterTaskDefinition", func(input interface{}) (interface{}, error) {
+			deregistered[aws.StringValue(input.(*ecs.DeregisterTaskDefinitionInput).TaskDefinition)] = true
+			return &ecs.DeregisterTaskDefinitionOutput{}, nil
+		})
+		api.on("DeleteRolePolicy", func(input interface{}) (interface{}, error) {
+			return &iam.DeleteRolePolicyOutput{}, nil
+		})
+		api.on("DeleteRole", func(input interface{}) (interface{}, error) {
+			return &iam.DeleteRoleOutput{}, nil
+		})
+		api.on("DeleteLogGroup", func(input interface{}) (interface{}, error) {
+			return &cloudwatchlogs.DeleteLogGroupOutput{}, nil
+		})
+		api.on("DescribeSecret", func(input interface{}) (interface{}, error) {
+			return nil, awserr.New(secretsmanager.ErrCodeResourceNotFoundException, "no secret", nil)
+		})
+
+		if err := DeleteTaskRevision(namespace, "echo"); err != nil {
+			t.Fatal(err)
+		}
+
+		want := map[string]bool{arn("openfaas-echo", 1): true, arn("openfaas-echo", 3): true}
+		if !reflect.DeepEqual(deregistered, want) {
+			t.Errorf("Want the revisions of echo deregistered %v, got %v", want, deregistered)
+		}
+	})
+}
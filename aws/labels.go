@@ -0,0 +1,47 @@
+package aws
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// functionDockerLabels converts the OpenFaaS function labels into docker labels stored on the function container.
+// The version of the ECS api we use does not support tagging services or task definitions, so the task
+// definition, which is re-registered on every deploy and update, is where labels live.
+// Labels using the reserved ownership prefix are replaced with the labels identifying the owner of the function.
+func functionDockerLabels(namespace *Namespace, functionName string, labels *map[string]string) map[string]*string {
+	result := namespace.ownershipLabels(functionName)
+	if labels == nil {
+		return result
+	}
+
+	for name, value := range *labels {
+		if strings.HasPrefix(name, ownershipLabelPrefix) {
+			continue
+		}
+
+		result[name] = aws.String(value)
+	}
+
+	return result
+}
+
+// labelsFromContainer returns the OpenFaaS function labels stored on the function container
+func labelsFromContainer(container *ecs.ContainerDefinition) *map[string]string {
+	result := map[string]string{}
+	if container == nil {
+		return &result
+	}
+
+	for name, value := range container.DockerLabels {
+		if strings.HasPrefix(name, ownershipLabelPrefix) {
+			continue
+		}
+
+		result[name] = aws.StringValue(value)
+	}
+
+	return &result
+}
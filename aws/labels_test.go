@@ -0,0 +1,37 @@
+package aws
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func Test_Labels_RoundTrip(t *testing.T) {
+	labels := map[string]string{
+		"com.openfaas.scale.min": "2",
+		"team":                   "data processing",
+	}
+
+	container := &ecs.ContainerDefinition{DockerLabels: functionDockerLabels(DefaultNamespace(), "figlet", &labels)}
+	got := *labelsFromContainer(container)
+
+	if len(got) != len(labels) {
+		t.Fatalf("Want %d labels, got %d", len(labels), len(got))
+	}
+
+	for name, value := range labels {
+		if got[name] != value {
+			t.Errorf("%s: want %s, got %s", name, value, got[name])
+		}
+	}
+}
+
+func Test_Labels_Empty(t *testing.T) {
+	if labels := functionDockerLabels(DefaultNamespace(), "figlet", nil); len(labels) != len(DefaultNamespace().ownershipLabels("figlet")) {
+		t.Errorf("Want only ownership labels, got %v", labels)
+	}
+
+	if labels := labelsFromContainer(nil); len(*labels) != 0 {
+		t.Errorf("Want no labels, got %v", *labels)
+	}
+}
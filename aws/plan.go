@@ -0,0 +1,147 @@
+package aws
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/servicediscovery"
+	"github.com/ewilde/faas-fargate/types"
+	"github.com/openfaas/faas/gateway/requests"
+)
+
+// knownAfterDeploy stands in for values, such as the arn of a resource, that are only known once the resource has
+// been created
+const knownAfterDeploy = "(known after deploy)"
+
+// DeployPlan describes the AWS requests a deploy would make, for review before anything is changed
+type DeployPlan struct {
+	// TaskDefinition is the task definition revision that would be registered
+	TaskDefinition *ecs.RegisterTaskDefinitionInput `json:"taskDefinition"`
+	// RepositoryCredentials maps containers to the registry auth secret added to the task definition
+	RepositoryCredentials map[string]string `json:"repositoryCredentials,omitempty"`
+	// RolePolicy is the policy document that would be put on the function role
+	RolePolicy json.RawMessage `json:"rolePolicy"`
+	// ServiceDiscovery is the route 53 auto-naming service that would be created, if there is none
+	ServiceDiscovery *servicediscovery.CreateServiceInput `json:"serviceDiscovery,omitempty"`
+	// CreateService is the ECS service that would be created when the function does not exist
+	CreateService *ecs.CreateServiceInput `json:"createService,omitempty"`
+	// UpdateService is the update that would be made to the existing ECS service
+	UpdateService *ecs.UpdateServiceInput `json:"updateService,omitempty"`
+	// ScalableTarget and ScalingPolicy are the autoscaling that would be configured, when it is enabled
+	ScalableTarget *scalableTarget `json:"scalableTarget,omitempty"`
+	ScalingPolicy  *scalingPolicy  `json:"scalingPolicy,omitempty"`
+	// Diff against the function currently running
+	Diff *FunctionDiff `json:"diff"`
+}
+
+// PlanDeploy renders the requests deploying the function would make and diffs them against what exists, without
+// changing anything
+func PlanDeploy(
+	namespace *Namespace,
+	request requests.CreateFunctionRequest,
+	config *types.DeployHandlerConfig) (*DeployPlan, error) {
+
+	rendered, err := renderTaskDefinition(namespace, request, config)
+	if err != nil {
+		return nil, err
+	}
+
+	service, err := describeFunctionService(namespace, request.Service)
+	if err != nil {
+		return nil, err
+	}
+
+	diff, err := diffRenderedFunction(namespace, request, rendered, service)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &DeployPlan{TaskDefinition: rendered.input, Diff: diff}
+
+	if rendered.credentials != nil {
+		secretArn := rendered.registryAuthArn
+		if len(secretArn) == 0 {
+			secretArn = knownAfterDeploy
+			buildRegistryAuthPolicyStatement(rendered.policy, secretArn)
+		}
+
+		plan.RepositoryCredentials = map[string]string{aws.StringValue(rendered.input.Family): secretArn}
+	}
+
+	plan.RolePolicy = json.RawMessage(rendered.policy.String())
+
+	roleArn, err := planRoleArn(namespace, request.Service)
+	if err != nil {
+		return nil, err
+	}
+
+	rendered.input.TaskRoleArn = aws.String(roleArn)
+	rendered.input.ExecutionRoleArn = aws.String(roleArn)
+
+	if scaling, _ := newScalingSettings(request.Labels); scaling.applicationAutoScaling() {
+		target := functionScalableTarget(namespace, request.Service)
+		plan.ScalingPolicy = scaling.policy(namespace.ServiceNameFromFunctionName(request.Service), target)
+		plan.ScalableTarget = target
+		plan.ScalableTarget.MinCapacity = aws.Int64(scaling.min)
+		plan.ScalableTarget.MaxCapacity = aws.Int64(scaling.max)
+	}
+
+	// the family resolves to its latest revision, which will be the revision registered by the deploy
+	taskDefinitionArn := rendered.input.Family
+	if service != nil {
+		plan.UpdateService = updateServiceInput(namespace, service, taskDefinitionArn, request)
+		return plan, nil
+	}
+
+	registryArn, err := planServiceRegistration(namespace, request.Service, plan)
+	if err != nil {
+		return nil, err
+	}
+
+	plan.CreateService = createServiceInput(namespace, taskDefinitionArn, request, config, registryArn)
+	return plan, nil
+}
+
+// planRoleArn returns the arn of the function role, if it exists
+func planRoleArn(namespace *Namespace, functionName string) (string, error) {
+	roleName := namespace.ServiceNameFromFunctionName(functionName)
+	existing, err := iamClient.GetRole(&iam.GetRoleInput{RoleName: aws.String(roleName)})
+	if checkForErrorAllowEntityNotExists(err) != nil {
+		return "", fmt.Errorf("could not get role %s. %v", roleName, err)
+	}
+
+	if existing.Role == nil {
+		return knownAfterDeploy, nil
+	}
+
+	return aws.StringValue(existing.Role.Arn), nil
+}
+
+// planServiceRegistration returns the arn of the route 53 auto-naming service of the function, adding the request
+// that would create it to the plan when it does not exist
+func planServiceRegistration(namespace *Namespace, functionName string, plan *DeployPlan) (string, error) {
+	serviceName := namespace.DiscoveryNameFromFunctionName(functionName)
+	namespaceID, found, err := lookupDNSNamespace(namespace)
+	if err != nil {
+		return "", err
+	}
+
+	if found {
+		registration, err := findServiceRegistration(namespaceID, serviceName)
+		if err != nil {
+			return "", err
+		}
+
+		if registration != nil {
+			return aws.StringValue(registration.Arn), nil
+		}
+	} else {
+		namespaceID = aws.String(knownAfterDeploy)
+	}
+
+	plan.ServiceDiscovery = serviceRegistrationInput(namespace, functionName, namespaceID)
+	return knownAfterDeploy, nil
+}
@@ -0,0 +1,119 @@
+package aws
+
+import (
+	"encoding/json"
+	"net/url"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/servicediscovery"
+	"github.com/ewilde/faas-fargate/types"
+	"github.com/openfaas/faas/gateway/requests"
+)
+
+func Test_PlanDeploy_Create(t *testing.T) {
+	withStubbedAPI(t, func(api *stubAPI) {
+		api.on("DescribeServices", func(input interface{}) (interface{}, error) {
+			return &ecs.DescribeServicesOutput{}, nil
+		})
+		api.on("GetRole", func(input interface{}) (interface{}, error) {
+			return nil, awserr.New(iam.ErrCodeNoSuchEntityException, "no role", nil)
+		})
+		api.on("ListNamespaces", func(input interface{}) (interface{}, error) {
+			return &servicediscovery.ListNamespacesOutput{Namespaces: []*servicediscovery.NamespaceSummary{
+				{Id: aws.String("ns-local"), Name: aws.String(dnsNamespace)},
+			}}, nil
+		})
+		api.on("ListServices", func(input interface{}) (interface{}, error) {
+			return &servicediscovery.ListServicesOutput{}, nil
+		})
+
+		request := requests.CreateFunctionRequest{Service: "figlet", Image: "functions/figlet:0.1"}
+		config := &types.DeployHandlerConfig{Region: "eu-west-1", SubnetIDs: "subnet-1"}
+		plan, err := PlanDeploy(DefaultNamespace(), request, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if plan.Diff.Exists || len(plan.Diff.Changes) != 0 {
+			t.Errorf("Want a function which does not exist yet without changes, got %+v", plan.Diff)
+		}
+
+		if plan.CreateService == nil || plan.UpdateService != nil || plan.ServiceDiscovery == nil {
+			t.Errorf("Want the service and its registration created, got %+v", plan)
+		}
+
+		if arn := aws.StringValue(plan.TaskDefinition.TaskRoleArn); arn != knownAfterDeploy {
+			t.Errorf("Want the role arn known after deploy, got %s", arn)
+		}
+
+		for _, operation := range []string{"CreateLogGroup", "CreateRole", "RegisterTaskDefinition", "CreateService"} {
+			if api.called(operation) {
+				t.Errorf("Want nothing changed, got a call to %s", operation)
+			}
+		}
+	})
+}
+
+func Test_PlanDeploy_Update(t *testing.T) {
+	withStubbedAPI(t, func(api *stubAPI) {
+		namespace := DefaultNamespace()
+		config := &types.DeployHandlerConfig{Region: "eu-west-1", SubnetIDs: "subnet-1"}
+		request := requests.CreateFunctionRequest{Service: "figlet", Image: "functions/figlet:0.1"}
+
+		stubbed := stubFunctionService(api, namespace, "figlet", nil)
+		stubbed.taskDefinition = testRenderedTaskDefinition(t, request, config)
+
+		roleName := namespace.ServiceNameFromFunctionName("figlet")
+		currentPolicy := `{"Version":"2012-10-17","Statement":[]}`
+		api.on("GetRole", func(input interface{}) (interface{}, error) {
+			return &iam.GetRoleOutput{Role: &iam.Role{
+				RoleName: aws.String(roleName),
+				Arn:      aws.String("arn:aws:iam::123456789012:role/" + roleName),
+				Path:     aws.String("/"),
+			}}, nil
+		})
+		api.on("GetRolePolicy", func(input interface{}) (interface{}, error) {
+			return &iam.GetRolePolicyOutput{PolicyDocument: aws.String(url.QueryEscape(currentPolicy))}, nil
+		})
+
+		request.Image = "functions/figlet:0.2"
+		plan, err := PlanDeploy(namespace, request, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if plan.UpdateService == nil || plan.CreateService != nil {
+			t.Errorf("Want the existing service updated, got %+v", plan)
+		}
+
+		details := map[string]FieldChange{}
+		for _, change := range plan.Diff.Details {
+			details[change.Part] = change
+		}
+
+		if len(plan.Diff.Changes) != 2 || len(details) != 2 {
+			t.Fatalf("Want the image and role policy changed, got %v", plan.Diff.Changes)
+		}
+
+		if image := details[changeImage]; image.Current != "functions/figlet:0.1" || image.Desired != "functions/figlet:0.2" {
+			t.Errorf("Want the image changed from 0.1 to 0.2, got %+v", image)
+		}
+
+		policy := details[changeRolePolicy]
+		if current, _ := policy.Current.(json.RawMessage); string(current) != currentPolicy {
+			t.Errorf("Want the current role policy %s, got %v", currentPolicy, policy.Current)
+		}
+
+		if desired, _ := policy.Desired.(json.RawMessage); !json.Valid(desired) {
+			t.Errorf("Want the desired role policy document, got %v", policy.Desired)
+		}
+
+		if api.called("UpdateService") || api.called("PutRolePolicy") {
+			t.Error("Want nothing changed")
+		}
+	})
+}
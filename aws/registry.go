@@ -0,0 +1,373 @@
+package aws
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	log "github.com/sirupsen/logrus"
+)
+
+// registryAuthSuffix is appended to the function service name to name the secret holding its registry credentials
+const registryAuthSuffix = "-registry-auth"
+
+// secretCurrentStage labels the version of a secret that is read by default
+const secretCurrentStage = "AWSCURRENT"
+
+// registryAuthVersionLabel records the version of the registry auth secret a task definition revision was registered
+// with, so a rollback can make it current again
+const registryAuthVersionLabel = ownershipLabelPrefix + "registry-auth-version"
+
+// registryCredentials is the secret format ECS expects for private registry authentication
+// see: https://docs.aws.amazon.com/AmazonECS/latest/developerguide/private-auth.html
+type registryCredentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// decodeRegistryAuth decodes the docker config style (base64 encoded username:password) registry auth
+func decodeRegistryAuth(auth string) (*registryCredentials, error) {
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(auth))
+	if err != nil {
+		return nil, newValidationError("registry auth is not valid base64. %v", err)
+	}
+
+	parts := strings.SplitN(string(decoded), ":", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 {
+		return nil, newValidationError("registry auth must be an encoded username:password")
+	}
+
+	return &registryCredentials{Username: parts[0], Password: parts[1]}, nil
+}
+
+func registryAuthSecretName(namespace *Namespace, functionName string) string {
+	return namespace.ServiceNameFromFunctionName(functionName) + registryAuthSuffix
+}
+
+// ensureRegistryAuthSecret stores the registry credentials for the function in secrets manager returning the
+// secret arn and the id of the version written. An existing secret is given a new version so rotating credentials
+// does not create a new secret, the undo function returned makes the previous version current again or deletes a
+// secret that was created.
+func ensureRegistryAuthSecret(
+	namespace *Namespace,
+	functionName string,
+	credentials *registryCredentials) (string, string, undoFunc, error) {
+
+	name := registryAuthSecretName(namespace, functionName)
+	value, err := json.Marshal(credentials)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	existing, err := secretsClient.DescribeSecret(&secretsmanager.DescribeSecretInput{SecretId: aws.String(name)})
+	if err != nil && !isSecretNotFound(err) {
+		return "", "", nil, fmt.Errorf("error describing registry auth secret %s. %v", name, err)
+	}
+
+	if err == nil {
+		tags := map[string]*string{}
+		for _, tag := range existing.Tags {
+			tags[aws.StringValue(tag.Key)] = tag.Value
+		}
+
+		if !namespace.isOwnedResource(tags, functionName) {
+			return "", "", nil, newConflictError(
+				"registry auth secret %s belongs to a function of another installation or namespace", name)
+		}
+
+		return updateRegistryAuthSecret(namespace, functionName, existing, string(value))
+	}
+
+	created, err := secretsClient.CreateSecret(&secretsmanager.CreateSecretInput{
+		Name:         aws.String(name),
+		Description:  aws.String(fmt.Sprintf("Openfaas registry credentials for %s", functionName)),
+		SecretString: aws.String(string(value)),
+	})
+	if err != nil {
+		return "", "", nil, fmt.Errorf("error creating registry auth secret %s. %v", name, err)
+	}
+
+	undo := func() error { return deleteRegistryAuthSecret(namespace, functionName) }
+
+	var tags []*secretsmanager.Tag
+	for key, tagValue := range namespace.ownershipLabels(functionName) {
+		tags = append(tags, &secretsmanager.Tag{Key: aws.String(key), Value: tagValue})
+	}
+
+	_, err = secretsClient.TagResource(&secretsmanager.TagResourceInput{SecretId: created.ARN, Tags: tags})
+	if err != nil {
+		if undoErr := undo(); undoErr != nil {
+			log.Errorln(undoErr)
+		}
+
+		return "", "", nil, fmt.Errorf("error tagging registry auth secret %s. %v", name, err)
+	}
+
+	log.Infof("Created registry auth secret %s", name)
+	return aws.StringValue(created.ARN), aws.StringValue(created.VersionId), undo, nil
+}
+
+// updateRegistryAuthSecret puts a new version of an existing secret, restoring it first if a previous deploy
+// scheduled it for deletion
+func updateRegistryAuthSecret(
+	namespace *Namespace,
+	functionName string,
+	existing *secretsmanager.DescribeSecretOutput,
+	value string) (string, string, undoFunc, error) {
+
+	name := aws.StringValue(existing.Name)
+	restored := existing.DeletedDate != nil
+	if restored {
+		_, err := secretsClient.RestoreSecret(&secretsmanager.RestoreSecretInput{SecretId: existing.ARN})
+		if err != nil {
+			return "", "", nil, fmt.Errorf("error restoring registry auth secret %s. %v", name, err)
+		}
+	}
+
+	output, err := secretsClient.PutSecretValue(&secretsmanager.PutSecretValueInput{
+		SecretId:     existing.ARN,
+		SecretString: aws.String(value),
+	})
+	if err != nil {
+		if restored {
+			if deleteErr := deleteRegistryAuthSecret(namespace, functionName); deleteErr != nil {
+				log.Errorln(deleteErr)
+			}
+		}
+
+		return "", "", nil, fmt.Errorf("error updating registry auth secret %s. %v", name, err)
+	}
+
+	log.Infof("Updated registry auth secret %s", name)
+
+	previousVersion := currentSecretVersion(existing.VersionIdsToStages)
+	undo := func() error {
+		if restored {
+			return deleteRegistryAuthSecret(namespace, functionName)
+		}
+
+		return moveCurrentSecretVersion(existing.ARN, previousVersion, aws.StringValue(output.VersionId))
+	}
+
+	return aws.StringValue(existing.ARN), aws.StringValue(output.VersionId), undo, nil
+}
+
+// restoreRegistryAuthVersion makes the version of the registry auth secret of the function current again, restoring
+// the secret first if it is scheduled for deletion. Returns the secret arn and an undo function putting back the
+// version that was current.
+func restoreRegistryAuthVersion(namespace *Namespace, functionName string, version string) (string, undoFunc, error) {
+	name := registryAuthSecretName(namespace, functionName)
+	existing, err := secretsClient.DescribeSecret(&secretsmanager.DescribeSecretInput{SecretId: aws.String(name)})
+	if err != nil {
+		if isSecretNotFound(err) {
+			return "", nil, newValidationError("registry auth secret %s no longer exists", name)
+		}
+
+		return "", nil, fmt.Errorf("error describing registry auth secret %s. %v", name, err)
+	}
+
+	restored := existing.DeletedDate != nil
+	if restored {
+		_, err := secretsClient.RestoreSecret(&secretsmanager.RestoreSecretInput{SecretId: existing.ARN})
+		if err != nil {
+			return "", nil, fmt.Errorf("error restoring registry auth secret %s. %v", name, err)
+		}
+	}
+
+	current := currentSecretVersion(existing.VersionIdsToStages)
+	err = moveCurrentSecretVersion(existing.ARN, version, current)
+	if err != nil {
+		if restored {
+			if deleteErr := deleteRegistryAuthSecret(namespace, functionName); deleteErr != nil {
+				log.Errorln(deleteErr)
+			}
+		}
+
+		return "", nil, fmt.Errorf("error restoring version %s of registry auth secret %s. %v", version, name, err)
+	}
+
+	undo := func() error {
+		if restored {
+			return deleteRegistryAuthSecret(namespace, functionName)
+		}
+
+		return moveCurrentSecretVersion(existing.ARN, current, version)
+	}
+
+	return aws.StringValue(existing.ARN), undo, nil
+}
+
+// moveCurrentSecretVersion labels the version as the current version of the secret instead of the version that was
+// current, nothing is changed when the version is empty or already current
+func moveCurrentSecretVersion(secretArn *string, version string, current string) error {
+	if len(version) == 0 || version == current {
+		return nil
+	}
+
+	input := &secretsmanager.UpdateSecretVersionStageInput{
+		SecretId:        secretArn,
+		VersionStage:    aws.String(secretCurrentStage),
+		MoveToVersionId: aws.String(version),
+	}
+	if len(current) > 0 {
+		input.RemoveFromVersionId = aws.String(current)
+	}
+
+	_, err := secretsClient.UpdateSecretVersionStage(input)
+	return err
+}
+
+// readRegistryAuthSecret returns the registry credentials stored in the version of the registry auth secret of the
+// function
+func readRegistryAuthSecret(namespace *Namespace, functionName string, version string) (*registryCredentials, error) {
+	name := registryAuthSecretName(namespace, functionName)
+	output, err := secretsClient.GetSecretValue(&secretsmanager.GetSecretValueInput{
+		SecretId:  aws.String(name),
+		VersionId: aws.String(version),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading version %s of registry auth secret %s. %v", version, name, err)
+	}
+
+	credentials := &registryCredentials{}
+	if err := json.Unmarshal([]byte(aws.StringValue(output.SecretString)), credentials); err != nil {
+		return nil, fmt.Errorf("error decoding registry auth secret %s. %v", name, err)
+	}
+
+	return credentials, nil
+}
+
+// activeRegistryAuthSecretArn returns the arn of the registry auth secret of the function, or an empty string if it
+// does not exist or is scheduled for deletion
+func activeRegistryAuthSecretArn(namespace *Namespace, functionName string) (string, error) {
+	name := registryAuthSecretName(namespace, functionName)
+	existing, err := secretsClient.DescribeSecret(&secretsmanager.DescribeSecretInput{SecretId: aws.String(name)})
+	if err != nil {
+		if isSecretNotFound(err) {
+			return "", nil
+		}
+
+		return "", fmt.Errorf("error describing registry auth secret %s. %v", name, err)
+	}
+
+	if existing.DeletedDate != nil {
+		return "", nil
+	}
+
+	return aws.StringValue(existing.ARN), nil
+}
+
+// currentSecretVersion returns the id of the version labelled AWSCURRENT, or an empty string
+func currentSecretVersion(versionIdsToStages map[string][]*string) string {
+	for id, stages := range versionIdsToStages {
+		for _, stage := range stages {
+			if aws.StringValue(stage) == secretCurrentStage {
+				return id
+			}
+		}
+	}
+
+	return ""
+}
+
+// deleteRegistryAuthSecret removes the registry credentials for the function, if there are any that are not already
+// scheduled for deletion
+func deleteRegistryAuthSecret(namespace *Namespace, functionName string) error {
+	name := registryAuthSecretName(namespace, functionName)
+	existing, err := secretsClient.DescribeSecret(&secretsmanager.DescribeSecretInput{SecretId: aws.String(name)})
+	if err != nil {
+		if isSecretNotFound(err) {
+			return nil
+		}
+
+		return fmt.Errorf("error describing registry auth secret %s. %v", name, err)
+	}
+
+	if existing.DeletedDate != nil {
+		return nil
+	}
+
+	_, err = secretsClient.DeleteSecret(&secretsmanager.DeleteSecretInput{
+		SecretId:             aws.String(name),
+		RecoveryWindowInDays: aws.Int64(7),
+	})
+
+	if err != nil && !isSecretNotFound(err) {
+		return fmt.Errorf("error deleting registry auth secret %s. %v", name, err)
+	}
+
+	return nil
+}
+
+func buildRegistryAuthPolicyStatement(builder *PolicyBuilder, secretArn string) {
+	builder.AddStatement([]string{"secretsmanager:GetSecretValue"}, []string{secretArn})
+}
+
+// registerTaskDefinition registers the task definition, adding repository credentials to the named containers.
+// The vendored ECS api predates repositoryCredentials, so the field is added to the request body after it is built.
+func registerTaskDefinition(
+	input *ecs.RegisterTaskDefinitionInput,
+	repositoryCredentials map[string]string) (*ecs.RegisterTaskDefinitionOutput, error) {
+
+	req, output := ecsClient.RegisterTaskDefinitionRequest(input)
+	if len(repositoryCredentials) > 0 {
+		req.Handlers.Build.PushBack(func(r *request.Request) {
+			if r.Error != nil {
+				return
+			}
+
+			body, err := ioutil.ReadAll(r.GetBody())
+			if err != nil {
+				r.Error = err
+				return
+			}
+
+			body, err = addRepositoryCredentials(body, repositoryCredentials)
+			if err != nil {
+				r.Error = err
+				return
+			}
+
+			r.SetBufferBody(body)
+		})
+	}
+
+	return output, req.Send()
+}
+
+func addRepositoryCredentials(body []byte, repositoryCredentials map[string]string) ([]byte, error) {
+	document := map[string]interface{}{}
+	if err := json.Unmarshal(body, &document); err != nil {
+		return nil, fmt.Errorf("error reading register task definition request. %v", err)
+	}
+
+	containers, _ := document["containerDefinitions"].([]interface{})
+	for _, item := range containers {
+		container, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		name, _ := container["name"].(string)
+		if arn, exists := repositoryCredentials[name]; exists {
+			container["repositoryCredentials"] = map[string]string{"credentialsParameter": arn}
+		}
+	}
+
+	return json.Marshal(document)
+}
+
+func isSecretNotFound(err error) bool {
+	if awsErr, ok := err.(awserr.Error); ok {
+		return awsErr.Code() == secretsmanager.ErrCodeResourceNotFoundException
+	}
+
+	return false
+}
@@ -0,0 +1,55 @@
+package aws
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+)
+
+func Test_DecodeRegistryAuth(t *testing.T) {
+	credentials, err := decodeRegistryAuth(base64.StdEncoding.EncodeToString([]byte("ewilde:pa:ss")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if credentials.Username != "ewilde" || credentials.Password != "pa:ss" {
+		t.Errorf("Want ewilde/pa:ss, got %s/%s", credentials.Username, credentials.Password)
+	}
+}
+
+func Test_DecodeRegistryAuth_Invalid(t *testing.T) {
+	for _, auth := range []string{"not base64!", base64.StdEncoding.EncodeToString([]byte("nopassword"))} {
+		if _, err := decodeRegistryAuth(auth); err == nil {
+			t.Errorf("%s: expected error", auth)
+		}
+	}
+}
+
+func Test_AddRepositoryCredentials(t *testing.T) {
+	body := []byte(`{"family":"openfaas-figlet","containerDefinitions":[` +
+		`{"name":"openfaas-figlet-kms"},{"name":"openfaas-figlet","image":"private/figlet"}]}`)
+
+	result, err := addRepositoryCredentials(body, map[string]string{"openfaas-figlet": "arn:secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	document := struct {
+		ContainerDefinitions []struct {
+			Name                  string            `json:"name"`
+			RepositoryCredentials map[string]string `json:"repositoryCredentials"`
+		} `json:"containerDefinitions"`
+	}{}
+
+	if err := json.Unmarshal(result, &document); err != nil {
+		t.Fatal(err)
+	}
+
+	if document.ContainerDefinitions[0].RepositoryCredentials != nil {
+		t.Errorf("Want no credentials on the sidecar, got %v", document.ContainerDefinitions[0].RepositoryCredentials)
+	}
+
+	if got := document.ContainerDefinitions[1].RepositoryCredentials["credentialsParameter"]; got != "arn:secret" {
+		t.Errorf("Want arn:secret, got %s", got)
+	}
+}
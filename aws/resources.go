@@ -0,0 +1,198 @@
+package aws
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/openfaas/faas/gateway/requests"
+)
+
+const (
+	// defaultTaskCPU is the fargate cpu units used when a function specifies no cpu limit or request
+	defaultTaskCPU = 256
+	// defaultTaskMemory is the fargate memory (MiB) used when a function specifies no memory limit or request
+	defaultTaskMemory = 512
+
+	// secretsSidecarCPU cpu units reserved for the kms-template secrets sidecar
+	secretsSidecarCPU = 64
+	// secretsSidecarMemory memory (MiB) reserved for the kms-template secrets sidecar
+	secretsSidecarMemory = 32
+)
+
+// fargateTaskSizes lists the valid memory (MiB) values for each fargate cpu value.
+// see: https://docs.aws.amazon.com/AmazonECS/latest/developerguide/task-cpu-memory-error.html
+var fargateTaskSizes = []struct {
+	cpu      int64
+	memories []int64
+}{
+	{cpu: 256, memories: []int64{512, 1024, 2048}},
+	{cpu: 512, memories: memoryRange(1024, 4096)},
+	{cpu: 1024, memories: memoryRange(2048, 8192)},
+	{cpu: 2048, memories: memoryRange(4096, 16384)},
+	{cpu: 4096, memories: memoryRange(8192, 30720)},
+}
+
+// TaskSize is the cpu and memory given to a fargate task and split between its containers
+type TaskSize struct {
+	// CPU units for the whole task, 1024 units is one vCPU
+	CPU int64
+	// Memory for the whole task in MiB
+	Memory int64
+	// FunctionCPU units given to the function container
+	FunctionCPU int64
+	// FunctionMemory in MiB given to the function container
+	FunctionMemory int64
+}
+
+// NewTaskSize calculates the smallest valid fargate task size able to satisfy the limits, or failing that the
+// requests, of the function. Room is made for the secrets sidecar when the function uses secrets.
+func NewTaskSize(request requests.CreateFunctionRequest) (*TaskSize, error) {
+	cpuValue, memoryValue := "", ""
+	for _, resources := range []*requests.FunctionResources{request.Limits, request.Requests} {
+		if resources == nil {
+			continue
+		}
+
+		if len(cpuValue) == 0 {
+			cpuValue = resources.CPU
+		}
+
+		if len(memoryValue) == 0 {
+			memoryValue = resources.Memory
+		}
+	}
+
+	wantCPU, err := ParseCPU(cpuValue)
+	if err != nil {
+		return nil, err
+	}
+
+	wantMemory, err := ParseMemory(memoryValue)
+	if err != nil {
+		return nil, err
+	}
+
+	var sidecarCPU, sidecarMemory int64
+	if len(request.Secrets) > 0 {
+		sidecarCPU = secretsSidecarCPU
+		sidecarMemory = secretsSidecarMemory
+	}
+
+	if wantCPU == 0 {
+		wantCPU = defaultTaskCPU - sidecarCPU
+	}
+
+	if wantMemory == 0 {
+		wantMemory = defaultTaskMemory - sidecarMemory
+	}
+
+	cpu, memory, err := fargateTaskSize(wantCPU+sidecarCPU, wantMemory+sidecarMemory)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TaskSize{
+		CPU:            cpu,
+		Memory:         memory,
+		FunctionCPU:    cpu - sidecarCPU,
+		FunctionMemory: memory - sidecarMemory,
+	}, nil
+}
+
+// ParseMemory converts an OpenFaaS memory value i.e. 128Mi, 2Gi, 512m, 1G into MiB. An empty value returns 0.
+func ParseMemory(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	if len(value) == 0 {
+		return 0, nil
+	}
+
+	units := []struct {
+		suffix string
+		bytes  float64
+	}{
+		{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30}, {"Ti", 1 << 40},
+		{"k", 1e3}, {"K", 1e3}, {"m", 1 << 20}, {"M", 1e6}, {"G", 1e9}, {"g", 1 << 30}, {"T", 1e12},
+	}
+
+	number, multiplier := value, float64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(value, unit.suffix) {
+			number = strings.TrimSuffix(value, unit.suffix)
+			multiplier = unit.bytes
+			break
+		}
+	}
+
+	parsed, err := strconv.ParseFloat(number, 64)
+	if err != nil || parsed <= 0 {
+		return 0, newValidationError("invalid memory value %s", value)
+	}
+
+	mebibytes := parsed * multiplier / (1 << 20)
+	result := int64(mebibytes)
+	if float64(result) < mebibytes {
+		result++
+	}
+
+	return result, nil
+}
+
+// ParseCPU converts an OpenFaaS cpu value i.e. 500m, 0.5, 2 into fargate cpu units where 1024 units is one vCPU.
+// An empty value returns 0.
+func ParseCPU(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	if len(value) == 0 {
+		return 0, nil
+	}
+
+	cores := float64(0)
+	if strings.HasSuffix(value, "m") {
+		millis, err := strconv.ParseFloat(strings.TrimSuffix(value, "m"), 64)
+		if err != nil || millis <= 0 {
+			return 0, newValidationError("invalid cpu value %s", value)
+		}
+
+		cores = millis / 1000
+	} else {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed <= 0 {
+			return 0, newValidationError("invalid cpu value %s", value)
+		}
+
+		cores = parsed
+	}
+
+	units := cores * 1024
+	result := int64(units)
+	if float64(result) < units {
+		result++
+	}
+
+	return result, nil
+}
+
+func fargateTaskSize(cpu int64, memory int64) (int64, int64, error) {
+	for _, size := range fargateTaskSizes {
+		if size.cpu < cpu {
+			continue
+		}
+
+		for _, m := range size.memories {
+			if m >= memory {
+				return size.cpu, m, nil
+			}
+		}
+	}
+
+	return 0, 0, newValidationError(
+		"no fargate task size can provide %d cpu units and %dMiB of memory", cpu, memory)
+}
+
+func memoryRange(from int64, to int64) []int64 {
+	var result []int64
+	for m := from; m <= to; m += 1024 {
+		result = append(result, m)
+	}
+
+	return result
+}
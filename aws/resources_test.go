@@ -0,0 +1,128 @@
+package aws
+
+import (
+	"testing"
+
+	"github.com/openfaas/faas/gateway/requests"
+)
+
+func Test_ParseMemory(t *testing.T) {
+	cases := map[string]int64{
+		"":      0,
+		"128Mi": 128,
+		"2Gi":   2048,
+		"512m":  512,
+		"1G":    954,
+		"1g":    1024,
+		"1024":  1,
+	}
+
+	for value, want := range cases {
+		got, err := ParseMemory(value)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", value, err)
+		}
+
+		if got != want {
+			t.Errorf("%s: want %d, got %d", value, want, got)
+		}
+	}
+}
+
+func Test_ParseMemory_Invalid(t *testing.T) {
+	for _, value := range []string{"lots", "-1Mi", "0"} {
+		if _, err := ParseMemory(value); err == nil {
+			t.Errorf("%s: expected error", value)
+		}
+	}
+}
+
+func Test_ParseCPU(t *testing.T) {
+	cases := map[string]int64{
+		"":     0,
+		"500m": 512,
+		"0.25": 256,
+		"1":    1024,
+		"2":    2048,
+	}
+
+	for value, want := range cases {
+		got, err := ParseCPU(value)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", value, err)
+		}
+
+		if got != want {
+			t.Errorf("%s: want %d, got %d", value, want, got)
+		}
+	}
+}
+
+func Test_NewTaskSize_Default(t *testing.T) {
+	size, err := NewTaskSize(requests.CreateFunctionRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertTaskSize(t, size, TaskSize{CPU: 256, Memory: 512, FunctionCPU: 256, FunctionMemory: 512})
+}
+
+func Test_NewTaskSize_Default_WithSecrets(t *testing.T) {
+	size, err := NewTaskSize(requests.CreateFunctionRequest{Secrets: []string{"db-password"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertTaskSize(t, size, TaskSize{CPU: 256, Memory: 512, FunctionCPU: 192, FunctionMemory: 480})
+}
+
+func Test_NewTaskSize_SnapsToValidSize(t *testing.T) {
+	size, err := NewTaskSize(requests.CreateFunctionRequest{
+		Limits: &requests.FunctionResources{Memory: "3Gi", CPU: "100m"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertTaskSize(t, size, TaskSize{CPU: 512, Memory: 3072, FunctionCPU: 512, FunctionMemory: 3072})
+}
+
+func Test_NewTaskSize_SecretsSidecarMovesToNextSize(t *testing.T) {
+	size, err := NewTaskSize(requests.CreateFunctionRequest{
+		Limits:  &requests.FunctionResources{Memory: "512Mi", CPU: "250m"},
+		Secrets: []string{"db-password"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertTaskSize(t, size, TaskSize{CPU: 512, Memory: 1024, FunctionCPU: 448, FunctionMemory: 992})
+}
+
+func Test_NewTaskSize_LimitsTakePrecedenceOverRequests(t *testing.T) {
+	size, err := NewTaskSize(requests.CreateFunctionRequest{
+		Limits:   &requests.FunctionResources{Memory: "2Gi"},
+		Requests: &requests.FunctionResources{Memory: "128Mi", CPU: "1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertTaskSize(t, size, TaskSize{CPU: 1024, Memory: 2048, FunctionCPU: 1024, FunctionMemory: 2048})
+}
+
+func Test_NewTaskSize_Impossible(t *testing.T) {
+	_, err := NewTaskSize(requests.CreateFunctionRequest{
+		Limits: &requests.FunctionResources{Memory: "64Gi", CPU: "4"},
+	})
+
+	if _, ok := err.(*ValidationError); !ok {
+		t.Errorf("Want ValidationError, got %v", err)
+	}
+}
+
+func assertTaskSize(t *testing.T, got *TaskSize, want TaskSize) {
+	if *got != want {
+		t.Errorf("Want %+v, got %+v", want, *got)
+	}
+}
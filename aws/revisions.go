@@ -0,0 +1,238 @@
+package aws
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	log "github.com/sirupsen/logrus"
+)
+
+// registeredAtLabel records when a task definition revision was registered, the ECS api we use does not return it
+const registeredAtLabel = ownershipLabelPrefix + "registered-at"
+
+// FunctionRevision is a task definition revision registered by a deploy or update of the function
+type FunctionRevision struct {
+	Revision     int64             `json:"revision"`
+	Arn          string            `json:"arn"`
+	Image        string            `json:"image"`
+	EnvProcess   string            `json:"envProcess,omitempty"`
+	EnvVars      map[string]string `json:"envVars"`
+	Labels       map[string]string `json:"labels"`
+	CPU          string            `json:"cpu"`
+	Memory       string            `json:"memory"`
+	RegisteredAt *time.Time        `json:"registeredAt,omitempty"`
+	// InUse is true when the ECS service is running, or rolling out, the revision
+	InUse bool `json:"inUse"`
+	// RolledBackAt lists the times the function was rolled back to the revision
+	RolledBackAt []time.Time `json:"rolledBackAt,omitempty"`
+}
+
+// GetFunctionRevisions returns the active task definition revisions of the function, newest first, or nil if the
+// function has no revisions owned by this installation
+func GetFunctionRevisions(namespace *Namespace, functionName string) ([]FunctionRevision, error) {
+	arns, err := listTaskRevisions(namespace, functionName)
+	if err != nil {
+		return nil, err
+	}
+
+	inUse, err := revisionsInUse(namespace, functionName)
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := readHistoryEvents(namespace, functionName)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []FunctionRevision
+	for _, arn := range arns {
+		output, err := ecsClient.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{TaskDefinition: aws.String(arn)})
+		if err != nil {
+			return nil, fmt.Errorf("error describing task definition %s. %v", arn, err)
+		}
+
+		container := FunctionContainer(output.TaskDefinition)
+		if !namespace.isOwnedFunction(container, functionName) {
+			continue
+		}
+
+		revision := newFunctionRevision(output.TaskDefinition, inUse[arn])
+		for _, event := range history {
+			if event.Action == historyActionRollback && event.To == arn {
+				revision.RolledBackAt = append(revision.RolledBackAt, event.Time)
+			}
+		}
+
+		result = append(result, revision)
+	}
+
+	return result, nil
+}
+
+func newFunctionRevision(taskDefinition *ecs.TaskDefinition, inUse bool) FunctionRevision {
+	container := FunctionContainer(taskDefinition)
+	revision := FunctionRevision{
+		Revision: aws.Int64Value(taskDefinition.Revision),
+		Arn:      aws.StringValue(taskDefinition.TaskDefinitionArn),
+		Image:    aws.StringValue(container.Image),
+		EnvVars:  map[string]string{},
+		Labels:   *labelsFromContainer(container),
+		CPU:      aws.StringValue(taskDefinition.Cpu),
+		Memory:   aws.StringValue(taskDefinition.Memory),
+		InUse:    inUse,
+	}
+
+	for _, item := range container.Environment {
+		if aws.StringValue(item.Name) == envProcessName {
+			revision.EnvProcess = aws.StringValue(item.Value)
+			continue
+		}
+
+		revision.EnvVars[aws.StringValue(item.Name)] = aws.StringValue(item.Value)
+	}
+
+	if registeredAt, err := time.Parse(time.RFC3339, aws.StringValue(container.DockerLabels[registeredAtLabel])); err == nil {
+		revision.RegisteredAt = &registeredAt
+	}
+
+	return revision
+}
+
+// listTaskRevisions returns the arns of the active revisions of the function task definition family, newest first
+func listTaskRevisions(namespace *Namespace, functionName string) ([]string, error) {
+	family := namespace.ServiceNameFromFunctionName(functionName)
+
+	var arns []string
+	err := ecsClient.ListTaskDefinitionsPages(
+		&ecs.ListTaskDefinitionsInput{
+			FamilyPrefix: aws.String(family),
+			Status:       aws.String(ecs.TaskDefinitionStatusActive),
+		},
+		func(output *ecs.ListTaskDefinitionsOutput, lastPage bool) bool {
+			for _, arn := range output.TaskDefinitionArns {
+				// the family prefix also matches the families of functions whose names start with this function name
+				if itemFamily, _ := parseTaskDefinitionArn(aws.StringValue(arn)); itemFamily == family {
+					arns = append(arns, aws.StringValue(arn))
+				}
+			}
+
+			return true
+		})
+
+	if err != nil {
+		return nil, fmt.Errorf("error listing task definitions of %s. %v", family, err)
+	}
+
+	sortRevisionsNewestFirst(arns)
+	return arns, nil
+}
+
+// sortRevisionsNewestFirst sorts task definition arns of one family by revision, newest first
+func sortRevisionsNewestFirst(arns []string) {
+	sort.Slice(arns, func(i, j int) bool {
+		_, left := parseTaskDefinitionArn(arns[i])
+		_, right := parseTaskDefinitionArn(arns[j])
+		return left > right
+	})
+}
+
+// parseTaskDefinitionArn returns the family and revision of a task definition arn, in the format
+// arn:aws:ecs:<region>:<account>:task-definition/<family>:<revision>
+func parseTaskDefinitionArn(arn string) (string, int64) {
+	name := arn[strings.LastIndex(arn, "/")+1:]
+	separator := strings.LastIndex(name, ":")
+	if separator < 0 {
+		return name, 0
+	}
+
+	revision, _ := strconv.ParseInt(name[separator+1:], 10, 64)
+	return name[:separator], revision
+}
+
+// revisionsInUse returns the arns of the task definitions the function service, or its canary, is running or rolling
+// out
+func revisionsInUse(namespace *Namespace, functionName string) (map[string]bool, error) {
+	service, err := describeFunctionService(namespace, functionName)
+	if err != nil {
+		return nil, err
+	}
+
+	return withCanaryTaskDefinitions(namespace, functionName, serviceTaskDefinitions(service))
+}
+
+// withCanaryTaskDefinitions adds the task definitions the canary of the function is running to inUse
+func withCanaryTaskDefinitions(namespace *Namespace, functionName string, inUse map[string]bool) (map[string]bool, error) {
+	canary, err := describeCanaryService(namespace, functionName)
+	if err != nil {
+		return nil, err
+	}
+
+	for arn := range serviceTaskDefinitions(canary) {
+		inUse[arn] = true
+	}
+
+	return inUse, nil
+}
+
+func serviceTaskDefinitions(service *ecs.Service) map[string]bool {
+	result := map[string]bool{}
+	if service == nil {
+		return result
+	}
+
+	result[aws.StringValue(service.TaskDefinition)] = true
+	for _, deployment := range service.Deployments {
+		result[aws.StringValue(deployment.TaskDefinition)] = true
+	}
+
+	return result
+}
+
+// expiredRevisions returns the revisions beyond the newest keep revisions, excluding those in use
+func expiredRevisions(arns []string, keep int, inUse map[string]bool) []string {
+	var result []string
+	for i, arn := range arns {
+		if i < keep || inUse[arn] {
+			continue
+		}
+
+		result = append(result, arn)
+	}
+
+	return result
+}
+
+// pruneTaskRevisions deregisters the revisions of the function beyond the newest keep revisions, never touching
+// the revisions the service or its canary is using. A keep of zero retains every revision.
+func pruneTaskRevisions(namespace *Namespace, functionName string, keep int, service *ecs.Service) error {
+	if keep <= 0 {
+		return nil
+	}
+
+	arns, err := listTaskRevisions(namespace, functionName)
+	if err != nil {
+		return err
+	}
+
+	inUse, err := withCanaryTaskDefinitions(namespace, functionName, serviceTaskDefinitions(service))
+	if err != nil {
+		return err
+	}
+
+	for _, arn := range expiredRevisions(arns, keep, inUse) {
+		log.Infof("Deregistering task definition %s of function %s, beyond the revision history limit of %d",
+			arn, functionName, keep)
+
+		if err := deregisterTaskDefinition(aws.String(arn)); err != nil {
+			return fmt.Errorf("error deregistering task definition %s. %v", arn, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,196 @@
+package aws
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+)
+
+func Test_ParseTaskDefinitionArn(t *testing.T) {
+	family, revision := parseTaskDefinitionArn("arn:aws:ecs:eu-west-1:122668425727:task-definition/openfaas-figlet:12")
+	if family != "openfaas-figlet" || revision != 12 {
+		t.Errorf("Want openfaas-figlet 12, got %s %d", family, revision)
+	}
+}
+
+func Test_SortRevisionsNewestFirst(t *testing.T) {
+	arns := []string{
+		"arn:aws:ecs:eu-west-1:1:task-definition/openfaas-figlet:9",
+		"arn:aws:ecs:eu-west-1:1:task-definition/openfaas-figlet:10",
+		"arn:aws:ecs:eu-west-1:1:task-definition/openfaas-figlet:2",
+	}
+
+	sortRevisionsNewestFirst(arns)
+	want := []string{
+		"arn:aws:ecs:eu-west-1:1:task-definition/openfaas-figlet:10",
+		"arn:aws:ecs:eu-west-1:1:task-definition/openfaas-figlet:9",
+		"arn:aws:ecs:eu-west-1:1:task-definition/openfaas-figlet:2",
+	}
+
+	if !reflect.DeepEqual(arns, want) {
+		t.Errorf("Want %v, got %v", want, arns)
+	}
+}
+
+func Test_ExpiredRevisions_KeepsNewestAndInUse(t *testing.T) {
+	arns := []string{"r5", "r4", "r3", "r2", "r1"}
+	expired := expiredRevisions(arns, 2, map[string]bool{"r2": true})
+
+	want := []string{"r3", "r1"}
+	if !reflect.DeepEqual(expired, want) {
+		t.Errorf("Want %v, got %v", want, expired)
+	}
+}
+
+func Test_RollbackTarget(t *testing.T) {
+	arns := []string{
+		"arn:aws:ecs:eu-west-1:1:task-definition/openfaas-figlet:5",
+		"arn:aws:ecs:eu-west-1:1:task-definition/openfaas-figlet:4",
+		"arn:aws:ecs:eu-west-1:1:task-definition/openfaas-figlet:2",
+	}
+
+	target, err := rollbackTarget(arns, arns[1], 0)
+	if err != nil || target != arns[2] {
+		t.Errorf("Want the previous revision %s, got %s %v", arns[2], target, err)
+	}
+
+	target, err = rollbackTarget(arns, arns[1], 5)
+	if err != nil || target != arns[0] {
+		t.Errorf("Want revision 5, got %s %v", target, err)
+	}
+
+	if _, err := rollbackTarget(arns, arns[2], 0); err == nil {
+		t.Errorf("Want an error when there is no older revision")
+	}
+
+	if _, err := rollbackTarget(arns, arns[0], 3); err == nil {
+		t.Errorf("Want an error for a revision which is not active")
+	}
+
+	if _, err := rollbackTarget(arns, arns[0], 5); err == nil {
+		t.Errorf("Want an error for the revision in use")
+	}
+}
+
+func Test_RevisionSecrets(t *testing.T) {
+	taskDefinition := &ecs.TaskDefinition{
+		Family: aws.String("openfaas-figlet"),
+		ContainerDefinitions: []*ecs.ContainerDefinition{
+			{
+				Name: aws.String("openfaas-figlet-kms"),
+				Environment: []*ecs.KeyValuePair{
+					{Name: aws.String("SECRETS"), Value: aws.String("openfaas-api-key,openfaas-db-password")},
+				},
+			},
+			{Name: aws.String("openfaas-figlet")},
+		},
+	}
+
+	want := []string{"api-key", "db-password"}
+	if got := revisionSecrets(taskDefinition); !reflect.DeepEqual(got, want) {
+		t.Errorf("Want %v, got %v", want, got)
+	}
+
+	taskDefinition.ContainerDefinitions = taskDefinition.ContainerDefinitions[1:]
+	if got := revisionSecrets(taskDefinition); len(got) != 0 {
+		t.Errorf("Want no secrets without a sidecar, got %v", got)
+	}
+}
+
+func Test_RollbackFunction_AppliesRevisionLabels(t *testing.T) {
+	withStubbedAPI(t, func(api *stubAPI) {
+		withLocalScaling(t, func(local *localScaling) {
+			namespace := DefaultNamespace()
+			stubbed := stubFunctionService(api, namespace, "echo", nil)
+			family := aws.StringValue(stubbed.taskDefinition.Family)
+
+			revisions := map[string]map[string]string{}
+			var arns []*string
+			for revision, labels := range []map[string]string{
+				{scaleMinLabel: "2"},
+				{scaleMinLabel: "3", scaleMaxLabel: "6", scaleTypeLabel: scaleTypeMemory, minHealthyPercentLabel: "50"},
+				nil,
+			} {
+				arn := fmt.Sprintf("arn:aws:ecs:us-east-1:123456789012:task-definition/%s:%d", family, revision+1)
+				revisions[arn] = labels
+				arns = append(arns, aws.String(arn))
+			}
+			stubbed.service.TaskDefinition = arns[2]
+
+			api.on("ListTaskDefinitions", func(input interface{}) (interface{}, error) {
+				return &ecs.ListTaskDefinitionsOutput{TaskDefinitionArns: arns}, nil
+			})
+			api.on("DescribeTaskDefinition", func(input interface{}) (interface{}, error) {
+				arn := aws.StringValue(input.(*ecs.DescribeTaskDefinitionInput).TaskDefinition)
+				labels := revisions[arn]
+				_, revision := parseTaskDefinitionArn(arn)
+				return &ecs.DescribeTaskDefinitionOutput{TaskDefinition: &ecs.TaskDefinition{
+					Family:            aws.String(family),
+					TaskDefinitionArn: aws.String(arn),
+					Revision:          aws.Int64(revision),
+					ContainerDefinitions: []*ecs.ContainerDefinition{
+						{Name: aws.String(family), DockerLabels: functionDockerLabels(namespace, "echo", &labels)},
+					},
+				}}, nil
+			})
+			api.on("DescribeSecret", func(input interface{}) (interface{}, error) {
+				return nil, awserr.New(secretsmanager.ErrCodeResourceNotFoundException, "no secret", nil)
+			})
+			api.on("GetRole", func(input interface{}) (interface{}, error) {
+				return &iam.GetRoleOutput{Role: &iam.Role{RoleName: aws.String(family), Arn: aws.String("role"), Path: aws.String("/")}}, nil
+			})
+			api.on("GetRolePolicy", func(input interface{}) (interface{}, error) {
+				return &iam.GetRolePolicyOutput{}, nil
+			})
+			api.on("PutRolePolicy", func(input interface{}) (interface{}, error) {
+				return &iam.PutRolePolicyOutput{}, nil
+			})
+			api.on("DescribeLogStreams", func(input interface{}) (interface{}, error) {
+				return &cloudwatchlogs.DescribeLogStreamsOutput{LogStreams: []*cloudwatchlogs.LogStream{
+					{LogStreamName: aws.String(historyStreamName), UploadSequenceToken: aws.String("1")},
+				}}, nil
+			})
+			api.on("PutLogEvents", func(input interface{}) (interface{}, error) {
+				return &cloudwatchlogs.PutLogEventsOutput{}, nil
+			})
+
+			target := aws.StringValue(functionScalableTarget(namespace, "echo").ResourceId)
+
+			if _, err := RollbackFunction(namespace, "echo", 2); err != nil {
+				t.Fatal(err)
+			}
+
+			update := stubbed.updates[len(stubbed.updates)-1]
+			if aws.StringValue(update.TaskDefinition) != aws.StringValue(arns[1]) || update.DesiredCount != nil ||
+				aws.Int64Value(update.DeploymentConfiguration.MinimumHealthyPercent) != 50 {
+				t.Errorf("Want revision 2 with its deployment configuration and the count left to autoscaling, got %s", update)
+			}
+
+			if scalable, found := local.targets[target]; !found ||
+				aws.Int64Value(scalable.MinCapacity) != 3 || aws.Int64Value(scalable.MaxCapacity) != 6 {
+				t.Errorf("Want the autoscaling of revision 2 registered, got %+v", scalable)
+			}
+
+			if _, err := RollbackFunction(namespace, "echo", 1); err != nil {
+				t.Fatal(err)
+			}
+
+			update = stubbed.updates[len(stubbed.updates)-1]
+			if aws.Int64Value(update.DesiredCount) != 2 ||
+				aws.Int64Value(update.DeploymentConfiguration.MinimumHealthyPercent) != defaultMinHealthyPercent {
+				t.Errorf("Want revision 1 with its minimum of 2 replicas and the default deployment configuration, got %s", update)
+			}
+
+			if _, found := local.targets[target]; found {
+				t.Error("Want the autoscaling removed rolling back to a revision without it")
+			}
+		})
+	})
+}
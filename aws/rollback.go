@@ -0,0 +1,195 @@
+package aws
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/openfaas/faas/gateway/requests"
+	log "github.com/sirupsen/logrus"
+)
+
+// RollbackFunction points the function service back at an earlier task definition revision, the previous revision
+// when revision is zero. Labels live on the task definition, so the labels of the revision are restored with it, and
+// the role policy and registry credentials are restored from the revision, see restoreRevisionAccess. The service
+// settings of the labels, its deployment configuration, desired count and autoscaling, are applied as a deploy of
+// the revision would. No task definition is written. Returns nil if the function is not found.
+func RollbackFunction(namespace *Namespace, functionName string, revision int64) (*FunctionRevision, error) {
+	service, err := describeFunctionService(namespace, functionName)
+	if err != nil || service == nil {
+		return nil, err
+	}
+
+	arns, err := listTaskRevisions(namespace, functionName)
+	if err != nil {
+		return nil, err
+	}
+
+	current := aws.StringValue(service.TaskDefinition)
+	target, err := rollbackTarget(arns, current, revision)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := ecsClient.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{TaskDefinition: aws.String(target)})
+	if err != nil {
+		return nil, fmt.Errorf("error describing task definition %s. %v", target, err)
+	}
+
+	if !namespace.isOwnedFunction(FunctionContainer(output.TaskDefinition), functionName) {
+		return nil, newValidationError("revision %d does not belong to function %s", revision, functionName)
+	}
+
+	if isCanaryRevision(namespace, functionName, output.TaskDefinition) {
+		return nil, newValidationError("revision %d was deployed as a canary and uses the canary role, "+
+			"roll back to the revision it was promoted to instead", aws.Int64Value(output.TaskDefinition.Revision))
+	}
+
+	request := requests.CreateFunctionRequest{
+		Service: functionName,
+		Labels:  labelsFromContainer(FunctionContainer(output.TaskDefinition)),
+	}
+
+	settings, err := newRolloutSettings(request.Labels)
+	if err != nil {
+		return nil, newValidationError("the labels of revision %d can not be applied. %v",
+			aws.Int64Value(output.TaskDefinition.Revision), err)
+	}
+
+	scaling, err := newScalingSettings(request.Labels)
+	if err != nil {
+		return nil, newValidationError("the labels of revision %d can not be applied. %v",
+			aws.Int64Value(output.TaskDefinition.Revision), err)
+	}
+
+	d := newDeployment(functionName)
+	if err := restoreRevisionAccess(d, namespace, functionName, output.TaskDefinition); err != nil {
+		return nil, err
+	}
+
+	updated, err := updateECSService(d, namespace, service, aws.String(target), request)
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.run(stepAutoscaling, func() (undoFunc, error) {
+		return configureAutoscaling(namespace, functionName, scaling)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	watchRollout(namespace, functionName, updated, settings)
+
+	log.Infof("Rolled back function %s from %s to %s", functionName, current, target)
+
+	now := time.Now().UTC()
+	event := historyEvent{Time: now, Action: historyActionRollback, From: current, To: target}
+	if err := recordHistoryEvent(namespace, functionName, event); err != nil {
+		log.Warnf("Error recording rollback of %s. %v", functionName, err)
+	}
+
+	result := newFunctionRevision(output.TaskDefinition, true)
+	result.RolledBackAt = []time.Time{now}
+	return &result, nil
+}
+
+// rollbackTarget returns the arn of the requested revision, or when revision is zero the newest revision older than
+// the current one. Arns must be sorted newest first.
+func rollbackTarget(arns []string, current string, revision int64) (string, error) {
+	_, currentRevision := parseTaskDefinitionArn(current)
+	for _, arn := range arns {
+		_, itemRevision := parseTaskDefinitionArn(arn)
+		if revision == 0 && itemRevision < currentRevision {
+			return arn, nil
+		}
+
+		if revision != 0 && itemRevision == revision {
+			if arn == current {
+				return "", newValidationError("revision %d is already in use", revision)
+			}
+
+			return arn, nil
+		}
+	}
+
+	if revision == 0 {
+		return "", newValidationError("there is no revision older than revision %d to roll back to", currentRevision)
+	}
+
+	return "", newValidationError("revision %d is not an active revision", revision)
+}
+
+// restoreRevisionAccess puts back the role policy and registry credentials the task definition revision was
+// registered with, as later deploys may have changed them. The policy is rebuilt from the log group, the secrets the
+// sidecar reads and the registry auth secret of the revision. The registry auth secret version recorded on the
+// revision is made current again, a revision registered without a recorded version leaves the secret as it is.
+func restoreRevisionAccess(d *deployment, namespace *Namespace, functionName string, taskDefinition *ecs.TaskDefinition) error {
+	container := FunctionContainer(taskDefinition)
+	if container == nil {
+		return newValidationError("task definition %s has no function container", aws.StringValue(taskDefinition.TaskDefinitionArn))
+	}
+
+	policy := NewPolicyBuilder()
+	if err := buildLogPolicyStatement(policy, logGroupName(namespace, functionName)); err != nil {
+		return err
+	}
+
+	if secrets := revisionSecrets(taskDefinition); len(secrets) > 0 {
+		if err := buildSecretsPolicyStatement(policy, functionName, secrets); err != nil {
+			return newValidationError("the secrets of revision %s can not be restored. %v",
+				aws.StringValue(taskDefinition.TaskDefinitionArn), err)
+		}
+	}
+
+	var secretArn string
+	version, recorded := container.DockerLabels[registryAuthVersionLabel]
+	err := d.run(stepRegistryAuth, func() (undo undoFunc, err error) {
+		if recorded {
+			secretArn, undo, err = restoreRegistryAuthVersion(namespace, functionName, aws.StringValue(version))
+			return undo, err
+		}
+
+		secretArn, err = activeRegistryAuthSecretArn(namespace, functionName)
+		return nil, err
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(secretArn) > 0 {
+		buildRegistryAuthPolicyStatement(policy, secretArn)
+	}
+
+	return d.run(stepRole, func() (undo undoFunc, err error) {
+		_, undo, err = createRoleWithPolicy(namespace, functionName, policy.String())
+		return undo, err
+	})
+}
+
+// revisionSecrets returns the names of the secrets the secrets sidecar of the task definition reads
+func revisionSecrets(taskDefinition *ecs.TaskDefinition) []string {
+	sidecar := fmt.Sprintf("%s-kms", aws.StringValue(taskDefinition.Family))
+	for _, container := range taskDefinition.ContainerDefinitions {
+		if aws.StringValue(container.Name) != sidecar {
+			continue
+		}
+
+		for _, item := range container.Environment {
+			if aws.StringValue(item.Name) != "SECRETS" || len(aws.StringValue(item.Value)) == 0 {
+				continue
+			}
+
+			var names []string
+			for _, name := range strings.Split(aws.StringValue(item.Value), ",") {
+				names = append(names, strings.TrimPrefix(name, secretPrefix))
+			}
+
+			return names
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,449 @@
+package aws
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	minHealthyPercentLabel = "com.openfaas.deploy.minHealthyPercent"
+	maxPercentLabel        = "com.openfaas.deploy.maxPercent"
+	circuitBreakerLabel    = "com.openfaas.deploy.circuitBreaker"
+	rollbackLabel          = "com.openfaas.deploy.rollback"
+
+	// defaultMinHealthyPercent and defaultMaxPercent are the ECS defaults of the deployment configuration
+	defaultMinHealthyPercent = 100
+	defaultMaxPercent        = 200
+
+	// rolloutPollInterval is how often a rollout is checked while waiting for it or watching it, a wait with little
+	// time left checks more often but no more often than minRolloutPollInterval
+	rolloutPollInterval    = 10 * time.Second
+	minRolloutPollInterval = 500 * time.Millisecond
+	// rolloutWatchTimeout is how long the circuit breaker watches a rollout before giving up on it
+	rolloutWatchTimeout = 30 * time.Minute
+)
+
+// Rollout states
+const (
+	RolloutInProgress = "IN_PROGRESS"
+	RolloutCompleted  = "COMPLETED"
+	RolloutFailed     = "FAILED"
+	RolloutRolledBack = "ROLLED_BACK"
+)
+
+// rolloutSettings controls how ECS replaces the tasks of a function and what happens when the new tasks fail
+type rolloutSettings struct {
+	minHealthyPercent *int64
+	maxPercent        *int64
+	// circuitBreaker fails the rollout once enough tasks of the new revision have failed. The ECS api we use has no
+	// deployment circuit breaker, so faas-fargate watches the rollout instead.
+	circuitBreaker bool
+	// rollback returns the service to the previous revision when the circuit breaker fails the rollout
+	rollback bool
+}
+
+// newRolloutSettings reads the rollout settings from the com.openfaas.deploy.* labels
+func newRolloutSettings(labels *map[string]string) (*rolloutSettings, error) {
+	settings := &rolloutSettings{}
+	if labels == nil {
+		return settings, nil
+	}
+
+	percentages := []struct {
+		label    string
+		min, max int64
+		value    **int64
+	}{
+		{minHealthyPercentLabel, 0, 100, &settings.minHealthyPercent},
+		{maxPercentLabel, 100, 200, &settings.maxPercent},
+	}
+
+	for _, setting := range percentages {
+		raw, exists := (*labels)[setting.label]
+		if !exists {
+			continue
+		}
+
+		value, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+		if err != nil || value < setting.min || value > setting.max {
+			return nil, newValidationError("label %s must be between %d and %d, got %s",
+				setting.label, setting.min, setting.max, raw)
+		}
+
+		*setting.value = aws.Int64(value)
+	}
+
+	flags := []struct {
+		label string
+		value *bool
+	}{
+		{circuitBreakerLabel, &settings.circuitBreaker},
+		{rollbackLabel, &settings.rollback},
+	}
+
+	for _, setting := range flags {
+		raw, exists := (*labels)[setting.label]
+		if !exists {
+			continue
+		}
+
+		value, err := strconv.ParseBool(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, newValidationError("label %s must be true or false, got %s", setting.label, raw)
+		}
+
+		*setting.value = value
+	}
+
+	// rolling back relies on the circuit breaker noticing the failure
+	if settings.rollback {
+		settings.circuitBreaker = true
+	}
+
+	return settings, nil
+}
+
+// deploymentConfiguration returns the ECS deployment configuration, or nil to use the ECS defaults
+func (s *rolloutSettings) deploymentConfiguration() *ecs.DeploymentConfiguration {
+	if s == nil || (s.minHealthyPercent == nil && s.maxPercent == nil) {
+		return nil
+	}
+
+	return &ecs.DeploymentConfiguration{
+		MinimumHealthyPercent: s.minHealthyPercent,
+		MaximumPercent:        s.maxPercent,
+	}
+}
+
+// updateDeploymentConfiguration returns the ECS deployment configuration for updating a service. ECS keeps the
+// configuration of the service when an update leaves it out, so the ECS defaults are sent for percentages without a
+// label, and removing a label resets it.
+func (s *rolloutSettings) updateDeploymentConfiguration() *ecs.DeploymentConfiguration {
+	config := &ecs.DeploymentConfiguration{
+		MinimumHealthyPercent: aws.Int64(defaultMinHealthyPercent),
+		MaximumPercent:        aws.Int64(defaultMaxPercent),
+	}
+
+	if s == nil {
+		return config
+	}
+
+	if s.minHealthyPercent != nil {
+		config.MinimumHealthyPercent = s.minHealthyPercent
+	}
+
+	if s.maxPercent != nil {
+		config.MaximumPercent = s.maxPercent
+	}
+
+	return config
+}
+
+// RolloutStatus is the progress of replacing the tasks of a function with those of its primary revision
+type RolloutStatus struct {
+	State          string     `json:"state"`
+	TaskDefinition string     `json:"taskDefinition"`
+	Desired        int64      `json:"desired"`
+	Running        int64      `json:"running"`
+	Pending        int64      `json:"pending"`
+	FailedTasks    int64      `json:"failedTasks"`
+	StartedAt      *time.Time `json:"startedAt,omitempty"`
+	UpdatedAt      *time.Time `json:"updatedAt,omitempty"`
+	// RolledBackFrom is the revision the circuit breaker rolled back from
+	RolledBackFrom string `json:"rolledBackFrom,omitempty"`
+	Reason         string `json:"reason,omitempty"`
+}
+
+// Done returns true when the rollout will make no more progress
+func (s *RolloutStatus) Done() bool {
+	return s.State != RolloutInProgress
+}
+
+// rolloutRecord is the outcome of a rollout the circuit breaker failed
+type rolloutRecord struct {
+	state          string
+	taskDefinition string
+	rolledBackFrom string
+	reason         string
+}
+
+var rolloutsLock = &sync.Mutex{}
+var rollouts = map[string]rolloutRecord{}
+
+func rolloutKey(namespace *Namespace, functionName string) string {
+	return namespace.Name + "/" + functionName
+}
+
+// GetRolloutStatus returns the rollout status of the function, or nil if the function is not found
+func GetRolloutStatus(namespace *Namespace, functionName string) (*RolloutStatus, error) {
+	service, err := describeFunctionService(namespace, functionName)
+	if err != nil || service == nil {
+		return nil, err
+	}
+
+	primary := primaryDeployment(service)
+	if primary == nil {
+		return nil, fmt.Errorf("service %s has no primary deployment", aws.StringValue(service.ServiceName))
+	}
+
+	output, err := ecsClient.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{TaskDefinition: primary.TaskDefinition})
+	if err != nil {
+		return nil, fmt.Errorf("error describing task definition %s. %v", aws.StringValue(primary.TaskDefinition), err)
+	}
+
+	settings, err := newRolloutSettings(labelsFromContainer(FunctionContainer(output.TaskDefinition)))
+	if err != nil {
+		settings = &rolloutSettings{}
+	}
+
+	stopped, err := getServiceTasks(namespace, service.ServiceName, ecs.DesiredStatusStopped)
+	if err != nil {
+		return nil, err
+	}
+
+	rolloutsLock.Lock()
+	record, found := rollouts[rolloutKey(namespace, functionName)]
+	rolloutsLock.Unlock()
+
+	status := newRolloutStatus(service, primary, failedTasks(primary, stopped), settings.circuitBreaker)
+	if found && record.taskDefinition == status.TaskDefinition {
+		status.State = record.state
+		status.RolledBackFrom = record.rolledBackFrom
+		status.Reason = record.reason
+	}
+
+	return status, nil
+}
+
+func newRolloutStatus(service *ecs.Service, primary *ecs.Deployment, failed int64, circuitBreaker bool) *RolloutStatus {
+	status := &RolloutStatus{
+		State:          RolloutInProgress,
+		TaskDefinition: aws.StringValue(primary.TaskDefinition),
+		Desired:        aws.Int64Value(primary.DesiredCount),
+		Running:        aws.Int64Value(primary.RunningCount),
+		Pending:        aws.Int64Value(primary.PendingCount),
+		FailedTasks:    failed,
+		StartedAt:      primary.CreatedAt,
+		UpdatedAt:      primary.UpdatedAt,
+	}
+
+	switch {
+	case circuitBreaker && failed >= circuitBreakerThreshold(status.Desired):
+		status.State = RolloutFailed
+		status.Reason = fmt.Sprintf("%d tasks of the new revision failed", failed)
+	case len(service.Deployments) == 1 && status.Running == status.Desired && status.Pending == 0:
+		status.State = RolloutCompleted
+	}
+
+	return status
+}
+
+// circuitBreakerThreshold is the number of failed tasks that fails a rollout, half the desired count bounded to
+// between 3 and 200, as ECS does
+func circuitBreakerThreshold(desired int64) int64 {
+	threshold := desired / 2
+	if threshold < 3 {
+		return 3
+	}
+
+	if threshold > 200 {
+		return 200
+	}
+
+	return threshold
+}
+
+// failedTasks counts the tasks of the primary deployment that stopped, other than those stopped by scaling
+func failedTasks(primary *ecs.Deployment, stopped []*ecs.Task) int64 {
+	var failed int64
+	for _, task := range stopped {
+		if aws.StringValue(task.TaskDefinitionArn) != aws.StringValue(primary.TaskDefinition) {
+			continue
+		}
+
+		if primary.CreatedAt != nil && task.CreatedAt != nil && task.CreatedAt.Before(*primary.CreatedAt) {
+			continue
+		}
+
+		if strings.HasPrefix(aws.StringValue(task.StoppedReason), "Scaling activity initiated by") {
+			continue
+		}
+
+		failed++
+	}
+
+	return failed
+}
+
+func primaryDeployment(service *ecs.Service) *ecs.Deployment {
+	for _, item := range service.Deployments {
+		if aws.StringValue(item.Status) == "PRIMARY" {
+			return item
+		}
+	}
+
+	return nil
+}
+
+// previousTaskDefinition returns the task definition the service was running before the primary deployment
+func previousTaskDefinition(service *ecs.Service) *string {
+	for _, item := range service.Deployments {
+		if aws.StringValue(item.Status) == "ACTIVE" {
+			return item.TaskDefinition
+		}
+	}
+
+	return nil
+}
+
+// WaitForRollout polls the rollout status of the function until it is done or the deadline passes, returning the
+// last status seen. The status is read at least once, even when the deadline has passed.
+func WaitForRollout(namespace *Namespace, functionName string, deadline time.Time) (*RolloutStatus, error) {
+	for {
+		status, err := GetRolloutStatus(namespace, functionName)
+		if err != nil || status == nil || status.Done() {
+			return status, err
+		}
+
+		interval := rolloutWaitInterval(time.Until(deadline))
+		if interval == 0 {
+			return status, nil
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+// rolloutWaitInterval returns how long to sleep before checking the rollout again with the time remaining of a wait,
+// a quarter of it up to the rolloutPollInterval, or zero when there is no time for another check
+func rolloutWaitInterval(remaining time.Duration) time.Duration {
+	interval := remaining / 4
+	if interval > rolloutPollInterval {
+		interval = rolloutPollInterval
+	}
+
+	if interval < minRolloutPollInterval {
+		return 0
+	}
+
+	return interval
+}
+
+// watchRollout acts as the deployment circuit breaker, failing the rollout of the service once too many of its new
+// tasks fail and, when enabled, rolling back to the previous revision
+func watchRollout(namespace *Namespace, functionName string, service *ecs.Service, settings *rolloutSettings) {
+	key := rolloutKey(namespace, functionName)
+	rolloutsLock.Lock()
+	delete(rollouts, key)
+	rolloutsLock.Unlock()
+
+	if !settings.circuitBreaker {
+		return
+	}
+
+	previous := previousTaskDefinition(service)
+	target := primaryDeployment(service)
+	if target == nil {
+		return
+	}
+
+	go func() {
+		deadline := time.Now().Add(rolloutWatchTimeout)
+		for time.Now().Before(deadline) {
+			time.Sleep(rolloutPollInterval)
+
+			status, err := GetRolloutStatus(namespace, functionName)
+			if err != nil {
+				log.Warnf("Error checking rollout of %s. %v", functionName, err)
+				continue
+			}
+
+			if status == nil || status.TaskDefinition != aws.StringValue(target.TaskDefinition) {
+				return // the function was deleted or deployed again
+			}
+
+			if status.State == RolloutCompleted {
+				log.Infof("Rollout of %s to %s completed", functionName, status.TaskDefinition)
+				return
+			}
+
+			if status.State == RolloutFailed {
+				failRollout(namespace, functionName, service, previous, settings, status)
+				return
+			}
+		}
+	}()
+}
+
+// failRollout records the failed rollout and rolls the service back to the previous revision if enabled
+func failRollout(
+	namespace *Namespace,
+	functionName string,
+	service *ecs.Service,
+	previous *string,
+	settings *rolloutSettings,
+	status *RolloutStatus) {
+
+	log.Errorf("Rollout of %s to %s failed, %s", functionName, status.TaskDefinition, status.Reason)
+
+	record := rolloutRecord{state: RolloutFailed, taskDefinition: status.TaskDefinition, reason: status.Reason}
+	if settings.rollback && previous != nil {
+		err := rollbackService(namespace, functionName, service, previous)
+		if err != nil {
+			log.Errorf("Error rolling back %s to %s. %v", functionName, aws.StringValue(previous), err)
+			record.reason = fmt.Sprintf("%s, rollback failed. %v", status.Reason, err)
+		} else {
+			log.Infof("Rolled back %s from %s to %s", functionName, status.TaskDefinition, aws.StringValue(previous))
+			record = rolloutRecord{
+				state:          RolloutRolledBack,
+				taskDefinition: aws.StringValue(previous),
+				rolledBackFrom: status.TaskDefinition,
+				reason:         status.Reason,
+			}
+
+			event := historyEvent{
+				Time:   time.Now().UTC(),
+				Action: historyActionRollback,
+				From:   status.TaskDefinition,
+				To:     aws.StringValue(previous),
+			}
+			if err := recordHistoryEvent(namespace, functionName, event); err != nil {
+				log.Warnf("Error recording rollback of %s. %v", functionName, err)
+			}
+		}
+	}
+
+	rolloutsLock.Lock()
+	rollouts[rolloutKey(namespace, functionName)] = record
+	rolloutsLock.Unlock()
+}
+
+// rollbackService points the service back at the previous task definition, restoring the role policy and registry
+// credentials it was registered with first
+func rollbackService(namespace *Namespace, functionName string, service *ecs.Service, previous *string) error {
+	output, err := ecsClient.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{TaskDefinition: previous})
+	if err != nil {
+		return fmt.Errorf("error describing task definition %s. %v", aws.StringValue(previous), err)
+	}
+
+	d := newDeployment(functionName)
+	if err := restoreRevisionAccess(d, namespace, functionName, output.TaskDefinition); err != nil {
+		return err
+	}
+
+	return d.run(stepService, func() (undoFunc, error) {
+		_, err := ecsClient.UpdateService(&ecs.UpdateServiceInput{
+			Cluster:        namespace.ClusterID(),
+			Service:        service.ServiceArn,
+			TaskDefinition: previous,
+		})
+		return nil, err
+	})
+}
@@ -0,0 +1,153 @@
+package aws
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func Test_RolloutSettings_Labels(t *testing.T) {
+	settings, err := newRolloutSettings(&map[string]string{
+		minHealthyPercentLabel: "50",
+		maxPercentLabel:        "150",
+		rollbackLabel:          "true",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := settings.deploymentConfiguration()
+	if aws.Int64Value(config.MinimumHealthyPercent) != 50 || aws.Int64Value(config.MaximumPercent) != 150 {
+		t.Errorf("Unexpected deployment configuration %s", config.String())
+	}
+
+	if !settings.circuitBreaker || !settings.rollback {
+		t.Errorf("Want rollback to enable the circuit breaker, got %+v", settings)
+	}
+}
+
+func Test_RolloutSettings_Defaults(t *testing.T) {
+	settings, err := newRolloutSettings(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if settings.deploymentConfiguration() != nil {
+		t.Errorf("Want ECS defaults, got %s", settings.deploymentConfiguration().String())
+	}
+}
+
+func Test_RolloutSettings_UpdateSendsDefaults(t *testing.T) {
+	settings, err := newRolloutSettings(&map[string]string{maxPercentLabel: "150"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := settings.updateDeploymentConfiguration()
+	if aws.Int64Value(config.MinimumHealthyPercent) != 100 || aws.Int64Value(config.MaximumPercent) != 150 {
+		t.Errorf("Want the default minimum healthy percent with the labelled maximum, got %s", config.String())
+	}
+
+	settings, _ = newRolloutSettings(nil)
+	config = settings.updateDeploymentConfiguration()
+	if aws.Int64Value(config.MinimumHealthyPercent) != 100 || aws.Int64Value(config.MaximumPercent) != 200 {
+		t.Errorf("Want the ECS defaults sent when the labels are removed, got %s", config.String())
+	}
+}
+
+func Test_RolloutSettings_Invalid(t *testing.T) {
+	for _, labels := range []map[string]string{
+		{minHealthyPercentLabel: "101"},
+		{maxPercentLabel: "99"},
+		{maxPercentLabel: "lots"},
+		{circuitBreakerLabel: "sometimes"},
+	} {
+		_, err := newRolloutSettings(&labels)
+		if _, ok := err.(*ValidationError); !ok {
+			t.Errorf("Want validation error for %v, got %v", labels, err)
+		}
+	}
+}
+
+func Test_NewRolloutStatus(t *testing.T) {
+	started := time.Now().Add(-time.Minute)
+	primary := &ecs.Deployment{
+		Status:         aws.String("PRIMARY"),
+		TaskDefinition: aws.String("arn:aws:ecs:us-east-1:0:task-definition/openfaas-echo:2"),
+		DesiredCount:   aws.Int64(2),
+		RunningCount:   aws.Int64(2),
+		PendingCount:   aws.Int64(0),
+		CreatedAt:      &started,
+	}
+	active := &ecs.Deployment{
+		Status:         aws.String("ACTIVE"),
+		TaskDefinition: aws.String("arn:aws:ecs:us-east-1:0:task-definition/openfaas-echo:1"),
+	}
+
+	status := newRolloutStatus(&ecs.Service{Deployments: []*ecs.Deployment{primary, active}}, primary, 0, true)
+	if status.State != RolloutInProgress {
+		t.Errorf("Want %s while the old deployment drains, got %s", RolloutInProgress, status.State)
+	}
+
+	status = newRolloutStatus(&ecs.Service{Deployments: []*ecs.Deployment{primary}}, primary, 0, true)
+	if status.State != RolloutCompleted {
+		t.Errorf("Want %s, got %s", RolloutCompleted, status.State)
+	}
+
+	status = newRolloutStatus(&ecs.Service{Deployments: []*ecs.Deployment{primary, active}}, primary, 3, true)
+	if status.State != RolloutFailed {
+		t.Errorf("Want %s, got %s", RolloutFailed, status.State)
+	}
+
+	status = newRolloutStatus(&ecs.Service{Deployments: []*ecs.Deployment{primary, active}}, primary, 3, false)
+	if status.State != RolloutInProgress {
+		t.Errorf("Want %s without the circuit breaker, got %s", RolloutInProgress, status.State)
+	}
+}
+
+func Test_FailedTasks(t *testing.T) {
+	started := time.Now().Add(-time.Minute)
+	before := started.Add(-time.Minute)
+	after := started.Add(time.Second)
+	primary := &ecs.Deployment{TaskDefinition: aws.String("echo:2"), CreatedAt: &started}
+
+	failed := failedTasks(primary, []*ecs.Task{
+		{TaskDefinitionArn: aws.String("echo:2"), CreatedAt: &after, StoppedReason: aws.String("Essential container in task exited")},
+		{TaskDefinitionArn: aws.String("echo:2"), CreatedAt: &after, StoppedReason: aws.String("Scaling activity initiated by (deployment ecs-svc/1)")},
+		{TaskDefinitionArn: aws.String("echo:2"), CreatedAt: &before, StoppedReason: aws.String("Essential container in task exited")},
+		{TaskDefinitionArn: aws.String("echo:1"), CreatedAt: &after, StoppedReason: aws.String("Essential container in task exited")},
+	})
+
+	if failed != 1 {
+		t.Errorf("Want 1 failed task, got %d", failed)
+	}
+}
+
+func Test_CircuitBreakerThreshold(t *testing.T) {
+	for desired, want := range map[int64]int64{1: 3, 10: 5, 1000: 200} {
+		if got := circuitBreakerThreshold(desired); got != want {
+			t.Errorf("Want threshold %d for %d tasks, got %d", want, desired, got)
+		}
+	}
+}
+
+func Test_RolloutWaitInterval(t *testing.T) {
+	cases := []struct {
+		remaining time.Duration
+		want      time.Duration
+	}{
+		{5 * time.Minute, rolloutPollInterval},
+		{9 * time.Second, 2250 * time.Millisecond},
+		{2 * time.Second, minRolloutPollInterval},
+		{time.Second, 0},
+		{-time.Second, 0},
+	}
+
+	for _, c := range cases {
+		if interval := rolloutWaitInterval(c.remaining); interval != c.want {
+			t.Errorf("Want %s between checks with %s remaining, got %s", c.want, c.remaining, interval)
+		}
+	}
+}
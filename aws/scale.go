@@ -0,0 +1,73 @@
+package aws
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	log "github.com/sirupsen/logrus"
+)
+
+// ScaleResult is the desired count a scale request set, with the reason it differs from the count requested
+type ScaleResult struct {
+	Function  string `json:"function"`
+	Requested int64  `json:"requested"`
+	Replicas  int64  `json:"replicas"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// ScaleFunction sets the desired count of the function, clamped to its com.openfaas.scale.min and
+// com.openfaas.scale.max labels. Zero is allowed below the minimum so functions can be scaled to zero. Requests
+// above the provider wide maxReplicas are rejected, as are requests to scale a function while a deployment is
+// rolling out. Returns nil if the function is not found.
+func ScaleFunction(namespace *Namespace, functionName string, replicas int64, maxReplicas int64) (*ScaleResult, error) {
+	if replicas < 0 {
+		return nil, newValidationError("replicas must be no less than 0, got %d", replicas)
+	}
+
+	if replicas > maxReplicas {
+		return nil, newValidationError("replicas must be no more than the provider limit of %d, got %d", maxReplicas, replicas)
+	}
+
+	service, err := describeFunctionService(namespace, functionName)
+	if err != nil || service == nil {
+		return nil, err
+	}
+
+	if len(service.Deployments) > 1 {
+		return nil, newConflictError("function %s is rolling out a deployment, scale it once the rollout completes", functionName)
+	}
+
+	output, err := ecsClient.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{TaskDefinition: service.TaskDefinition})
+	if err != nil {
+		return nil, fmt.Errorf("error describing task definition %s. %v", aws.StringValue(service.TaskDefinition), err)
+	}
+
+	labels := labelsFromContainer(FunctionContainer(output.TaskDefinition))
+	settings, err := newScalingSettings(labels)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ScaleResult{Function: functionName, Requested: replicas, Replicas: replicas}
+	_, hasMax := (*labels)[scaleMaxLabel]
+	switch {
+	case replicas > 0 && replicas < settings.min:
+		result.Replicas = settings.min
+		result.Reason = fmt.Sprintf("raised to the minimum of %d set by %s", settings.min, scaleMinLabel)
+	case hasMax && replicas > settings.max:
+		result.Replicas = settings.max
+		result.Reason = fmt.Sprintf("lowered to the maximum of %d set by %s", settings.max, scaleMaxLabel)
+	}
+
+	if result.Reason != "" {
+		log.Infof("Scaling %s to %d replicas rather than the %d requested, %s",
+			functionName, result.Replicas, replicas, result.Reason)
+	}
+
+	if _, err := UpdateECSServiceDesiredCount(namespace, functionName, int(result.Replicas)); err != nil {
+		return nil, fmt.Errorf("error scaling %s to %d replicas. %v", functionName, result.Replicas, err)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,82 @@
+package aws
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func Test_ScaleFunction_Invalid(t *testing.T) {
+	namespace := &Namespace{Name: "default", isDefault: true}
+	for _, replicas := range []int64{-1, 101} {
+		_, err := ScaleFunction(namespace, "echo", replicas, 100)
+		if _, ok := err.(*ValidationError); !ok {
+			t.Errorf("Want validation error scaling to %d, got %v", replicas, err)
+		}
+	}
+}
+
+func Test_ScaleFunction_Clamped(t *testing.T) {
+	withStubbedAPI(t, func(api *stubAPI) {
+		stubbed := stubFunctionService(api, DefaultNamespace(), "echo", map[string]string{scaleMinLabel: "2", scaleMaxLabel: "5"})
+
+		cases := []struct {
+			requested int64
+			want      int64
+			clamped   bool
+		}{
+			{1, 2, true},
+			{3, 3, false},
+			{8, 5, true},
+			{0, 0, false},
+		}
+
+		for _, c := range cases {
+			result, err := ScaleFunction(DefaultNamespace(), "echo", c.requested, 100)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if result.Requested != c.requested || result.Replicas != c.want || (len(result.Reason) > 0) != c.clamped {
+				t.Errorf("Want %d replicas scaling to %d, got %+v", c.want, c.requested, result)
+			}
+
+			if desired := aws.Int64Value(stubbed.service.DesiredCount); desired != c.want {
+				t.Errorf("Want the desired count set to %d, got %d", c.want, desired)
+			}
+		}
+	})
+}
+
+func Test_ScaleFunction_NotFound(t *testing.T) {
+	withStubbedAPI(t, func(api *stubAPI) {
+		stubFunctionService(api, DefaultNamespace(), "echo", nil)
+
+		result, err := ScaleFunction(DefaultNamespace(), "figlet", 2, 100)
+		if result != nil || err != nil {
+			t.Errorf("Want nil for a function which is not found, got %+v %v", result, err)
+		}
+
+		if api.called("UpdateService") {
+			t.Error("Want no service updated")
+		}
+	})
+}
+
+func Test_ScaleFunction_RollingOut(t *testing.T) {
+	withStubbedAPI(t, func(api *stubAPI) {
+		stubbed := stubFunctionService(api, DefaultNamespace(), "echo", nil)
+		stubbed.service.Deployments = append(stubbed.service.Deployments,
+			&ecs.Deployment{Status: aws.String("ACTIVE"), TaskDefinition: aws.String("openfaas-echo:0")})
+
+		_, err := ScaleFunction(DefaultNamespace(), "echo", 2, 100)
+		if _, ok := err.(*ConflictError); !ok {
+			t.Errorf("Want a conflict error scaling during a rollout, got %v", err)
+		}
+
+		if api.called("UpdateService") {
+			t.Error("Want no service updated during a rollout")
+		}
+	})
+}
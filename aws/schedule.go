@@ -0,0 +1,477 @@
+package aws
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	scaleScheduleLabel         = "com.openfaas.scale.schedule"
+	scaleScheduleTimeZoneLabel = "com.openfaas.scale.schedule-timezone"
+
+	// scheduleCheckInterval is how often due scheduled actions are looked for, more often than the minute a cron
+	// expression resolves to
+	scheduleCheckInterval = 30 * time.Second
+
+	// maxUpcomingActions is the number of upcoming scheduled actions reported for a function
+	maxUpcomingActions = 10
+
+	// scheduleLookback is how far back the action in effect is looked for when a schedule is first read
+	scheduleLookback = 7 * 24 * time.Hour
+)
+
+// scaleSchedule is the scheduled scaling of a function, read from the com.openfaas.scale.schedule label. The label
+// is a semicolon separated list of cron expressions, each followed by = and the replicas to scale to, for example
+// "0 8 * * MON-FRI=3; 0 19 * * *=0". Times are in the com.openfaas.scale.schedule-timezone, UTC by default.
+type scaleSchedule struct {
+	raw      string
+	location *time.Location
+	entries  []scheduleEntry
+	// max is the most replicas the schedule may scale to, set by the com.openfaas.scale.max label or the provider
+	// wide maxReplicas, zero when neither limits it
+	max       int64
+	maxSource string
+}
+
+type scheduleEntry struct {
+	raw      string
+	cron     *cronExpression
+	replicas int64
+}
+
+// ScheduledAction is a time a function is scaled by its schedule
+type ScheduledAction struct {
+	Time     time.Time `json:"time"`
+	Replicas int64     `json:"replicas"`
+	Cron     string    `json:"cron"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// ManualScale is a scale request made since the last scheduled action, it holds until the next one
+type ManualScale struct {
+	Replicas int64      `json:"replicas"`
+	Time     time.Time  `json:"time"`
+	Until    *time.Time `json:"until,omitempty"`
+}
+
+// ScheduleStatus is the scheduled scaling of a function
+type ScheduleStatus struct {
+	Function    string            `json:"function"`
+	Schedule    string            `json:"schedule"`
+	TimeZone    string            `json:"timeZone"`
+	Upcoming    []ScheduledAction `json:"upcoming"`
+	LastApplied *ScheduledAction  `json:"lastApplied,omitempty"`
+	Manual      *ManualScale      `json:"manual,omitempty"`
+}
+
+// newScaleSchedule reads the scaling schedule from the labels, or nil if the function has none. Replicas are not
+// checked against the max of the schedule, which validate does for a deploy.
+func newScaleSchedule(labels *map[string]string, maxReplicas int64) (*scaleSchedule, error) {
+	if labels == nil {
+		return nil, nil
+	}
+
+	raw, exists := (*labels)[scaleScheduleLabel]
+	if !exists {
+		return nil, nil
+	}
+
+	schedule := &scaleSchedule{raw: raw, location: time.UTC}
+	if zone, exists := (*labels)[scaleScheduleTimeZoneLabel]; exists {
+		location, err := time.LoadLocation(strings.TrimSpace(zone))
+		if err != nil {
+			return nil, newValidationError("label %s must be a time zone such as Europe/London, got %s",
+				scaleScheduleTimeZoneLabel, zone)
+		}
+
+		schedule.location = location
+	}
+
+	for _, item := range strings.Split(raw, ";") {
+		if len(strings.TrimSpace(item)) == 0 {
+			continue
+		}
+
+		i := strings.LastIndex(item, "=")
+		if i < 0 {
+			return nil, newValidationError("label %s entry %q must be a cron expression followed by =replicas",
+				scaleScheduleLabel, strings.TrimSpace(item))
+		}
+
+		cron, err := parseCron(item[:i])
+		if err != nil {
+			return nil, newValidationError("label %s is invalid. %v", scaleScheduleLabel, err)
+		}
+
+		replicas, err := strconv.ParseInt(strings.TrimSpace(item[i+1:]), 10, 64)
+		if err != nil || replicas < 0 {
+			return nil, newValidationError("label %s entry %q must scale to a number of replicas no less than 0",
+				scaleScheduleLabel, strings.TrimSpace(item))
+		}
+
+		schedule.entries = append(schedule.entries,
+			scheduleEntry{raw: strings.TrimSpace(item), cron: cron, replicas: replicas})
+	}
+
+	if len(schedule.entries) == 0 {
+		return nil, newValidationError("label %s must have at least one entry", scaleScheduleLabel)
+	}
+
+	if maxReplicas > 0 {
+		schedule.max = maxReplicas
+		schedule.maxSource = "the provider limit"
+	}
+
+	// the max label itself is validated by newScalingSettings
+	if raw, exists := (*labels)[scaleMaxLabel]; exists {
+		max, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+		if err == nil && max >= 0 && (schedule.max == 0 || max < schedule.max) {
+			schedule.max = max
+			schedule.maxSource = "label " + scaleMaxLabel
+		}
+	}
+
+	return schedule, nil
+}
+
+// validate rejects entries scaling to more replicas than the max of the schedule
+func (s *scaleSchedule) validate() error {
+	for _, entry := range s.entries {
+		if s.max > 0 && entry.replicas > s.max {
+			return newValidationError("label %s entry %q must scale to no more than the maximum of %d set by %s",
+				scaleScheduleLabel, entry.raw, s.max, s.maxSource)
+		}
+	}
+
+	return nil
+}
+
+// bounded returns the replicas kept within the max of the schedule
+func (s *scaleSchedule) bounded(replicas int64) int64 {
+	if s.max > 0 && replicas > s.max {
+		return s.max
+	}
+
+	return replicas
+}
+
+// upcoming returns the next scheduled actions after the given time, soonest first
+func (s *scaleSchedule) upcoming(after time.Time, count int) []ScheduledAction {
+	next := make([]time.Time, len(s.entries))
+	for i, entry := range s.entries {
+		next[i] = entry.cron.next(after.In(s.location))
+	}
+
+	var result []ScheduledAction
+	for len(result) < count {
+		soonest := -1
+		for i := range s.entries {
+			if !next[i].IsZero() && (soonest < 0 || next[i].Before(next[soonest])) {
+				soonest = i
+			}
+		}
+
+		if soonest < 0 {
+			break
+		}
+
+		entry := s.entries[soonest]
+		result = append(result, ScheduledAction{Time: next[soonest], Replicas: entry.replicas, Cron: entry.cron.raw})
+		next[soonest] = entry.cron.next(next[soonest])
+	}
+
+	return result
+}
+
+// due returns the latest scheduled action after since and no later than now, or nil if there is none. When two
+// entries fall due at the same time the later entry wins.
+func (s *scaleSchedule) due(since time.Time, now time.Time) *ScheduledAction {
+	var result *ScheduledAction
+	for _, entry := range s.entries {
+		for next := entry.cron.next(since.In(s.location)); !next.IsZero() && !next.After(now); next = entry.cron.next(next) {
+			if result == nil || !next.Before(result.Time) {
+				result = &ScheduledAction{Time: next, Replicas: entry.replicas, Cron: entry.cron.raw}
+			}
+		}
+	}
+
+	return result
+}
+
+// ScaleScheduler applies the scaling schedules of functions. An action is applied when it falls due; actions due
+// while the provider was not running are skipped. A manual scale holds until the next scheduled action. Replicas
+// are kept within the com.openfaas.scale.max label and the provider wide maxReplicas.
+//
+// A function its schedule has scaled to zero is still woken by a request, and is scaled back to zero by the
+// RequestAutoscaler once it has been idle, see ScaledToZero.
+type ScaleScheduler struct {
+	lock        *sync.Mutex
+	functions   map[string]*scheduledFunction
+	maxReplicas int64
+}
+
+type scheduledFunction struct {
+	// checked is when due actions were last looked for
+	checked        time.Time
+	taskDefinition string
+	schedule       *scaleSchedule
+	lastApplied    *ScheduledAction
+	// inEffect is the latest action due, whether or not it was applied by this provider
+	inEffect *ScheduledAction
+	manual   *ManualScale
+}
+
+// NewScaleScheduler creates a scheduler, which applies schedules once started
+func NewScaleScheduler(maxReplicas int64) *ScaleScheduler {
+	return &ScaleScheduler{lock: &sync.Mutex{}, functions: map[string]*scheduledFunction{}, maxReplicas: maxReplicas}
+}
+
+// Start applies the schedules of functions in the background
+func (s *ScaleScheduler) Start() {
+	go func() {
+		ticker := time.NewTicker(scheduleCheckInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			s.Apply(time.Now())
+		}
+	}()
+}
+
+// Apply scales the functions in every namespace with scheduled actions which have fallen due
+func (s *ScaleScheduler) Apply(now time.Time) {
+	live := map[string]bool{}
+	listed := true
+	for _, name := range Namespaces() {
+		namespace, err := GetNamespace(name)
+		if err != nil {
+			log.Errorf("Scheduler could not find namespace %s. %v", name, err)
+			listed = false
+			continue
+		}
+
+		services, err := describeFunctionServices(namespace)
+		if err != nil {
+			log.Errorf("Scheduler could not list functions in namespace %s. %v", name, err)
+			listed = false
+			continue
+		}
+
+		for _, service := range services {
+			functionName := namespace.ServiceNameForDisplay(service.ServiceName)
+			key := rolloutKey(namespace, functionName)
+			live[key] = true
+
+			if err := s.applyFunction(namespace, functionName, service, now); err != nil {
+				log.Errorf("Scheduler could not scale %s. %v", functionName, err)
+			}
+		}
+	}
+
+	// functions are only forgotten once every namespace has been listed, so a failed listing skips no actions
+	if !listed {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for key := range s.functions {
+		if !live[key] {
+			delete(s.functions, key)
+		}
+	}
+}
+
+func (s *ScaleScheduler) applyFunction(namespace *Namespace, functionName string, service *ecs.Service, now time.Time) error {
+	s.lock.Lock()
+	function, found := s.functions[rolloutKey(namespace, functionName)]
+	if !found {
+		function = &scheduledFunction{checked: now}
+		s.functions[rolloutKey(namespace, functionName)] = function
+	}
+	s.lock.Unlock()
+
+	if aws.StringValue(service.TaskDefinition) != function.taskDefinition {
+		schedule, err := functionSchedule(namespace, functionName, service, s.maxReplicas)
+		if err != nil {
+			return err
+		}
+
+		s.lock.Lock()
+		function.taskDefinition = aws.StringValue(service.TaskDefinition)
+		function.schedule = schedule
+		function.inEffect = nil
+		if schedule != nil {
+			function.inEffect = schedule.due(now.Add(-scheduleLookback), now)
+		}
+		s.lock.Unlock()
+	}
+
+	if function.schedule == nil {
+		return nil
+	}
+
+	action := function.schedule.due(function.checked, now)
+	function.checked = now
+	if action == nil {
+		return nil
+	}
+
+	// functions deployed before the max was checked may have a schedule above it
+	if bounded := function.schedule.bounded(action.Replicas); bounded != action.Replicas {
+		log.Warnf("Scheduled scaling of %s to %d replicas lowered to the maximum of %d set by %s",
+			functionName, action.Replicas, bounded, function.schedule.maxSource)
+		action.Replicas = bounded
+	}
+
+	current := aws.Int64Value(service.DesiredCount)
+	if current != action.Replicas {
+		if _, err := UpdateECSServiceDesiredCount(namespace, functionName, int(action.Replicas)); err != nil {
+			action.Error = err.Error()
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"function": functionName,
+		"cron":     action.Cron,
+		"from":     current,
+		"to":       action.Replicas,
+	}).Infof("Applied scheduled scaling due at %s", action.Time.Format(time.RFC3339))
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	function.lastApplied = action
+	function.inEffect = action
+	function.manual = nil
+	return nil
+}
+
+// ScaledToZero returns true when the latest scheduled action of the function scaled it to zero and no manual scale
+// has been made since
+func (s *ScaleScheduler) ScaledToZero(namespace *Namespace, functionName string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	function, found := s.functions[rolloutKey(namespace, functionName)]
+	return found && function.schedule != nil && function.manual == nil &&
+		function.inEffect != nil && function.inEffect.Replicas == 0
+}
+
+// functionSchedule returns the scaling schedule of the function running as the service, or nil if it has none or
+// the service is not a function owned by this installation
+func functionSchedule(
+	namespace *Namespace,
+	functionName string,
+	service *ecs.Service,
+	maxReplicas int64) (*scaleSchedule, error) {
+
+	output, err := ecsClient.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{TaskDefinition: service.TaskDefinition})
+	if err != nil {
+		return nil, fmt.Errorf("error describing task definition %s. %v", aws.StringValue(service.TaskDefinition), err)
+	}
+
+	container := FunctionContainer(output.TaskDefinition)
+	if !namespace.isOwnedFunction(container, functionName) {
+		return nil, nil
+	}
+
+	return newScaleSchedule(labelsFromContainer(container), maxReplicas)
+}
+
+// describeFunctionServices returns the active ECS services of functions in the namespace
+func describeFunctionServices(namespace *Namespace) ([]*ecs.Service, error) {
+	arns, err := getServices(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []*string
+	for _, item := range arns {
+		if namespace.IsFaasService(item) {
+			names = append(names, ServiceNameFromArn(item))
+		}
+	}
+
+	var result []*ecs.Service
+	for len(names) > 0 {
+		describe := names
+		if len(describe) > 10 {
+			describe = names[:10]
+		}
+		names = names[len(describe):]
+
+		details, err := ecsClient.DescribeServices(&ecs.DescribeServicesInput{Services: describe, Cluster: namespace.ClusterID()})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range details.Services {
+			if aws.StringValue(item.Status) == "ACTIVE" {
+				result = append(result, item)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// ManualScale records a scale request for a function, which holds until its next scheduled action. Returns the time
+// of that action, or nil if the function has no schedule.
+func (s *ScaleScheduler) ManualScale(namespace *Namespace, functionName string, replicas int64) *time.Time {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	function, found := s.functions[rolloutKey(namespace, functionName)]
+	if !found || function.schedule == nil {
+		return nil
+	}
+
+	now := time.Now()
+	function.manual = &ManualScale{Replicas: replicas, Time: now}
+	if upcoming := function.schedule.upcoming(now, 1); len(upcoming) > 0 {
+		function.manual.Until = &upcoming[0].Time
+	}
+
+	return function.manual.Until
+}
+
+// Status returns the schedule of the function with its upcoming actions, or nil if no function is found
+func (s *ScaleScheduler) Status(namespace *Namespace, functionName string) (*ScheduleStatus, error) {
+	service, err := describeFunctionService(namespace, functionName)
+	if err != nil || service == nil {
+		return nil, err
+	}
+
+	schedule, err := functionSchedule(namespace, functionName, service, s.maxReplicas)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &ScheduleStatus{Function: functionName, Upcoming: []ScheduledAction{}}
+	if schedule == nil {
+		return status, nil
+	}
+
+	status.Schedule = schedule.raw
+	status.TimeZone = schedule.location.String()
+	status.Upcoming = schedule.upcoming(time.Now(), maxUpcomingActions)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if function, found := s.functions[rolloutKey(namespace, functionName)]; found {
+		status.LastApplied = function.lastApplied
+		status.Manual = function.manual
+	}
+
+	return status, nil
+}
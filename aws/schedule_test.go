@@ -0,0 +1,185 @@
+package aws
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/openfaas/faas/gateway/requests"
+)
+
+func Test_ScaleSchedule_Labels(t *testing.T) {
+	schedule, err := newScaleSchedule(&map[string]string{scaleScheduleLabel: "0 8 * * MON-FRI=3; 0 19 * * *=0"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(schedule.entries) != 2 || schedule.entries[0].replicas != 3 || schedule.entries[1].replicas != 0 {
+		t.Errorf("Unexpected entries %+v", schedule.entries)
+	}
+
+	if schedule.location != time.UTC {
+		t.Errorf("Want schedule in UTC by default, got %s", schedule.location)
+	}
+
+	if schedule, err := newScaleSchedule(&map[string]string{}, 0); schedule != nil || err != nil {
+		t.Errorf("Want no schedule without the label, got %+v %v", schedule, err)
+	}
+}
+
+func Test_ScaleSchedule_Invalid(t *testing.T) {
+	for _, labels := range []map[string]string{
+		{scaleScheduleLabel: "0 8 * * MON-FRI"},
+		{scaleScheduleLabel: "0 8 * * MON-FRI=-1"},
+		{scaleScheduleLabel: "0 8 * *=3"},
+		{scaleScheduleLabel: " ; "},
+		{scaleScheduleLabel: "0 8 * * *=1", scaleScheduleTimeZoneLabel: "Nowhere/Special"},
+	} {
+		_, err := newScaleSchedule(&labels, 0)
+		if _, ok := err.(*ValidationError); !ok {
+			t.Errorf("Want validation error for %v, got %v", labels, err)
+		}
+	}
+}
+
+func Test_ScaleSchedule_Upcoming(t *testing.T) {
+	schedule, _ := newScaleSchedule(&map[string]string{scaleScheduleLabel: "0 8 * * MON-FRI=3; 0 19 * * *=0"}, 0)
+
+	// a friday evening
+	upcoming := schedule.upcoming(time.Date(2018, time.June, 15, 20, 0, 0, 0, time.UTC), 4)
+
+	want := []ScheduledAction{
+		{Time: time.Date(2018, time.June, 16, 19, 0, 0, 0, time.UTC), Replicas: 0, Cron: "0 19 * * *"},
+		{Time: time.Date(2018, time.June, 17, 19, 0, 0, 0, time.UTC), Replicas: 0, Cron: "0 19 * * *"},
+		{Time: time.Date(2018, time.June, 18, 8, 0, 0, 0, time.UTC), Replicas: 3, Cron: "0 8 * * MON-FRI"},
+		{Time: time.Date(2018, time.June, 18, 19, 0, 0, 0, time.UTC), Replicas: 0, Cron: "0 19 * * *"},
+	}
+
+	if len(upcoming) != len(want) {
+		t.Fatalf("Want %d actions, got %+v", len(want), upcoming)
+	}
+
+	for i := range want {
+		if !upcoming[i].Time.Equal(want[i].Time) || upcoming[i].Replicas != want[i].Replicas || upcoming[i].Cron != want[i].Cron {
+			t.Errorf("Want action %+v, got %+v", want[i], upcoming[i])
+		}
+	}
+}
+
+func Test_ScaleSchedule_Due(t *testing.T) {
+	schedule, _ := newScaleSchedule(&map[string]string{scaleScheduleLabel: "0 8 * * *=3; 0 8 * * MON=5; 0 19 * * *=0"}, 0)
+	monday := time.Date(2018, time.June, 18, 0, 0, 0, 0, time.UTC)
+
+	if action := schedule.due(monday.Add(7*time.Hour), monday.Add(7*time.Hour+59*time.Minute)); action != nil {
+		t.Errorf("Want nothing due before 8, got %+v", action)
+	}
+
+	// both morning entries are due at 8, the later one wins
+	action := schedule.due(monday.Add(7*time.Hour+59*time.Minute), monday.Add(8*time.Hour))
+	if action == nil || action.Replicas != 5 {
+		t.Errorf("Want 5 replicas due at 8 on a monday, got %+v", action)
+	}
+
+	// across a whole day only the latest action applies
+	action = schedule.due(monday, monday.Add(20*time.Hour))
+	if action == nil || action.Replicas != 0 {
+		t.Errorf("Want 0 replicas due at 19, got %+v", action)
+	}
+}
+
+func Test_ScaleScheduler_ManualScale(t *testing.T) {
+	namespace := &Namespace{Name: "default", isDefault: true}
+	schedule, _ := newScaleSchedule(&map[string]string{scaleScheduleLabel: "0 19 * * *=0"}, 0)
+
+	scheduler := NewScaleScheduler(100)
+	if until := scheduler.ManualScale(namespace, "echo", 2); until != nil {
+		t.Errorf("Want no hold for a function without a schedule, got %s", until)
+	}
+
+	scheduler.functions[rolloutKey(namespace, "echo")] = &scheduledFunction{schedule: schedule}
+	until := scheduler.ManualScale(namespace, "echo", 2)
+	if until == nil || until.Hour() != 19 || until.Minute() != 0 {
+		t.Errorf("Want the manual scale held until 19:00, got %v", until)
+	}
+}
+
+func Test_ScaleSchedule_Max(t *testing.T) {
+	schedule, _ := newScaleSchedule(&map[string]string{scaleScheduleLabel: "0 8 * * *=20; 0 19 * * *=0"}, 100)
+	if err := schedule.validate(); err != nil {
+		t.Errorf("Want schedule within the provider limit accepted, got %v", err)
+	}
+
+	for _, labels := range []map[string]string{
+		{scaleScheduleLabel: "0 8 * * *=20; 0 19 * * *=0", scaleMaxLabel: "10"},
+		{scaleScheduleLabel: "0 8 * * *=200; 0 19 * * *=0"},
+	} {
+		schedule, _ := newScaleSchedule(&labels, 100)
+		if _, ok := schedule.validate().(*ValidationError); !ok {
+			t.Errorf("Want validation error for %v", labels)
+		}
+	}
+
+	schedule, _ = newScaleSchedule(&map[string]string{scaleScheduleLabel: "0 8 * * *=20", scaleMaxLabel: "10"}, 100)
+	if bounded := schedule.bounded(20); bounded != 10 {
+		t.Errorf("Want scheduled replicas lowered to the max of 10, got %d", bounded)
+	}
+}
+
+func Test_ScaleScheduler_ScaledToZero(t *testing.T) {
+	namespace := &Namespace{Name: "default", isDefault: true}
+	schedule, _ := newScaleSchedule(&map[string]string{scaleScheduleLabel: "0 8 * * *=3; 0 19 * * *=0"}, 0)
+	evening := time.Date(2018, time.June, 15, 20, 0, 0, 0, time.UTC)
+
+	scheduler := NewScaleScheduler(100)
+	if scheduler.ScaledToZero(namespace, "echo") {
+		t.Error("Want a function without a schedule not scaled to zero")
+	}
+
+	function := &scheduledFunction{schedule: schedule, inEffect: schedule.due(evening.Add(-scheduleLookback), evening)}
+	scheduler.functions[rolloutKey(namespace, "echo")] = function
+	if !scheduler.ScaledToZero(namespace, "echo") {
+		t.Errorf("Want the function scaled to zero by the action at 19:00, in effect %+v", function.inEffect)
+	}
+
+	function.manual = &ManualScale{Replicas: 2}
+	if scheduler.ScaledToZero(namespace, "echo") {
+		t.Error("Want a manual scale to hold over the scheduled zero")
+	}
+}
+
+func Test_ScaleSchedule_Autoscaling(t *testing.T) {
+	schedule := "0 8 * * *=3; 0 19 * * *=0"
+	settings, err := newScalingSettings(&map[string]string{scaleScheduleLabel: schedule, scaleMaxLabel: "10"})
+	if err != nil || settings.enabled || settings.max != 10 {
+		t.Errorf("Want %s to only bound a schedule, got %+v %v", scaleMaxLabel, settings, err)
+	}
+
+	settings, err = newScalingSettings(&map[string]string{scaleScheduleLabel: schedule, scaleTypeLabel: scaleTypeConcurrency})
+	if err != nil || !settings.enabled {
+		t.Errorf("Want a schedule allowed with concurrency scaling, got %+v %v", settings, err)
+	}
+
+	for _, scaleType := range []string{scaleTypeCPU, scaleTypeMemory} {
+		_, err := newScalingSettings(&map[string]string{scaleScheduleLabel: schedule, scaleTypeLabel: scaleType})
+		if _, ok := err.(*ValidationError); !ok {
+			t.Errorf("Want validation error for a schedule with %s=%s, got %v", scaleTypeLabel, scaleType, err)
+		}
+	}
+}
+
+func Test_UpdateServiceInput_Scheduled(t *testing.T) {
+	existing := &ecs.Service{ServiceArn: aws.String("arn:aws:ecs:us-east-1:123456789012:service/openfaas-echo")}
+
+	request := requests.CreateFunctionRequest{Service: "echo", Labels: &map[string]string{scaleMinLabel: "2"}}
+	input := updateServiceInput(DefaultNamespace(), existing, aws.String("echo:2"), request)
+	if aws.Int64Value(input.DesiredCount) != 2 {
+		t.Errorf("Want an unscheduled function redeployed at its min of 2, got %v", input.DesiredCount)
+	}
+
+	(*request.Labels)[scaleScheduleLabel] = "0 8 * * *=3; 0 19 * * *=0"
+	input = updateServiceInput(DefaultNamespace(), existing, aws.String("echo:2"), request)
+	if input.DesiredCount != nil {
+		t.Errorf("Want a scheduled function to keep its desired count, got %d", aws.Int64Value(input.DesiredCount))
+	}
+}
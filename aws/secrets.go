@@ -24,7 +24,7 @@ func getSecretsID(names []string) ([]string, error) {
 	var result []string
 
 	for _, v := range names {
-		name := fmt.Sprintf("%s%s", servicePrefix, v)
+		name := fmt.Sprintf("%s%s", secretPrefix, v)
 		output, err := secretsClient.DescribeSecret(&secretsmanager.DescribeSecretInput{
 			SecretId: aws.String(name),
 		})
@@ -9,14 +9,13 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/servicediscovery"
 	"github.com/ewilde/faas-fargate/system"
 	"github.com/ewilde/faas-fargate/types"
 	"github.com/openfaas/faas/gateway/requests"
 	log "github.com/sirupsen/logrus"
 )
 
-const servicePrefix = "openfaas-"
-
 var clusterID string
 var subnetsFunc = &sync.Once{}
 var subnets []*string
@@ -25,68 +24,173 @@ func init() {
 	clusterID = system.GetEnv("cluster_name", "openfaas")
 }
 
-// FindECSServiceArn based on the serviceName finds a matching service, returning it's arn.
-func FindECSServiceArn(serviceName string) (*string, error) {
-	services, err := ecsClient.ListServices(&ecs.ListServicesInput{
-		Cluster: ClusterID(),
-	})
-
-	if err != nil {
+// FindECSServiceArn finds the service running the function with exactly the supplied name which is owned by this
+// installation, returning it's arn or nil if there is no such service.
+func FindECSServiceArn(namespace *Namespace, functionName string) (*string, error) {
+	service, err := describeFunctionService(namespace, functionName)
+	if err != nil || service == nil {
 		return nil, err
 	}
 
-	var serviceArn *string
-	for _, item := range services.ServiceArns {
-		if strings.Contains(aws.StringValue(item), serviceName) {
-			serviceArn = item
-		}
-	}
-
-	return serviceArn, nil
+	return service.ServiceArn, nil
 }
 
 // UpdateOrCreateECSService either creates an new service or updates an existing one if matched based on the
 // service name in the request
 func UpdateOrCreateECSService(
+	namespace *Namespace,
+	taskDefinition *ecs.TaskDefinition,
+	request requests.CreateFunctionRequest,
+	cfg *types.DeployHandlerConfig) (*ecs.Service, error) {
+
+	return updateOrCreateECSService(newDeployment(request.Service), namespace, taskDefinition, request, cfg)
+}
+
+func updateOrCreateECSService(
+	d *deployment,
+	namespace *Namespace,
 	taskDefinition *ecs.TaskDefinition,
 	request requests.CreateFunctionRequest,
 	cfg *types.DeployHandlerConfig) (*ecs.Service, error) {
 
-	serviceArn, err := FindECSServiceArn(request.Service)
+	existing, err := describeFunctionService(namespace, request.Service)
 	if err != nil {
 		log.Errorln(fmt.Sprintf("Could not find service with name %s.", request.Service), err)
+		return nil, d.rollback(stepService, err)
+	}
+
+	if existing != nil {
+		return updateECSService(d, namespace, existing, taskDefinition.TaskDefinitionArn, request)
+	}
+
+	var registryArn string
+	err = d.run(stepServiceDiscovery, func() (undo undoFunc, err error) {
+		registryArn, undo, err = ensureServiceRegistrationExists(namespace, request.Service, cfg.VpcID)
+		if err != nil {
+			log.Errorln(fmt.Sprintf("Error creating registry for service %s. ", request.Service), err)
+		}
+
+		return undo, err
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	if serviceArn != nil {
-		service, err := ecsClient.UpdateService(&ecs.UpdateServiceInput{
-			Cluster:        ClusterID(),
-			Service:        serviceArn,
-			DesiredCount:   getMinReplicaCount(request.Labels),
-			TaskDefinition: taskDefinition.TaskDefinitionArn,
-		})
+	var service *ecs.Service
+	err = d.run(stepService, func() (undoFunc, error) {
+		var err error
+		service, err = createECSService(namespace, taskDefinition, request, cfg, registryArn)
+		if err != nil {
+			return nil, err
+		}
+
+		return func() error { return deleteECSService(namespace, service.ServiceArn) }, nil
+	})
+
+	return service, err
+}
+
+// updateECSService points the existing service at the task definition, applying the desired count and deployment
+// configuration of the request labels. The undo function restores the revision, count and configuration it had.
+func updateECSService(
+	d *deployment,
+	namespace *Namespace,
+	existing *ecs.Service,
+	taskDefinitionArn *string,
+	request requests.CreateFunctionRequest) (*ecs.Service, error) {
+
+	var service *ecs.Service
+	err := d.run(stepService, func() (undoFunc, error) {
+		output, err := ecsClient.UpdateService(updateServiceInput(namespace, existing, taskDefinitionArn, request))
 
 		if err != nil {
 			log.Errorln(fmt.Sprintf("Error updating service %s. ", request.Service), err)
 			return nil, err
 		}
 
-		return service.Service, err
-	}
+		service = output.Service
+		return func() error {
+			_, err := ecsClient.UpdateService(&ecs.UpdateServiceInput{
+				Cluster:                 namespace.ClusterID(),
+				Service:                 existing.ServiceArn,
+				DesiredCount:            existing.DesiredCount,
+				TaskDefinition:          existing.TaskDefinition,
+				DeploymentConfiguration: existing.DeploymentConfiguration,
+			})
+			return err
+		}, nil
+	})
+
+	return service, err
+}
+
+func createECSService(
+	namespace *Namespace,
+	taskDefinition *ecs.TaskDefinition,
+	request requests.CreateFunctionRequest,
+	cfg *types.DeployHandlerConfig,
+	registryArn string) (*ecs.Service, error) {
 
-	registryArn, err := ensureServiceRegistrationExists(request.Service, cfg.VpcID)
+	result, err := ecsClient.CreateService(createServiceInput(namespace, taskDefinition.TaskDefinitionArn, request, cfg, registryArn))
 	if err != nil {
-		log.Errorln(fmt.Sprintf("Error creating registry for service %s. ", request.Service), err)
+		log.Errorln(fmt.Sprintf("Error creating service %s. Using subnets from configuration: %s",
+			request.Service, cfg.SubnetIDs), err)
 		return nil, err
 	}
 
+	return result.Service, nil
+}
+
+// updateServiceInput returns the request pointing the existing service at the task definition
+func updateServiceInput(
+	namespace *Namespace,
+	existing *ecs.Service,
+	taskDefinitionArn *string,
+	request requests.CreateFunctionRequest) *ecs.UpdateServiceInput {
+
+	// the rollout and scaling labels were validated when the task definition was rendered
+	settings, _ := newRolloutSettings(request.Labels)
+	input := &ecs.UpdateServiceInput{
+		Cluster:                 namespace.ClusterID(),
+		Service:                 existing.ServiceArn,
+		DesiredCount:            getMinReplicaCount(request.Labels),
+		TaskDefinition:          taskDefinitionArn,
+		DeploymentConfiguration: settings.updateDeploymentConfiguration(),
+	}
+
+	// an autoscaled service keeps its desired count, registering the scalable target brings it within min and max
+	if scaling, _ := newScalingSettings(request.Labels); scaling != nil && scaling.enabled {
+		input.DesiredCount = nil
+	}
+
+	// a scheduled service keeps the desired count its schedule set
+	if request.Labels != nil {
+		if _, scheduled := (*request.Labels)[scaleScheduleLabel]; scheduled {
+			input.DesiredCount = nil
+		}
+	}
+
+	return input
+}
+
+// createServiceInput returns the request creating the service running the function
+func createServiceInput(
+	namespace *Namespace,
+	taskDefinitionArn *string,
+	request requests.CreateFunctionRequest,
+	cfg *types.DeployHandlerConfig,
+	registryArn string) *ecs.CreateServiceInput {
+
+	settings, _ := newRolloutSettings(request.Labels)
+
 	// see: https://docs.aws.amazon.com/cli/latest/reference/ecs/create-service.html
-	result, err := ecsClient.CreateService(&ecs.CreateServiceInput{
-		Cluster:        ClusterID(),
-		ServiceName:    aws.String(ServiceNameFromFunctionName(request.Service)),
-		TaskDefinition: taskDefinition.TaskDefinitionArn,
-		LaunchType:     aws.String("FARGATE"),
-		DesiredCount:   getMinReplicaCount(request.Labels),
+	return &ecs.CreateServiceInput{
+		Cluster:                 namespace.ClusterID(),
+		ServiceName:             aws.String(namespace.ServiceNameFromFunctionName(request.Service)),
+		TaskDefinition:          taskDefinitionArn,
+		LaunchType:              aws.String("FARGATE"),
+		DesiredCount:            getMinReplicaCount(request.Labels),
+		DeploymentConfiguration: settings.deploymentConfiguration(),
 		NetworkConfiguration: &ecs.NetworkConfiguration{
 			AwsvpcConfiguration: &ecs.AwsVpcConfiguration{
 				AssignPublicIp: aws.String(cfg.AssignPublicIP),
@@ -99,22 +203,34 @@ func UpdateOrCreateECSService(
 				RegistryArn: aws.String(registryArn),
 			},
 		},
+	}
+}
+
+// deleteECSService stops and deletes the service without removing the other resources of the function
+func deleteECSService(namespace *Namespace, serviceArn *string) error {
+	_, err := ecsClient.UpdateService(&ecs.UpdateServiceInput{
+		Cluster:      namespace.ClusterID(),
+		Service:      serviceArn,
+		DesiredCount: aws.Int64(0),
 	})
+	if err != nil {
+		return fmt.Errorf("error stopping service %s. %v", aws.StringValue(serviceArn), err)
+	}
 
+	_, err = ecsClient.DeleteService(&ecs.DeleteServiceInput{Cluster: namespace.ClusterID(), Service: serviceArn})
 	if err != nil {
-		log.Errorln(fmt.Sprintf("Error creating service %s. Using subnets from configuration: %s",
-			request.Service, cfg.SubnetIDs), err)
-		return nil, err
+		return fmt.Errorf("error deleting service %s. %v", aws.StringValue(serviceArn), err)
 	}
 
-	return result.Service, nil
+	return nil
 }
 
 // DeleteECSService remove the service with the supplied name
 func DeleteECSService(
+	namespace *Namespace,
 	serviceName string,
 	cfg *types.DeployHandlerConfig) error {
-	serviceArn, err := FindECSServiceArn(serviceName)
+	serviceArn, err := FindECSServiceArn(namespace, serviceName)
 	if err != nil {
 		return fmt.Errorf("could not find service matching %s. %v", serviceName, err)
 	}
@@ -123,34 +239,42 @@ func DeleteECSService(
 		return fmt.Errorf("can not delete a function, no function found matching %s", serviceName)
 	}
 
-	services, err := ecsClient.DescribeServices(&ecs.DescribeServicesInput{Cluster: ClusterID(), Services: []*string{serviceArn}})
+	if err := deleteCanary(namespace, serviceName); err != nil {
+		log.Errorf("error deleting canary of %s. %v", serviceName, err)
+	}
+
+	if err := removeAutoscaling(namespace, serviceName); err != nil {
+		log.Errorf("error removing autoscaling of %s. %v", serviceName, err)
+	}
+
+	services, err := ecsClient.DescribeServices(&ecs.DescribeServicesInput{Cluster: namespace.ClusterID(), Services: []*string{serviceArn}})
 	if err != nil {
 		return fmt.Errorf("could not describe service %s. %v", aws.StringValue(serviceArn), err)
 	}
 
 	if *services.Services[0].DesiredCount > 0 {
 		ecsClient.UpdateService(&ecs.UpdateServiceInput{
-			Cluster:      ClusterID(),
+			Cluster:      namespace.ClusterID(),
 			Service:      serviceArn,
 			DesiredCount: aws.Int64(0)})
 	}
 
 	// do this async it takes quite a long time
 	go func() {
-		err = deleteServiceRegistration(serviceName, cfg.VpcID)
+		err = deleteServiceRegistration(namespace, serviceName, cfg.VpcID)
 		if err != nil {
 			log.Errorf("error deleting service discovery registration for %s. %v", serviceName, err)
 		}
 	}()
 
-	result, err := ecsClient.DeleteService(&ecs.DeleteServiceInput{Cluster: ClusterID(), Service: serviceArn})
+	result, err := ecsClient.DeleteService(&ecs.DeleteServiceInput{Cluster: namespace.ClusterID(), Service: serviceArn})
 	if err != nil {
 		return fmt.Errorf("error deleting service %s arn: %s. %v", serviceName, aws.StringValue(serviceArn), err)
 	}
 
 	log.Infof("Successfully deleted service %s.", serviceName)
 
-	err = DeleteTaskRevision(serviceName)
+	err = DeleteTaskRevision(namespace, serviceName)
 	if err != nil {
 		return fmt.Errorf("error deleting task revision for service %s arn: %s. %v", serviceName, aws.StringValue(serviceArn), err)
 	}
@@ -161,10 +285,11 @@ func DeleteECSService(
 
 // UpdateECSServiceDesiredCount update the service desired count
 func UpdateECSServiceDesiredCount(
+	namespace *Namespace,
 	serviceName string,
 	desiredCount int) (*ecs.Service, error) {
 
-	serviceArn, err := FindECSServiceArn(serviceName)
+	serviceArn, err := FindECSServiceArn(namespace, serviceName)
 	if err != nil {
 		log.Errorln(fmt.Sprintf("could not find service with name %s.", serviceName), err)
 		return nil, err
@@ -175,7 +300,7 @@ func UpdateECSServiceDesiredCount(
 	}
 
 	service, err := ecsClient.UpdateService(&ecs.UpdateServiceInput{
-		Cluster:      ClusterID(),
+		Cluster:      namespace.ClusterID(),
 		Service:      serviceArn,
 		DesiredCount: aws.Int64(int64(desiredCount)),
 	})
@@ -187,23 +312,23 @@ func UpdateECSServiceDesiredCount(
 	return service.Service, nil
 }
 
-// ClusterID returns the configured cluster ID
+// ClusterID returns the configured cluster ID, used by namespaces not mapped to a cluster of their own
 func ClusterID() *string {
 	return aws.String(clusterID)
 }
 
-// GetServiceList returns the list of OpenFaas functions running
-func GetServiceList() ([]requests.Function, error) {
+// GetServiceList returns the list of OpenFaas functions running in the namespace
+func GetServiceList(namespace *Namespace) ([]requests.Function, error) {
 	var functions []requests.Function
 
-	services, err := getServices()
+	services, err := getServices(namespace)
 	if err != nil {
 		return nil, err
 	}
 
 	var serviceNames []*string
 	for _, item := range services {
-		if !IsFaasService(item) {
+		if !namespace.IsFaasService(item) {
 			continue
 		}
 
@@ -214,6 +339,11 @@ func GetServiceList() ([]requests.Function, error) {
 		return functions, nil
 	}
 
+	registrations, err := serviceRegistrations(namespace)
+	if err != nil {
+		return nil, err
+	}
+
 	for len(serviceNames) > 0 {
 		describe := serviceNames
 		if len(serviceNames) > 10 {
@@ -223,51 +353,102 @@ func GetServiceList() ([]requests.Function, error) {
 			serviceNames = serviceNames[len(serviceNames):]
 		}
 
-		details, err := ecsClient.DescribeServices(&ecs.DescribeServicesInput{Services: describe, Cluster: ClusterID()})
+		details, err := ecsClient.DescribeServices(&ecs.DescribeServicesInput{Services: describe, Cluster: namespace.ClusterID()})
 		if err != nil {
 			return nil, err
 		}
 
 		for _, item := range details.Services {
-			task, err := ecsClient.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{TaskDefinition: item.TaskDefinition})
+			function, _, err := functionFromService(namespace, item, registrations)
 			if err != nil {
 				return nil, err
 			}
 
-			function := requests.Function{
-				Name:              ServiceNameForDisplay(item.ServiceName),
-				Replicas:          uint64(*item.RunningCount),
-				Image:             aws.StringValue(task.TaskDefinition.ContainerDefinitions[0].Image),
-				AvailableReplicas: uint64(*item.DesiredCount), // TODO find out what this property relates to
-				InvocationCount:   0,
-				Labels:            nil,
+			if function == nil {
+				continue
 			}
 
-			functions = append(functions, function)
+			functions = append(functions, *function)
 		}
 	}
 
 	return functions, nil
 }
 
-// IsFaasService returns true if the service is an OpenFaaS function
-func IsFaasService(arn *string) bool {
-	return strings.Contains(aws.StringValue(arn), servicePrefix)
-}
+// GetFunction returns the OpenFaaS function and the status of its replicas, or nil if no function is found
+func GetFunction(namespace *Namespace, functionName string) (*requests.Function, *FunctionStatus, error) {
+	service, err := describeFunctionService(namespace, functionName)
+	if err != nil || service == nil {
+		return nil, nil, err
+	}
 
-// ServiceNameFromArn calculated the service name from the service arn
-func ServiceNameFromArn(arn *string) *string {
-	return aws.String(strings.Split(*arn, "/")[1])
+	return functionFromService(namespace, service, nil)
 }
 
-// ServiceNameForDisplay returns the service name shown to the user
-func ServiceNameForDisplay(name *string) string {
-	return strings.TrimPrefix(*name, servicePrefix)
+// describeFunctionService returns the active ECS service running the function, or nil if there is none or the
+// service is not owned by this installation
+func describeFunctionService(namespace *Namespace, functionName string) (*ecs.Service, error) {
+	details, err := ecsClient.DescribeServices(&ecs.DescribeServicesInput{
+		Cluster:  namespace.ClusterID(),
+		Services: []*string{aws.String(namespace.ServiceNameFromFunctionName(functionName))},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range details.Services {
+		if aws.StringValue(item.Status) != "ACTIVE" {
+			continue
+		}
+
+		task, err := ecsClient.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{TaskDefinition: item.TaskDefinition})
+		if err != nil {
+			return nil, err
+		}
+
+		if !namespace.isOwnedFunction(FunctionContainer(task.TaskDefinition), functionName) {
+			log.Warnf("Service %s is not owned by installation %s namespace %s",
+				aws.StringValue(item.ServiceName), installationID, namespace.Name)
+			return nil, nil
+		}
+
+		return item, nil
+	}
+
+	return nil, nil
 }
 
-// ServiceNameFromFunctionName returns the aws faargate service name based on the OpenFaaS function name
-func ServiceNameFromFunctionName(functionName string) string {
-	return servicePrefix + functionName
+// functionFromService returns the function running as the service, or nil if the service is not a function owned by
+// this installation. Registrations are the route 53 auto-naming services of the namespace, see getFunctionStatus.
+func functionFromService(
+	namespace *Namespace,
+	service *ecs.Service,
+	registrations map[string]*servicediscovery.ServiceSummary) (*requests.Function, *FunctionStatus, error) {
+
+	task, err := ecsClient.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{TaskDefinition: service.TaskDefinition})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	container := FunctionContainer(task.TaskDefinition)
+	if !namespace.isOwnedFunction(container, namespace.ServiceNameForDisplay(service.ServiceName)) {
+		return nil, nil, nil
+	}
+
+	status, err := getFunctionStatus(namespace, service, container.HealthCheck != nil, registrations)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &requests.Function{
+		Name:              namespace.ServiceNameForDisplay(service.ServiceName),
+		Replicas:          status.Running,
+		Image:             aws.StringValue(container.Image),
+		EnvProcess:        envProcessFromContainer(container),
+		AvailableReplicas: status.Available,
+		InvocationCount:   0,
+		Labels:            labelsFromContainer(container),
+	}, status, nil
 }
 
 func awsSubnet(client *ec2.EC2, subnetIds string, vpcID string) []*string {
@@ -302,14 +483,14 @@ func awsSubnet(client *ec2.EC2, subnetIds string, vpcID string) []*string {
 	return subnets
 }
 
-func getServices() ([]*string, error) {
+func getServices(namespace *Namespace) ([]*string, error) {
 	var result []*string
 	var next *string
 
 	for {
 		services, err := ecsClient.ListServices(
 			&ecs.ListServicesInput{
-				Cluster:   ClusterID(),
+				Cluster:   namespace.ClusterID(),
 				NextToken: next,
 			})
 		if err != nil {
@@ -7,7 +7,7 @@ import (
 )
 
 func Test_ServiceNameForDisplay(t *testing.T) {
-	serviceName := ServiceNameForDisplay(aws.String("openfaas-figlet"))
+	serviceName := DefaultNamespace().ServiceNameForDisplay(aws.String("openfaas-figlet"))
 	if serviceName != "figlet" {
 		t.Errorf("Expected figlet, actual %s", serviceName)
 		t.Fail()
@@ -16,7 +16,7 @@ func Test_ServiceNameForDisplay(t *testing.T) {
 
 func Test_ServiceList(t *testing.T) {
 	PreTest(t)
-	services, err := GetServiceList()
+	services, err := GetServiceList(DefaultNamespace())
 	if err != nil {
 		t.Error(err)
 	}
@@ -0,0 +1,137 @@
+package aws
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/servicediscovery"
+)
+
+// FunctionStatus describes the replicas of a function
+type FunctionStatus struct {
+	// Desired number of replicas requested from ECS
+	Desired uint64 `json:"desired"`
+	// Running number of replicas ECS reports as running
+	Running uint64 `json:"running"`
+	// Pending number of replicas ECS is starting
+	Pending uint64 `json:"pending"`
+	// Healthy number of running replicas passing their health check, or running if the function has no health check
+	Healthy uint64 `json:"healthy"`
+	// Registered number of replicas registered as healthy in route 53 auto-naming
+	Registered uint64 `json:"registered"`
+	// Available number of replicas that are running, healthy and registered, so able to receive invocations
+	Available uint64 `json:"available"`
+}
+
+// getFunctionStatus gathers the status of the tasks running for the service. Registrations holds the route 53
+// auto-naming services of the namespace when listing several functions, if nil the function's service is looked up.
+func getFunctionStatus(
+	namespace *Namespace,
+	service *ecs.Service,
+	hasHealthCheck bool,
+	registrations map[string]*servicediscovery.ServiceSummary) (*FunctionStatus, error) {
+
+	tasks, err := getServiceTasks(namespace, service.ServiceName, ecs.DesiredStatusRunning)
+	if err != nil {
+		return nil, err
+	}
+
+	functionName := namespace.ServiceNameForDisplay(service.ServiceName)
+	var registered map[string]bool
+	if registrations == nil {
+		registered, err = registeredHealthyInstances(namespace, functionName)
+	} else {
+		serviceName := namespace.DiscoveryNameFromFunctionName(functionName)
+		registered, err = healthyInstances(serviceName, registrations[serviceName])
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return newFunctionStatus(service, tasks, registered, hasHealthCheck), nil
+}
+
+func newFunctionStatus(
+	service *ecs.Service,
+	tasks []*ecs.Task,
+	registered map[string]bool,
+	hasHealthCheck bool) *FunctionStatus {
+
+	status := &FunctionStatus{
+		Desired:    uint64(aws.Int64Value(service.DesiredCount)),
+		Running:    uint64(aws.Int64Value(service.RunningCount)),
+		Pending:    uint64(aws.Int64Value(service.PendingCount)),
+		Registered: uint64(len(registered)),
+	}
+
+	for _, task := range tasks {
+		if aws.StringValue(task.LastStatus) != ecs.DesiredStatusRunning {
+			continue
+		}
+
+		healthy := aws.StringValue(task.HealthStatus) == ecs.HealthStatusHealthy
+		if !hasHealthCheck {
+			healthy = aws.StringValue(task.HealthStatus) != ecs.HealthStatusUnhealthy
+		}
+
+		if !healthy {
+			continue
+		}
+
+		status.Healthy++
+		if registered[TaskIDFromArn(task.TaskArn)] {
+			status.Available++
+		}
+	}
+
+	return status
+}
+
+// getServiceTasks returns the tasks of the service with the desired status
+func getServiceTasks(namespace *Namespace, serviceName *string, desiredStatus string) ([]*ecs.Task, error) {
+	var arns []*string
+	var next *string
+	for {
+		output, err := ecsClient.ListTasks(&ecs.ListTasksInput{
+			Cluster:       namespace.ClusterID(),
+			ServiceName:   serviceName,
+			DesiredStatus: aws.String(desiredStatus),
+			NextToken:     next,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error listing tasks for service %s. %v", aws.StringValue(serviceName), err)
+		}
+
+		arns = append(arns, output.TaskArns...)
+		next = output.NextToken
+		if next == nil {
+			break
+		}
+	}
+
+	var tasks []*ecs.Task
+	for len(arns) > 0 {
+		describe := arns
+		if len(arns) > 100 {
+			describe = arns[0:100]
+		}
+		arns = arns[len(describe):]
+
+		output, err := ecsClient.DescribeTasks(&ecs.DescribeTasksInput{Cluster: namespace.ClusterID(), Tasks: describe})
+		if err != nil {
+			return nil, fmt.Errorf("error describing tasks for service %s. %v", aws.StringValue(serviceName), err)
+		}
+
+		tasks = append(tasks, output.Tasks...)
+	}
+
+	return tasks, nil
+}
+
+// TaskIDFromArn returns the task id, the last part of the task arn
+func TaskIDFromArn(arn *string) string {
+	parts := strings.Split(aws.StringValue(arn), "/")
+	return parts[len(parts)-1]
+}
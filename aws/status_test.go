@@ -0,0 +1,59 @@
+package aws
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func Test_NewFunctionStatus(t *testing.T) {
+	service := &ecs.Service{
+		DesiredCount: aws.Int64(4),
+		RunningCount: aws.Int64(3),
+		PendingCount: aws.Int64(1),
+	}
+
+	tasks := []*ecs.Task{
+		testTask("a", "RUNNING", "HEALTHY"),
+		testTask("b", "RUNNING", "HEALTHY"),
+		testTask("c", "RUNNING", "UNKNOWN"),
+		testTask("d", "PROVISIONING", "UNKNOWN"),
+	}
+
+	registered := map[string]bool{"a": true, "c": true}
+
+	status := newFunctionStatus(service, tasks, registered, true)
+	want := FunctionStatus{Desired: 4, Running: 3, Pending: 1, Healthy: 2, Registered: 2, Available: 1}
+	if *status != want {
+		t.Errorf("Want %+v, got %+v", want, *status)
+	}
+}
+
+func Test_NewFunctionStatus_WithoutHealthCheck(t *testing.T) {
+	service := &ecs.Service{DesiredCount: aws.Int64(2), RunningCount: aws.Int64(2), PendingCount: aws.Int64(0)}
+	tasks := []*ecs.Task{
+		testTask("a", "RUNNING", "UNKNOWN"),
+		testTask("b", "RUNNING", "UNKNOWN"),
+	}
+
+	status := newFunctionStatus(service, tasks, map[string]bool{"b": true}, false)
+	if status.Healthy != 2 || status.Available != 1 {
+		t.Errorf("Want 2 healthy and 1 available, got %+v", *status)
+	}
+}
+
+func Test_TaskIDFromArn(t *testing.T) {
+	id := TaskIDFromArn(aws.String("arn:aws:ecs:eu-west-1:122668425727:task/7d8ac8a4-f0e4-4c3b-9d9a-1b2c3d4e5f60"))
+	if id != "7d8ac8a4-f0e4-4c3b-9d9a-1b2c3d4e5f60" {
+		t.Errorf("Unexpected task id %s", id)
+	}
+}
+
+func testTask(id string, lastStatus string, health string) *ecs.Task {
+	return &ecs.Task{
+		TaskArn:      aws.String("arn:aws:ecs:eu-west-1:122668425727:task/" + id),
+		LastStatus:   aws.String(lastStatus),
+		HealthStatus: aws.String(health),
+	}
+}
@@ -0,0 +1,177 @@
+package aws
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/servicediscovery"
+)
+
+// stubHandler answers a call to an aws operation with its output, or an error
+type stubHandler func(input interface{}) (interface{}, error)
+
+// stubAPI stands in for the ECS, IAM, CloudWatch Logs, Secrets Manager and route 53 auto-naming apis, answering
+// each operation with its handler rather than sending it. Operations without a handler fail the test.
+type stubAPI struct {
+	t        *testing.T
+	handlers map[string]stubHandler
+	// calls are the names of the operations called, in order
+	calls []string
+}
+
+// on answers the operation with the handler
+func (s *stubAPI) on(operation string, handler stubHandler) {
+	s.handlers[operation] = handler
+}
+
+// called returns true if the operation was called
+func (s *stubAPI) called(operation string) bool {
+	for _, item := range s.calls {
+		if item == operation {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *stubAPI) send(r *request.Request) {
+	s.calls = append(s.calls, r.Operation.Name)
+
+	handler, found := s.handlers[r.Operation.Name]
+	if !found {
+		s.t.Errorf("Unexpected call to %s", r.Operation.Name)
+		r.Error = awserr.New("NotStubbed", "no stub for "+r.Operation.Name, nil)
+		return
+	}
+
+	output, err := handler(r.Params)
+	if err != nil {
+		r.Error = err
+		return
+	}
+
+	if output != nil {
+		reflect.ValueOf(r.Data).Elem().Set(reflect.ValueOf(output).Elem())
+	}
+}
+
+// stub replaces the handlers of the client which send requests and read responses with the stub
+func (s *stubAPI) stub(c *client.Client) {
+	c.Handlers.Sign.Clear()
+	c.Handlers.Send.Clear()
+	c.Handlers.UnmarshalMeta.Clear()
+	c.Handlers.ValidateResponse.Clear()
+	c.Handlers.Unmarshal.Clear()
+	c.Handlers.UnmarshalError.Clear()
+	c.Handlers.Retry.Clear()
+	c.Handlers.AfterRetry.Clear()
+	c.Handlers.Send.PushBack(s.send)
+}
+
+// withStubbedAPI runs the test with the aws clients answered by a stubAPI
+func withStubbedAPI(t *testing.T, test func(api *stubAPI)) {
+	api := &stubAPI{t: t, handlers: map[string]stubHandler{}}
+	sess := session.Must(session.NewSession(aws.NewConfig().
+		WithRegion("us-east-1").
+		WithCredentials(credentials.NewStaticCredentials("id", "secret", ""))))
+
+	previousECS, previousIAM, previousLogs := ecsClient, iamClient, cloudwatchClient
+	previousSecrets, previousDiscovery := secretsClient, discoveryClient
+	defer func() {
+		ecsClient, iamClient, cloudwatchClient = previousECS, previousIAM, previousLogs
+		secretsClient, discoveryClient = previousSecrets, previousDiscovery
+	}()
+
+	ecsClient = ecs.New(sess)
+	iamClient = iam.New(sess)
+	cloudwatchClient = cloudwatchlogs.New(sess)
+	secretsClient = secretsmanager.New(sess)
+	discoveryClient = servicediscovery.New(sess)
+
+	api.stub(ecsClient.Client)
+	api.stub(iamClient.Client)
+	api.stub(cloudwatchClient.Client)
+	api.stub(secretsClient.Client)
+	api.stub(discoveryClient.Client)
+
+	test(api)
+}
+
+// withInstallation runs the test as the installation
+func withInstallation(id string, test func()) {
+	previous := installationID
+	installationID = id
+	defer func() { installationID = previous }()
+
+	test()
+}
+
+// stubbedService is an ECS service of a function answered by the stubAPI
+type stubbedService struct {
+	service        *ecs.Service
+	taskDefinition *ecs.TaskDefinition
+	// updates are the requests made to update the service, in order
+	updates []*ecs.UpdateServiceInput
+}
+
+// stubFunctionService answers DescribeServices, DescribeTaskDefinition and UpdateService for an active service of the
+// function, running a revision with the labels. Other services are not found.
+func stubFunctionService(api *stubAPI, namespace *Namespace, functionName string, labels map[string]string) *stubbedService {
+	name := namespace.ServiceNameFromFunctionName(functionName)
+	stubbed := &stubbedService{
+		service: &ecs.Service{
+			ServiceName:    aws.String(name),
+			ServiceArn:     aws.String("arn:aws:ecs:us-east-1:123456789012:service/" + name),
+			Status:         aws.String("ACTIVE"),
+			DesiredCount:   aws.Int64(1),
+			TaskDefinition: aws.String("arn:aws:ecs:us-east-1:123456789012:task-definition/" + name + ":1"),
+		},
+		taskDefinition: &ecs.TaskDefinition{
+			Family:            aws.String(name),
+			TaskDefinitionArn: aws.String("arn:aws:ecs:us-east-1:123456789012:task-definition/" + name + ":1"),
+			ContainerDefinitions: []*ecs.ContainerDefinition{
+				{Name: aws.String(name), DockerLabels: functionDockerLabels(namespace, functionName, &labels)},
+			},
+		},
+	}
+	stubbed.service.Deployments = []*ecs.Deployment{
+		{Status: aws.String("PRIMARY"), TaskDefinition: stubbed.service.TaskDefinition},
+	}
+
+	api.on("DescribeServices", func(input interface{}) (interface{}, error) {
+		if aws.StringValue(input.(*ecs.DescribeServicesInput).Services[0]) != name {
+			return &ecs.DescribeServicesOutput{}, nil
+		}
+
+		return &ecs.DescribeServicesOutput{Services: []*ecs.Service{stubbed.service}}, nil
+	})
+	api.on("DescribeTaskDefinition", func(input interface{}) (interface{}, error) {
+		return &ecs.DescribeTaskDefinitionOutput{TaskDefinition: stubbed.taskDefinition}, nil
+	})
+	api.on("UpdateService", func(input interface{}) (interface{}, error) {
+		update := input.(*ecs.UpdateServiceInput)
+		stubbed.updates = append(stubbed.updates, update)
+		if update.DesiredCount != nil {
+			stubbed.service.DesiredCount = update.DesiredCount
+		}
+
+		if update.TaskDefinition != nil {
+			stubbed.service.TaskDefinition = update.TaskDefinition
+		}
+
+		return &ecs.UpdateServiceOutput{Service: stubbed.service}, nil
+	})
+
+	return stubbed
+}
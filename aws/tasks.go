@@ -2,42 +2,125 @@ package aws
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/ewilde/faas-fargate/types"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/ecs"
 	"github.com/openfaas/faas/gateway/requests"
+	log "github.com/sirupsen/logrus"
 )
 
-// CreateTaskRevision create a new task revision
+// CreateTaskRevision create a new task revision, rolling back the resources it created if it fails
 func CreateTaskRevision(
+	namespace *Namespace,
 	request requests.CreateFunctionRequest,
 	config *types.DeployHandlerConfig) (*ecs.RegisterTaskDefinitionOutput, error) {
 
-	totalMemory := 512
-	totalCPU := 256
-	funcMemory := totalMemory
-	funcCPU := totalCPU
+	return createTaskRevision(newDeployment(request.Service), namespace, request, request.Service, config)
+}
 
-	name := ServiceNameFromFunctionName(request.Service)
-	taskDefinitionInput := &ecs.RegisterTaskDefinitionInput{
-		Family: aws.String(name),
-		Memory: aws.String("512"), // TODO configure
-		Cpu:    aws.String("256"), // TODO configure
-		RequiresCompatibilities: []*string{aws.String("FARGATE")},
-		NetworkMode:             aws.String("awsvpc"),
+// renderedTaskDefinition is the task definition and role policy a deploy request renders to, before any AWS
+// resources are created
+type renderedTaskDefinition struct {
+	input       *ecs.RegisterTaskDefinitionInput
+	policy      *PolicyBuilder
+	credentials *registryCredentials
+	// registryAuthArn is the arn of the registry auth secret, when known before it is created or updated
+	registryAuthArn string
+}
+
+// functionContainer returns the container running the function
+func (r *renderedTaskDefinition) functionContainer() *ecs.ContainerDefinition {
+	return FunctionContainer(&ecs.TaskDefinition{Family: r.input.Family, ContainerDefinitions: r.input.ContainerDefinitions})
+}
+
+// renderTaskDefinition validates the request and renders the task definition and role policy for it, without
+// creating anything
+func renderTaskDefinition(
+	namespace *Namespace,
+	request requests.CreateFunctionRequest,
+	config *types.DeployHandlerConfig) (*renderedTaskDefinition, error) {
+
+	if strings.HasSuffix(request.Service, canarySuffix) {
+		return nil, newValidationError("function name %s must not end with %s, it is reserved for canaries",
+			request.Service, canarySuffix)
+	}
+
+	if err := namespace.checkFunctionName(request.Service); err != nil {
+		return nil, err
+	}
+
+	size, err := NewTaskSize(request)
+	if err != nil {
+		return nil, err
+	}
+
+	environment, err := functionEnvironment(request, config.DefaultEnvVars)
+	if err != nil {
+		return nil, err
+	}
+
+	healthCheckOn, err := healthCheckEnabled(request.Labels, config.EnableFunctionReadinessProbe)
+	if err != nil {
+		return nil, err
+	}
+
+	var healthCheck *ecs.HealthCheck
+	if healthCheckOn {
+		healthCheck, err = functionHealthCheck(request.Labels)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := newRolloutSettings(request.Labels); err != nil {
+		return nil, err
+	}
+
+	if _, err := newScalingSettings(request.Labels); err != nil {
+		return nil, err
 	}
 
-	logGroupName, err := createLogGroup(request.Service)
+	schedule, err := newScaleSchedule(request.Labels, int64(config.MaxReplicas))
 	if err != nil {
 		return nil, err
 	}
 
+	if schedule != nil {
+		if err := schedule.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	var credentials *registryCredentials
+	if len(request.RegistryAuth) > 0 {
+		credentials, err = decodeRegistryAuth(request.RegistryAuth)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	name := namespace.ServiceNameFromFunctionName(request.Service)
+	taskDefinitionInput := &ecs.RegisterTaskDefinitionInput{
+		Family:                  aws.String(name),
+		Memory:                  aws.String(strconv.FormatInt(size.Memory, 10)),
+		Cpu:                     aws.String(strconv.FormatInt(size.CPU, 10)),
+		RequiresCompatibilities: []*string{aws.String("FARGATE")},
+		NetworkMode:             aws.String("awsvpc"),
+	}
+
+	logGroupName := logGroupName(namespace, request.Service)
 	funcTask := &ecs.ContainerDefinition{
-		Name:  aws.String(name),
-		Image: aws.String(request.Image),
+		Name:         aws.String(name),
+		Image:        aws.String(request.Image),
+		Environment:  environment,
+		DockerLabels: functionDockerLabels(namespace, request.Service, request.Labels),
+		HealthCheck:  healthCheck,
 		LogConfiguration: &ecs.LogConfiguration{
 			LogDriver: aws.String("awslogs"),
 			Options: map[string]*string{
@@ -60,12 +143,10 @@ func CreateTaskRevision(
 			return nil, err
 		}
 
-		funcMemory = funcMemory - 32
-		funcCPU = funcCPU - 64
 		secretTask := &ecs.ContainerDefinition{
 			Name:   aws.String(fmt.Sprintf("%s-kms", name)),
-			Cpu:    aws.Int64(64),
-			Memory: aws.Int64(32),
+			Cpu:    aws.Int64(secretsSidecarCPU),
+			Memory: aws.Int64(secretsSidecarMemory),
 			Image:  aws.String("ewilde/kms-template:latest"),
 			LogConfiguration: &ecs.LogConfiguration{
 				LogDriver: aws.String("awslogs"),
@@ -88,12 +169,68 @@ func CreateTaskRevision(
 		funcTask.VolumesFrom = []*ecs.VolumeFrom{{SourceContainer: secretTask.Name}}
 	}
 
-	funcTask.Cpu = aws.Int64(int64(funcCPU))
-	funcTask.Memory = aws.Int64(int64(funcMemory))
+	funcTask.Cpu = aws.Int64(size.FunctionCPU)
+	funcTask.Memory = aws.Int64(size.FunctionMemory)
 
 	taskDefinitionInput.ContainerDefinitions = append(taskDefinitionInput.ContainerDefinitions, funcTask)
 
-	arn, err := createRoleWithPolicy(request.Service, policy.String())
+	return &renderedTaskDefinition{input: taskDefinitionInput, policy: policy, credentials: credentials}, nil
+}
+
+// createTaskRevision registers a task definition revision of the function for the request. Its role and registry
+// auth secret are created for accessName, the function name except for a canary which gets its own so deploying it
+// does not change the access of the running function.
+func createTaskRevision(
+	d *deployment,
+	namespace *Namespace,
+	request requests.CreateFunctionRequest,
+	accessName string,
+	config *types.DeployHandlerConfig) (*ecs.RegisterTaskDefinitionOutput, error) {
+
+	rendered, err := renderTaskDefinition(namespace, request, config)
+	if err != nil {
+		return nil, err
+	}
+
+	// checked before anything is changed, as the role and service of the function are named after the family
+	if err := checkTaskFamilyOwner(namespace, request.Service); err != nil {
+		return nil, err
+	}
+
+	err = d.run(stepLogGroup, func() (undoFunc, error) {
+		return createLogGroup(namespace, request.Service)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	taskDefinitionInput := rendered.input
+	name := aws.StringValue(taskDefinitionInput.Family)
+	policy := rendered.policy
+
+	repositoryCredentials := map[string]string{}
+	if rendered.credentials != nil {
+		var secretArn, version string
+		err = d.run(stepRegistryAuth, func() (undo undoFunc, err error) {
+			secretArn, version, undo, err = ensureRegistryAuthSecret(namespace, accessName, rendered.credentials)
+			return undo, err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		buildRegistryAuthPolicyStatement(policy, secretArn)
+		repositoryCredentials[name] = secretArn
+		rendered.functionContainer().DockerLabels[registryAuthVersionLabel] = aws.String(version)
+	}
+
+	rendered.functionContainer().DockerLabels[registeredAtLabel] = aws.String(time.Now().UTC().Format(time.RFC3339))
+
+	var arn string
+	err = d.run(stepRole, func() (undo undoFunc, err error) {
+		arn, undo, err = createRoleWithPolicy(namespace, accessName, policy.String())
+		return undo, err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -101,7 +238,15 @@ func CreateTaskRevision(
 	taskDefinitionInput.TaskRoleArn = aws.String(arn)
 	taskDefinitionInput.ExecutionRoleArn = aws.String(arn)
 
-	output, err := ecsClient.RegisterTaskDefinition(taskDefinitionInput)
+	var output *ecs.RegisterTaskDefinitionOutput
+	err = d.run(stepTaskDefinition, func() (undoFunc, error) {
+		output, err = registerTaskDefinition(taskDefinitionInput, repositoryCredentials)
+		if err != nil {
+			return nil, fmt.Errorf("error registering task definition %s. %v", name, err)
+		}
+
+		return func() error { return deregisterTaskDefinition(output.TaskDefinition.TaskDefinitionArn) }, nil
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -109,54 +254,111 @@ func CreateTaskRevision(
 	return output, nil
 }
 
-// GetLatestTaskRevision gets the latest task revision for the corresponding functionName
-func GetLatestTaskRevision(functionName string) (string, error) {
-	name := ServiceNameFromFunctionName(functionName)
+// checkTaskFamilyOwner returns a ConflictError if the latest revision of the task family of the function belongs to
+// another function, whose name in another installation or namespace gives the same family name
+func checkTaskFamilyOwner(namespace *Namespace, functionName string) error {
+	family := namespace.ServiceNameFromFunctionName(functionName)
+	output, err := ecsClient.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{TaskDefinition: aws.String(family)})
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == ecs.ErrCodeClientException {
+			// the family has no active revisions
+			return nil
+		}
+
+		return fmt.Errorf("error describing task family %s. %v", family, err)
+	}
+
+	if !namespace.isOwnedFunction(FunctionContainer(output.TaskDefinition), functionName) {
+		return newConflictError("task family %s belongs to a function of another installation or namespace", family)
+	}
+
+	return nil
+}
+
+// GetLatestTaskRevision gets the latest active task revision for the corresponding functionName
+func GetLatestTaskRevision(namespace *Namespace, functionName string) (string, error) {
+	name := namespace.ServiceNameFromFunctionName(functionName)
 
-	output, err := ecsClient.ListTaskDefinitions(&ecs.ListTaskDefinitionsInput{
-		FamilyPrefix: aws.String(name),
-		Sort:         aws.String("DESC"),
+	output, err := ecsClient.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: aws.String(name),
 	})
 
 	if err != nil {
 		return "", fmt.Errorf("error getting latest task revision for %s. %v", name, err)
 	}
 
-	return aws.StringValue(output.TaskDefinitionArns[0]), nil
+	return aws.StringValue(output.TaskDefinition.TaskDefinitionArn), nil
 }
 
-// DeleteTaskRevision deletes the task revision
-func DeleteTaskRevision(functionName string) error {
-	latestTaskArn, err := GetLatestTaskRevision(functionName)
+// DeleteTaskRevision deregisters every active revision of the task family that belongs to the function, revisions
+// registered by a function of another installation or namespace with the same family name are left, then deletes
+// the role, log group and registry auth of the function
+func DeleteTaskRevision(namespace *Namespace, functionName string) error {
+	arns, err := listTaskRevisions(namespace, functionName)
 	if err != nil {
 		return err
 	}
 
-	_, err = ecsClient.DeregisterTaskDefinition(&ecs.DeregisterTaskDefinitionInput{
-		TaskDefinition: aws.String(latestTaskArn),
-	})
+	for _, arn := range arns {
+		output, err := ecsClient.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{TaskDefinition: aws.String(arn)})
+		if err != nil {
+			return fmt.Errorf("error describing task definition %s. %v", arn, err)
+		}
 
+		if !namespace.isOwnedFunction(FunctionContainer(output.TaskDefinition), functionName) {
+			log.Warnf("Task definition %s belongs to a function of another installation or namespace, leaving it", arn)
+			continue
+		}
+
+		err = deregisterTaskDefinition(aws.String(arn))
+		if err != nil {
+			return fmt.Errorf("error deleting task definition %s arn: %s. %v", functionName, arn, err)
+		}
+	}
+
+	err = deleteRole(namespace, functionName)
 	if err != nil {
-		return fmt.Errorf("error deleting task definition %s arn: %s. %v", functionName, latestTaskArn, err)
+		return fmt.Errorf("error deleting role for task definition %s. %v", functionName, err)
 	}
 
-	err = deleteRole(functionName)
+	err = deleteLogGroup(namespace, functionName)
 	if err != nil {
-		return fmt.Errorf("error deleting role for task definition %s arn: %s. %v", functionName, latestTaskArn, err)
+		return fmt.Errorf("error deleting log group for task definition %s. %v", functionName, err)
 	}
 
-	err = deleteLogGroup(functionName)
+	err = deleteRegistryAuthSecret(namespace, functionName)
 	if err != nil {
-		return fmt.Errorf("error deleting log group for task definition %s arn: %s. %v", functionName, latestTaskArn, err)
+		return fmt.Errorf("error deleting registry auth for task definition %s. %v", functionName, err)
 	}
 
 	return err
 }
 
+func deregisterTaskDefinition(arn *string) error {
+	_, err := ecsClient.DeregisterTaskDefinition(&ecs.DeregisterTaskDefinitionInput{TaskDefinition: arn})
+	return err
+}
+
+// FunctionContainer returns the container running the function from a task definition containing the function and
+// any sidecars.
+func FunctionContainer(taskDefinition *ecs.TaskDefinition) *ecs.ContainerDefinition {
+	if taskDefinition == nil || len(taskDefinition.ContainerDefinitions) == 0 {
+		return nil
+	}
+
+	for _, item := range taskDefinition.ContainerDefinitions {
+		if aws.StringValue(item.Name) == aws.StringValue(taskDefinition.Family) {
+			return item
+		}
+	}
+
+	return taskDefinition.ContainerDefinitions[len(taskDefinition.ContainerDefinitions)-1]
+}
+
 func getSecretNames(secrets []string) []string {
 	var names []string
 	for _, v := range secrets {
-		names = append(names, fmt.Sprintf("%s%s", servicePrefix, v))
+		names = append(names, fmt.Sprintf("%s%s", secretPrefix, v))
 	}
 
 	return names
@@ -13,7 +13,7 @@ func TestAccCreateTaskRevision(t *testing.T) {
 	subnetIDs := os.Getenv("subnet_ids")
 	vpcID := VpcFromSubnet(subnetIDs)
 
-	_, err := CreateTaskRevision(requests.CreateFunctionRequest{
+	_, err := CreateTaskRevision(DefaultNamespace(), requests.CreateFunctionRequest{
 		Service: "figlet",
 		Image:   "functions/figlet",
 	}, &types.DeployHandlerConfig{
@@ -28,7 +28,7 @@ func TestAccCreateTaskRevision(t *testing.T) {
 		t.Error(err)
 	}
 
-	defer DeleteTaskRevision("figlet")
+	defer DeleteTaskRevision(DefaultNamespace(), "figlet")
 }
 
 func TestAccCreateTaskRevision_WithSecret(t *testing.T) {
@@ -36,7 +36,7 @@ func TestAccCreateTaskRevision_WithSecret(t *testing.T) {
 	subnetIDs := os.Getenv("subnet_ids")
 	vpcID := VpcFromSubnet(subnetIDs)
 
-	_, err := CreateTaskRevision(requests.CreateFunctionRequest{
+	_, err := CreateTaskRevision(DefaultNamespace(), requests.CreateFunctionRequest{
 		Service: "hellogoworld",
 		Image:   "ewilde/hellogoworld:latest",
 		Secrets: []string{"db-password"},
@@ -52,5 +52,5 @@ func TestAccCreateTaskRevision_WithSecret(t *testing.T) {
 		t.Error(err)
 	}
 
-	defer DeleteTaskRevision("figlet")
+	defer DeleteTaskRevision(DefaultNamespace(), "figlet")
 }
@@ -0,0 +1,36 @@
+// Copyright (c) Edward Wilde 2018. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	awsutil "github.com/ewilde/faas-fargate/aws"
+	"github.com/gorilla/mux"
+)
+
+// MakeAutoscalerReader returns the recent decisions the request autoscaler made for a function, explaining why it
+// was or was not scaled
+func MakeAutoscalerReader(autoscaler *awsutil.RequestAutoscaler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		functionName := mux.Vars(r)["name"]
+
+		namespace := namespaceFromRequest(w, r)
+		if namespace == nil {
+			return
+		}
+
+		decisions := autoscaler.Decisions(namespace, functionName)
+		if decisions == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		decisionsBytes, _ := json.Marshal(decisions)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(decisionsBytes)
+	}
+}
@@ -0,0 +1,225 @@
+// Copyright (c) Edward Wilde 2018. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	awsutil "github.com/ewilde/faas-fargate/aws"
+	"github.com/ewilde/faas-fargate/types"
+	"github.com/gorilla/mux"
+	"github.com/openfaas/faas/gateway/requests"
+	log "github.com/sirupsen/logrus"
+)
+
+// canaryWeightRequest sets the percentage of requests routed to the canary
+type canaryWeightRequest struct {
+	Weight *int64 `json:"weight"`
+}
+
+// canaryShadowRequest sets the percentage of requests to the function mirrored to the canary
+type canaryShadowRequest struct {
+	Percent *int64 `json:"percent"`
+}
+
+// MakeCanaryDeployHandler deploys a new revision of a function alongside its current revision, as a canary
+func MakeCanaryDeployHandler(config *types.DeployHandlerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		functionName := mux.Vars(r)["name"]
+
+		body, _ := ioutil.ReadAll(r.Body)
+		request := requests.CreateFunctionRequest{}
+		if err := json.Unmarshal(body, &request); err != nil {
+			log.Errorln("Error during unmarshal of canary request. ", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if len(request.Service) == 0 {
+			request.Service = functionName
+		}
+
+		if request.Service != functionName {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("service in the request body does not match the function name"))
+			return
+		}
+
+		namespace := namespaceFromRequest(w, r)
+		if namespace == nil {
+			return
+		}
+
+		log.Infof("Canary request for function %s in namespace %s", functionName, namespace.Name)
+
+		status, err := awsutil.DeployCanary(namespace, request, config)
+		if err != nil {
+			log.Errorf("Error deploying canary of %s. %v", functionName, err)
+			writeDeployError(w, err)
+			return
+		}
+
+		writeCanaryStatus(w, http.StatusAccepted, status)
+	}
+}
+
+// MakeCanaryReader returns the canary of a function and its weight
+func MakeCanaryReader() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		functionName := mux.Vars(r)["name"]
+
+		namespace := namespaceFromRequest(w, r)
+		if namespace == nil {
+			return
+		}
+
+		status, err := awsutil.GetCanary(namespace, functionName)
+		if err != nil {
+			log.Errorf("Error reading canary of %s. %v", functionName, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		writeCanaryStatus(w, http.StatusOK, status)
+	}
+}
+
+// MakeCanaryWeightHandler shifts the share of requests routed to the canary of a function
+func MakeCanaryWeightHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		functionName := mux.Vars(r)["name"]
+
+		body, _ := ioutil.ReadAll(r.Body)
+		request := canaryWeightRequest{}
+		if err := json.Unmarshal(body, &request); err != nil || request.Weight == nil {
+			log.Errorln("Error during unmarshal of canary weight request. ", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		namespace := namespaceFromRequest(w, r)
+		if namespace == nil {
+			return
+		}
+
+		log.Infof("Canary weight request for function %s, weight %d", functionName, *request.Weight)
+
+		if err := awsutil.SetCanaryWeight(namespace, functionName, *request.Weight); err != nil {
+			log.Errorf("Error setting canary weight of %s. %v", functionName, err)
+			w.WriteHeader(statusCodeForError(err))
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		status, err := awsutil.GetCanary(namespace, functionName)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		writeCanaryStatus(w, http.StatusOK, status)
+	}
+}
+
+// MakeCanaryShadowHandler sets the share of requests to a function mirrored to its canary, whose responses are
+// discarded
+func MakeCanaryShadowHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		functionName := mux.Vars(r)["name"]
+
+		body, _ := ioutil.ReadAll(r.Body)
+		request := canaryShadowRequest{}
+		if err := json.Unmarshal(body, &request); err != nil || request.Percent == nil {
+			log.Errorln("Error during unmarshal of canary shadow request. ", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		namespace := namespaceFromRequest(w, r)
+		if namespace == nil {
+			return
+		}
+
+		log.Infof("Canary shadow request for function %s, percent %d", functionName, *request.Percent)
+
+		if err := awsutil.SetCanaryShadow(namespace, functionName, *request.Percent); err != nil {
+			log.Errorf("Error setting canary shadow of %s. %v", functionName, err)
+			w.WriteHeader(statusCodeForError(err))
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		status, err := awsutil.GetCanary(namespace, functionName)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		writeCanaryStatus(w, http.StatusOK, status)
+	}
+}
+
+// MakeCanaryPromoteHandler replaces the revision a function is running with its canary and removes the canary
+func MakeCanaryPromoteHandler(config *types.DeployHandlerConfig) http.HandlerFunc {
+	return makeCanaryEndHandler("promote", func(namespace *awsutil.Namespace, functionName string) (*awsutil.CanaryStatus, error) {
+		return awsutil.PromoteCanary(namespace, functionName, config)
+	})
+}
+
+// MakeCanaryAbortHandler routes every request back to the revision a function is running and removes the canary
+func MakeCanaryAbortHandler(config *types.DeployHandlerConfig) http.HandlerFunc {
+	return makeCanaryEndHandler("abort", func(namespace *awsutil.Namespace, functionName string) (*awsutil.CanaryStatus, error) {
+		return awsutil.AbortCanary(namespace, functionName, config)
+	})
+}
+
+func makeCanaryEndHandler(
+	action string,
+	end func(namespace *awsutil.Namespace, functionName string) (*awsutil.CanaryStatus, error)) http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		functionName := mux.Vars(r)["name"]
+
+		namespace := namespaceFromRequest(w, r)
+		if namespace == nil {
+			return
+		}
+
+		log.Infof("Canary %s request for function %s", action, functionName)
+
+		status, err := end(namespace, functionName)
+		if err != nil {
+			log.Errorf("Error during canary %s of %s. %v", action, functionName, err)
+			w.WriteHeader(statusCodeForError(err))
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		writeCanaryStatus(w, http.StatusAccepted, status)
+	}
+}
+
+// writeCanaryStatus writes the canary as json, or 404 when there is no canary
+func writeCanaryStatus(w http.ResponseWriter, statusCode int, status *awsutil.CanaryStatus) {
+	if status == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	statusBytes, _ := json.Marshal(status)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(statusBytes)
+}
@@ -36,7 +36,12 @@ func MakeDeleteHandler(
 			w.WriteHeader(http.StatusBadRequest)
 		}
 
-		err = awsutil.DeleteECSService(request.FunctionName, config)
+		namespace := namespaceFromRequest(w, r)
+		if namespace == nil {
+			return
+		}
+
+		err = awsutil.DeleteECSService(namespace, request.FunctionName, config)
 		if err != nil {
 			log.Errorf("Can not delete function %s. %v", request.FunctionName, err)
 			w.WriteHeader(http.StatusBadRequest)
@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
+	"time"
 
 	"fmt"
 
@@ -27,6 +28,7 @@ const initialReplicasCount = 1
 func MakeDeployHandler(
 	config *types.DeployHandlerConfig) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
 		defer r.Body.Close()
 
 		body, _ := ioutil.ReadAll(r.Body)
@@ -39,26 +41,94 @@ func MakeDeployHandler(
 			return
 		}
 
-		log.Infof("Deployment request for function %s", request.Service)
+		namespace := namespaceFromRequest(w, r)
+		if namespace == nil {
+			return
+		}
 
-		taskDefinition, err := awsutil.CreateTaskRevision(request, config)
-		if err != nil {
-			log.Errorln(fmt.Sprintf("Error creating task revision for %s", request.Service), err)
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(err.Error()))
+		if isDryRun(r) {
+			writeDeployPlan(w, namespace, request, config)
+			return
+		}
+
+		if !validateWait(w, r, config.WriteTimeout) {
 			return
 		}
 
-		log.Infof("Created task definition for %s", request.Service)
+		log.Infof("Deployment request for function %s in namespace %s", request.Service, namespace.Name)
 
-		service, err := awsutil.UpdateOrCreateECSService(taskDefinition.TaskDefinition, request, config)
+		service, err := awsutil.DeployFunction(namespace, request, config)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(err.Error()))
+			log.Errorln(fmt.Sprintf("Error deploying %s", request.Service), err)
+			writeDeployError(w, err)
 			return
 		}
 
-		w.WriteHeader(http.StatusAccepted)
 		log.Infof("Created service %s arn: %s", request.Service, aws.StringValue(service.ServiceArn))
+		writeDeployAccepted(w, r, namespace, request.Service, started, config.WriteTimeout)
+	}
+}
+
+// isDryRun returns true when the request asks for a plan of the deploy instead of making it
+func isDryRun(r *http.Request) bool {
+	return r.URL.Query().Get("dryRun") == "true"
+}
+
+// writeDeployPlan writes the requests a deploy would make, as json, without making them
+func writeDeployPlan(
+	w http.ResponseWriter,
+	namespace *awsutil.Namespace,
+	request requests.CreateFunctionRequest,
+	config *types.DeployHandlerConfig) {
+
+	log.Infof("Deployment plan request for function %s in namespace %s", request.Service, namespace.Name)
+
+	plan, err := awsutil.PlanDeploy(namespace, request, config)
+	if err != nil {
+		log.Errorf("Error planning deployment of %s. %v", request.Service, err)
+		w.WriteHeader(statusCodeForError(err))
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	planBytes, _ := json.Marshal(plan)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(planBytes)
+}
+
+// deployErrorResponse tells the caller which step of a deploy failed and what was rolled back
+type deployErrorResponse struct {
+	*awsutil.DeployError
+	Message string `json:"message"`
+}
+
+// writeDeployError writes a DeployError as json, other errors are written as text
+func writeDeployError(w http.ResponseWriter, err error) {
+	deployErr, ok := err.(*awsutil.DeployError)
+	if !ok {
+		w.WriteHeader(statusCodeForError(err))
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	responseBytes, _ := json.Marshal(deployErrorResponse{DeployError: deployErr, Message: deployErr.Err.Error()})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCodeForError(err))
+	w.Write(responseBytes)
+}
+
+// statusCodeForError returns 400 for errors caused by the content of a request, 409 for requests the function can
+// not carry out in its current state and 500 for everything else
+func statusCodeForError(err error) int {
+	switch e := err.(type) {
+	case *awsutil.ValidationError:
+		return http.StatusBadRequest
+	case *awsutil.ConflictError:
+		return http.StatusConflict
+	case *awsutil.DeployError:
+		return statusCodeForError(e.Err)
+	default:
+		return http.StatusInternalServerError
 	}
 }
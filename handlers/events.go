@@ -0,0 +1,46 @@
+// Copyright (c) Edward Wilde 2018. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	awsutil "github.com/ewilde/faas-fargate/aws"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// MakeFunctionEventsReader reports service events, stopped tasks and deployments for a function
+func MakeFunctionEventsReader() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		functionName := vars["name"]
+
+		log.Infof("Read events for %s", functionName)
+
+		namespace := namespaceFromRequest(w, r)
+		if namespace == nil {
+			return
+		}
+
+		events, err := awsutil.GetFunctionEvents(namespace, functionName)
+		if err != nil {
+			log.Errorf("Error reading events for function %s. %v", functionName, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		if events == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		eventBytes, _ := json.Marshal(events)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(eventBytes)
+	}
+}
@@ -0,0 +1,31 @@
+// Copyright (c) Edward Wilde 2018. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	awsutil "github.com/ewilde/faas-fargate/aws"
+	log "github.com/sirupsen/logrus"
+)
+
+// MakeGarbageCollectionReader reports the orphaned resources the garbage collector would remove, without removing
+// them
+func MakeGarbageCollectionReader(collector *awsutil.GarbageCollector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report, err := collector.Collect(true)
+		if err != nil {
+			log.Errorf("Error finding orphaned resources. %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		reportBytes, _ := json.Marshal(report)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(reportBytes)
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright (c) Edward Wilde 2018. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	awsutil "github.com/ewilde/faas-fargate/aws"
+	log "github.com/sirupsen/logrus"
+)
+
+// MakeNamespaceReader lists the function namespaces
+func MakeNamespaceReader() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		namespaceBytes, _ := json.Marshal(awsutil.Namespaces())
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(namespaceBytes)
+	}
+}
+
+// namespaceFromRequest returns the namespace named by the namespace query parameter, or the default namespace. When
+// the namespace is not configured a 400 is written and nil returned.
+func namespaceFromRequest(w http.ResponseWriter, r *http.Request) *awsutil.Namespace {
+	namespace, err := awsutil.GetNamespace(r.URL.Query().Get("namespace"))
+	if err != nil {
+		log.Errorln(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return nil
+	}
+
+	return namespace
+}
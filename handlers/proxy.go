@@ -8,29 +8,34 @@ import (
 	"io"
 	"net"
 	"net/http"
+	neturl "net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"fmt"
 
+	awsutil "github.com/ewilde/faas-fargate/aws"
 	"github.com/gorilla/mux"
-	"github.com/openfaas/faas/gateway/requests"
 	log "github.com/sirupsen/logrus"
 )
 
-// MakeProxy creates a proxy for HTTP web requests which can be routed to a function.
-func MakeProxy(timeout time.Duration) http.HandlerFunc {
-	proxyClient := http.Client{
+// MakeProxy creates a proxy for HTTP web requests which can be routed to a function. Requests are reported to the
+// autoscaler, and requests to a function scaled to zero wait for the autoscaler to wake it. A function which does not
+// start its response within the upstream timeout is answered with a 504.
+func MakeProxy(timeout time.Duration, upstreamTimeout time.Duration, autoscaler *awsutil.RequestAutoscaler) http.HandlerFunc {
+	proxyClient := newProxyClient(timeout, upstreamTimeout)
+
+	// shadow responses are discarded, so mirrored requests are given up on at the timeout rather than held open
+	shadowClient := &http.Client{
+		Timeout: timeout,
 		Transport: &http.Transport{
 			Proxy: http.ProxyFromEnvironment,
 			DialContext: (&net.Dialer{
 				Timeout:   timeout,
 				KeepAlive: 1 * time.Second,
 			}).DialContext,
-			// MaxIdleConns:          1,
-			// DisableKeepAlives:     false,
-			IdleConnTimeout:       120 * time.Millisecond,
-			ExpectContinueTimeout: 1500 * time.Millisecond,
+			IdleConnTimeout: 120 * time.Millisecond,
 		},
 	}
 
@@ -40,50 +45,172 @@ func MakeProxy(timeout time.Duration) http.HandlerFunc {
 			defer r.Body.Close()
 		}
 
-		switch r.Method {
-		case http.MethodGet,
-			http.MethodPost:
+		vars := mux.Vars(r)
+		service := vars["name"]
 
-			vars := mux.Vars(r)
-			service := vars["name"]
+		stamp := strconv.FormatInt(time.Now().Unix(), 10)
 
-			stamp := strconv.FormatInt(time.Now().Unix(), 10)
+		defer func(when time.Time) {
+			seconds := time.Since(when).Seconds()
+			log.Printf("[%s] took %f seconds\n", stamp, seconds)
+		}(time.Now())
 
-			defer func(when time.Time) {
-				seconds := time.Since(when).Seconds()
-				log.Printf("[%s] took %f seconds\n", stamp, seconds)
-			}(time.Now())
+		namespace, err := awsutil.GetNamespace(vars["namespace"])
+		if err != nil {
+			log.Errorln(fmt.Sprintf("Error looking up host name using service %s. ", service), err)
+			writeError(err, service, w)
+			return
+		}
 
-			forwardReq := requests.NewForwardRequest(r.Method, *r.URL)
+		autoscaler.RequestStarted(namespace, service)
+		defer autoscaler.RequestFinished(namespace, service)
 
-			hostName, err := lookupHostName(service)
+		if err := autoscaler.Wake(namespace, service); err != nil {
+			writeWakeError(err, service, w)
+			return
+		}
+
+		route := awsutil.RouteRequest(namespace, service)
+		url := upstreamURL(route.HostName, r)
+
+		var body io.Reader = r.Body
+		var mirrored chan<- proxyResult
+		if len(route.ShadowHostName) > 0 {
+			// the body is buffered so it can be replayed to the shadow
+			var buffered []byte
+			var fits bool
+			body, buffered, fits, err = readShadowBody(r)
 			if err != nil {
-				log.Errorln(fmt.Sprintf("Error looking up host name using service %s. ", service), err)
 				writeError(err, service, w)
 				return
 			}
 
-			url := forwardReq.ToURL(hostName, watchdogPort)
+			if fits {
+				mirrored = mirrorRequest(shadowClient, shadowKey(namespace, service), service, r.Method,
+					upstreamURL(route.ShadowHostName, r), r.Header, buffered)
+			} else {
+				recordShadowDropped(shadowKey(namespace, service), service)
+			}
+		}
 
-			request, _ := http.NewRequest(r.Method, url, r.Body)
+		forwardRequest(proxyClient, w, r, url, body, service, upstreamTimeout, mirrored)
+	}
+}
 
-			copyHeaders(&request.Header, &r.Header)
+// newProxyClient creates the client requests are sent to functions with, giving up on a function which does not
+// start its response within the upstream timeout
+func newProxyClient(timeout time.Duration, upstreamTimeout time.Duration) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   timeout,
+				KeepAlive: 1 * time.Second,
+			}).DialContext,
+			// MaxIdleConns:          1,
+			// DisableKeepAlives:     false,
+			IdleConnTimeout:       120 * time.Millisecond,
+			ExpectContinueTimeout: 1500 * time.Millisecond,
+			ResponseHeaderTimeout: upstreamTimeout,
+		},
+	}
+}
 
-			defer request.Body.Close()
+// forwardRequest sends the request to the function at url and copies its response to the caller. The result is
+// sent to mirrored, if not nil, to be compared with the shadow.
+func forwardRequest(
+	client *http.Client,
+	w http.ResponseWriter,
+	r *http.Request,
+	url string,
+	body io.Reader,
+	service string,
+	upstreamTimeout time.Duration,
+	mirrored chan<- proxyResult) {
 
-			response, err := proxyClient.Do(request)
-			if err != nil {
-				writeError(err, service, w)
-				return
-			}
+	request, _ := http.NewRequest(r.Method, url, body)
+	// the length is passed on so that the body is not sent chunked
+	request.ContentLength = r.ContentLength
+
+	copyHeaders(&request.Header, &r.Header)
+	removeHopHeaders(request.Header)
+
+	// the request to the function is cancelled if the caller goes away
+	request = request.WithContext(r.Context())
+
+	started := time.Now()
+	response, err := client.Do(request)
+	if mirrored != nil {
+		result := proxyResult{latency: time.Since(started), err: err}
+		if err == nil {
+			result.statusCode = response.StatusCode
+		}
+
+		mirrored <- result
+	}
+
+	if err != nil {
+		writeUpstreamError(err, service, upstreamTimeout, w)
+		return
+	}
+
+	defer response.Body.Close()
+
+	clientHeader := w.Header()
+	copyHeaders(&clientHeader, &response.Header)
+	removeHopHeaders(clientHeader)
+	if response.ContentLength >= 0 {
+		clientHeader.Set("Content-Length", strconv.FormatInt(response.ContentLength, 10))
+	}
+
+	writeHead(service, response.StatusCode, w)
+	copyResponse(w, response, service)
+}
 
-			clientHeader := w.Header()
-			copyHeaders(&clientHeader, &response.Header)
+// upstreamURL returns the url of the request on the function host, keeping the path below /function/{name} and the
+// query string as they were sent
+func upstreamURL(hostName string, r *http.Request) string {
+	path := r.URL.EscapedPath()
+	if i := strings.Index(strings.TrimPrefix(path, "/function/"), "/"); i >= 0 && strings.HasPrefix(path, "/function/") {
+		path = path[len("/function/")+i:]
+	} else {
+		path = "/"
+	}
+
+	url := fmt.Sprintf("http://%s:%d%s", hostName, watchdogPort, path)
+	if len(r.URL.RawQuery) > 0 {
+		url += "?" + r.URL.RawQuery
+	}
 
-			writeHead(service, http.StatusOK, w)
-			io.Copy(w, response.Body)
+	return url
+}
+
+// hopHeaders apply to a single connection and are not forwarded by proxies, see RFC 7230 section 6.1
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// removeHopHeaders removes the hop-by-hop headers, including those named by the Connection header
+func removeHopHeaders(header http.Header) {
+	for _, value := range header["Connection"] {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); len(name) > 0 {
+				header.Del(name)
+			}
 		}
 	}
+
+	for _, name := range hopHeaders {
+		header.Del(name)
+	}
 }
 
 func writeError(err error, service string, w http.ResponseWriter) {
@@ -93,6 +220,87 @@ func writeError(err error, service string, w http.ResponseWriter) {
 	w.Write(buf.Bytes())
 }
 
+// writeWakeError tells the caller a function scaled to zero is not ready yet, other errors are written as usual
+func writeWakeError(err error, service string, w http.ResponseWriter) {
+	if _, ok := err.(*awsutil.WakeTimeoutError); !ok {
+		writeError(err, service, w)
+		return
+	}
+
+	log.Warnln(err.Error())
+	w.Header().Set("Retry-After", "10")
+	writeHead(service, http.StatusServiceUnavailable, w)
+	w.Write([]byte(err.Error()))
+}
+
+// writeUpstreamError answers a request the function did not respond to, with a 504 if it timed out and a 502 if it
+// could not be reached
+func writeUpstreamError(err error, service string, upstreamTimeout time.Duration, w http.ResponseWriter) {
+	log.Errorf("Error proxying request to %s. %v", service, err)
+
+	if isUpstreamTimeout(err) {
+		writeHead(service, http.StatusGatewayTimeout, w)
+		w.Write([]byte(fmt.Sprintf("Function %s did not respond within %s", service, upstreamTimeout)))
+		return
+	}
+
+	writeHead(service, http.StatusBadGateway, w)
+	w.Write([]byte("Can't reach service: " + service))
+}
+
+// isUpstreamTimeout returns true when the function was reached but did not respond in time. Failing to connect in
+// time is not a timeout of the function.
+func isUpstreamTimeout(err error) bool {
+	if urlErr, ok := err.(*neturl.Error); ok {
+		err = urlErr.Err
+	}
+
+	if opErr, ok := err.(*net.OpError); ok && opErr.Op == "dial" {
+		return false
+	}
+
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+// copyResponse copies the response of the function to the caller. Streamed responses, those sent without a length
+// or as server-sent events, are flushed as each chunk arrives rather than when the buffer fills.
+func copyResponse(w http.ResponseWriter, response *http.Response, service string) {
+	flusher, canFlush := w.(http.Flusher)
+	streamed := response.ContentLength < 0 ||
+		strings.HasPrefix(response.Header.Get("Content-Type"), "text/event-stream")
+
+	if !canFlush || !streamed {
+		if _, err := io.Copy(w, response.Body); err != nil {
+			log.Warnf("Error copying response of %s. %v", service, err)
+		}
+
+		return
+	}
+
+	buffer := make([]byte, 32*1024)
+	for {
+		n, err := response.Body.Read(buffer)
+		if n > 0 {
+			if _, writeErr := w.Write(buffer[:n]); writeErr != nil {
+				log.Warnf("Error streaming response of %s. %v", service, writeErr)
+				return
+			}
+
+			flusher.Flush()
+		}
+
+		if err == io.EOF {
+			return
+		}
+
+		if err != nil {
+			log.Warnf("Error streaming response of %s. %v", service, err)
+			return
+		}
+	}
+}
+
 func writeHead(service string, code int, w http.ResponseWriter) {
 	w.WriteHeader(code)
 }
@@ -104,7 +312,3 @@ func copyHeaders(destination *http.Header, source *http.Header) {
 		(*destination)[k] = vClone
 	}
 }
-
-func lookupHostName(functionName string) (string, error) {
-	return fmt.Sprintf("%s.openfaas.local", functionName), nil
-}
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_UpstreamURL(t *testing.T) {
+	for _, test := range []struct {
+		target string
+		want   string
+	}{
+		{"/function/echo", "http://echo:8080/"},
+		{"/function/echo/", "http://echo:8080/"},
+		{"/function/echo/orders/42", "http://echo:8080/orders/42"},
+		{"/function/echo/a%2Fb/c%20d", "http://echo:8080/a%2Fb/c%20d"},
+		{"/function/echo?name=a%26b&x=1", "http://echo:8080/?name=a%26b&x=1"},
+		{"/function/echo.prod", "http://echo:8080/"},
+		{"/function/echo.prod/orders/42?x=1", "http://echo:8080/orders/42?x=1"},
+		{"/function/echo.prod/a%2Fb?q=%2F", "http://echo:8080/a%2Fb?q=%2F"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, test.target, nil)
+		if got := upstreamURL("echo", r); got != test.want {
+			t.Errorf("Want %s for %s, got %s", test.want, test.target, got)
+		}
+	}
+}
+
+func Test_RemoveHopHeaders(t *testing.T) {
+	header := http.Header{}
+	header.Set("Connection", "keep-alive, X-Session")
+	header.Set("Keep-Alive", "timeout=5")
+	header.Set("X-Session", "abc")
+	header.Set("Transfer-Encoding", "chunked")
+	header.Set("Upgrade", "websocket")
+	header.Set("Content-Type", "text/plain")
+	header.Set("X-Call-Id", "1")
+
+	removeHopHeaders(header)
+
+	for _, name := range []string{"Connection", "Keep-Alive", "X-Session", "Transfer-Encoding", "Upgrade"} {
+		if _, found := header[name]; found {
+			t.Errorf("Want %s removed, got %v", name, header)
+		}
+	}
+
+	for _, name := range []string{"Content-Type", "X-Call-Id"} {
+		if len(header.Get(name)) == 0 {
+			t.Errorf("Want %s kept, got %v", name, header)
+		}
+	}
+}
+
+func Test_ForwardRequest_Status(t *testing.T) {
+	function := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if string(body) != "ping" || r.Header.Get("X-Call-Id") != "1" || len(r.Header.Get("X-Session")) > 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("X-Function", "echo")
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("pong"))
+	}))
+	defer function.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/function/echo", strings.NewReader("ping"))
+	r.Header.Set("X-Call-Id", "1")
+	r.Header.Set("Connection", "X-Session")
+	r.Header.Set("X-Session", "abc")
+	w := httptest.NewRecorder()
+
+	forwardRequest(newProxyClient(time.Second, time.Second), w, r, function.URL, r.Body, "echo", time.Second, nil)
+
+	if w.Code != http.StatusTeapot {
+		t.Errorf("Want status %d passed through, got %d", http.StatusTeapot, w.Code)
+	}
+
+	if w.Header().Get("X-Function") != "echo" || w.Header().Get("Content-Length") != "4" {
+		t.Errorf("Want function headers passed through, got %v", w.Header())
+	}
+
+	if w.Body.String() != "pong" {
+		t.Errorf("Want body pong, got %s", w.Body.String())
+	}
+
+	if w.Flushed {
+		t.Error("Want a response with a length copied without flushing")
+	}
+}
+
+func Test_ForwardRequest_Mirrored(t *testing.T) {
+	function := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer function.Close()
+
+	r := httptest.NewRequest(http.MethodGet, "/function/echo", nil)
+	mirrored := make(chan proxyResult, 1)
+
+	forwardRequest(newProxyClient(time.Second, time.Second), httptest.NewRecorder(), r, function.URL, nil,
+		"echo", time.Second, mirrored)
+
+	if result := <-mirrored; result.err != nil || result.statusCode != http.StatusCreated {
+		t.Errorf("Want the status of the function sent for comparison, got %+v", result)
+	}
+}
+
+func Test_ForwardRequest_Unreachable(t *testing.T) {
+	function := httptest.NewServer(http.NotFoundHandler())
+	url := function.URL
+	function.Close()
+
+	r := httptest.NewRequest(http.MethodGet, "/function/echo", nil)
+	w := httptest.NewRecorder()
+
+	forwardRequest(newProxyClient(time.Second, time.Second), w, r, url, nil, "echo", time.Second, nil)
+
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("Want status %d when the function can not be reached, got %d", http.StatusBadGateway, w.Code)
+	}
+}
+
+func Test_ForwardRequest_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	function := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer function.Close()
+	defer close(release)
+
+	r := httptest.NewRequest(http.MethodGet, "/function/echo", nil)
+	w := httptest.NewRecorder()
+
+	upstreamTimeout := 50 * time.Millisecond
+	forwardRequest(newProxyClient(time.Second, upstreamTimeout), w, r, function.URL, nil, "echo", upstreamTimeout, nil)
+
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("Want status %d when the function does not respond, got %d", http.StatusGatewayTimeout, w.Code)
+	}
+}
+
+// timeoutError is a net.Error which timed out
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func Test_IsUpstreamTimeout(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		want bool
+	}{
+		{&neturl.Error{Op: "Get", Err: timeoutError{}}, true},
+		{&neturl.Error{Op: "Get", Err: &net.OpError{Op: "read", Err: timeoutError{}}}, true},
+		{&neturl.Error{Op: "Get", Err: &net.OpError{Op: "dial", Err: timeoutError{}}}, false},
+		{&neturl.Error{Op: "Get", Err: fmt.Errorf("connection refused")}, false},
+	} {
+		if got := isUpstreamTimeout(test.err); got != test.want {
+			t.Errorf("Want %t for %v, got %t", test.want, test.err, got)
+		}
+	}
+}
+
+func Test_CopyResponse_EventStream(t *testing.T) {
+	function := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		for i := 0; i < 3; i++ {
+			fmt.Fprintf(w, "data: %d\n\n", i)
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer function.Close()
+
+	r := httptest.NewRequest(http.MethodGet, "/function/events", nil)
+	w := httptest.NewRecorder()
+
+	forwardRequest(newProxyClient(time.Second, time.Second), w, r, function.URL, nil, "events", time.Second, nil)
+
+	if !w.Flushed {
+		t.Error("Want server-sent events flushed as they arrive")
+	}
+
+	if want := "data: 0\n\ndata: 1\n\ndata: 2\n\n"; w.Body.String() != want {
+		t.Errorf("Want %q, got %q", want, w.Body.String())
+	}
+}
+
+func Test_ReadShadowBody(t *testing.T) {
+	small := httptest.NewRequest(http.MethodPost, "/function/echo", strings.NewReader("ping"))
+	body, buffered, fits, err := readShadowBody(small)
+	if err != nil || !fits || string(buffered) != "ping" {
+		t.Errorf("Want a small body buffered for the shadow, got %q %t %v", buffered, fits, err)
+	}
+
+	if sent, _ := ioutil.ReadAll(body); string(sent) != "ping" {
+		t.Errorf("Want the whole body sent to the function, got %q", sent)
+	}
+
+	payload := bytes.Repeat([]byte("x"), maxShadowBodyBytes+10)
+	large := httptest.NewRequest(http.MethodPost, "/function/echo", bytes.NewReader(payload))
+	// a body sent chunked has no length, so its size is only known once read
+	large.ContentLength = -1
+	body, buffered, fits, err = readShadowBody(large)
+	if err != nil || fits || buffered != nil {
+		t.Errorf("Want a large body not mirrored, got %d bytes %t %v", len(buffered), fits, err)
+	}
+
+	if sent, _ := ioutil.ReadAll(body); !bytes.Equal(sent, payload) {
+		t.Errorf("Want the whole body sent to the function, got %d bytes", len(sent))
+	}
+}
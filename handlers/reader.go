@@ -14,7 +14,12 @@ import (
 // MakeFunctionReader handler for reading functions deployed in the cluster as deployments.
 func MakeFunctionReader() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		functions, err := awsutil.GetServiceList()
+		namespace := namespaceFromRequest(w, r)
+		if namespace == nil {
+			return
+		}
+
+		functions, err := awsutil.GetServiceList(namespace)
 
 		if err != nil {
 			log.Errorf("Error reading functions.\n%v", err)
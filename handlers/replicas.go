@@ -7,22 +7,37 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/openfaas/faas-netes/types"
 	"github.com/openfaas/faas/gateway/requests"
 
-	"github.com/aws/aws-sdk-go/aws"
 	awsutil "github.com/ewilde/faas-fargate/aws"
 	log "github.com/sirupsen/logrus"
 )
 
-// MakeReplicaUpdater updates desired count of replicas
-func MakeReplicaUpdater() http.HandlerFunc {
+// scaleRequest is a ScaleServiceRequest whose replicas can be told apart from a request without them
+type scaleRequest struct {
+	ServiceName string `json:"serviceName"`
+	Replicas    *int64 `json:"replicas"`
+}
+
+// scaleResponse is the desired count a scale request set, and until when it holds if the function has a schedule
+type scaleResponse struct {
+	*awsutil.ScaleResult
+	HeldUntil *time.Time `json:"heldUntil,omitempty"`
+}
+
+// scaleFunction sets the desired count of a function, replaced in tests
+var scaleFunction = awsutil.ScaleFunction
+
+// MakeReplicaUpdater updates desired count of replicas, within the scale labels of the function and the provider
+// wide maxReplicas. The count of a function with a scaling schedule holds until its next scheduled action.
+func MakeReplicaUpdater(scheduler *awsutil.ScaleScheduler, maxReplicas int64) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		log.Info("Update replicas")
 
-		request := types.ScaleServiceRequest{}
+		request := scaleRequest{}
 		if r.Body != nil {
 			defer r.Body.Close()
 			bytesIn, _ := ioutil.ReadAll(r.Body)
@@ -36,18 +51,57 @@ func MakeReplicaUpdater() http.HandlerFunc {
 			}
 		}
 
-		service, err := awsutil.UpdateECSServiceDesiredCount(request.ServiceName, int(request.Replicas))
-		if err != nil {
+		if request.Replicas == nil {
 			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Request must set replicas."))
+			return
+		}
+
+		functionName := mux.Vars(r)["name"]
+		if len(functionName) == 0 {
+			functionName = request.ServiceName
+		}
+
+		namespace := namespaceFromRequest(w, r)
+		if namespace == nil {
+			return
+		}
+
+		result, err := scaleFunction(namespace, functionName, *request.Replicas, maxReplicas)
+		if err != nil {
+			log.Errorf("Error scaling function %s. %v", functionName, err)
+			w.WriteHeader(statusCodeForError(err))
 			w.Write([]byte(err.Error()))
 			return
 		}
 
-		log.Infof("Updated service replica count for %s with arn %s", request.ServiceName, aws.StringValue(service.ServiceArn))
+		if result == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
 
+		log.Infof("Updated service replica count for %s to %d", functionName, result.Replicas)
+
+		response := scaleResponse{ScaleResult: result}
+		response.HeldUntil = scheduler.ManualScale(namespace, functionName, result.Replicas)
+		if response.HeldUntil != nil {
+			log.Infof("Replica count for %s holds until its next scheduled scaling at %s",
+				functionName, response.HeldUntil.Format(time.RFC3339))
+		}
+
+		responseBytes, _ := json.Marshal(response)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write(responseBytes)
 	}
 }
 
+// functionStatusResponse is the function as reported by /system/functions with the status of its replicas
+type functionStatusResponse struct {
+	requests.Function
+	Status *awsutil.FunctionStatus `json:"status"`
+}
+
 // MakeReplicaReader reads the amount of replicas for a deployment
 func MakeReplicaReader() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -56,26 +110,24 @@ func MakeReplicaReader() http.HandlerFunc {
 		vars := mux.Vars(r)
 		functionName := vars["name"]
 
-		functions, err := awsutil.GetServiceList()
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+		namespace := namespaceFromRequest(w, r)
+		if namespace == nil {
 			return
 		}
 
-		var found *requests.Function
-		for _, function := range functions {
-			if function.Name == functionName {
-				found = &function
-				break
-			}
+		function, status, err := awsutil.GetFunction(namespace, functionName)
+		if err != nil {
+			log.Errorf("Error reading function %s. %v", functionName, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
 		}
 
-		if found == nil {
+		if function == nil {
 			w.WriteHeader(404)
 			return
 		}
 
-		functionBytes, _ := json.Marshal(found)
+		functionBytes, _ := json.Marshal(functionStatusResponse{Function: *function, Status: status})
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(200)
 		w.Write(functionBytes)
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	awsutil "github.com/ewilde/faas-fargate/aws"
+	"github.com/gorilla/mux"
+)
+
+// withScaleFunction runs the test with scale requests answered by scale
+func withScaleFunction(scale func(*awsutil.Namespace, string, int64, int64) (*awsutil.ScaleResult, error), test func()) {
+	previous := scaleFunction
+	scaleFunction = scale
+	defer func() { scaleFunction = previous }()
+
+	test()
+}
+
+// scaleReplicas sends the scale request body for the echo function to the replica updater
+func scaleReplicas(body string) *httptest.ResponseRecorder {
+	router := mux.NewRouter()
+	router.HandleFunc("/system/scale-function/{name}", MakeReplicaUpdater(awsutil.NewScaleScheduler(100), 100))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/system/scale-function/echo", strings.NewReader(body)))
+	return w
+}
+
+func Test_ReplicaUpdater_MissingReplicas(t *testing.T) {
+	scaled := false
+	withScaleFunction(func(*awsutil.Namespace, string, int64, int64) (*awsutil.ScaleResult, error) {
+		scaled = true
+		return nil, nil
+	}, func() {
+		for _, body := range []string{`{"serviceName": "echo"}`, `{"serviceName": "echo", "replicas": null}`, `{`} {
+			if w := scaleReplicas(body); w.Code != http.StatusBadRequest {
+				t.Errorf("Want 400 for %s, got %d", body, w.Code)
+			}
+		}
+	})
+
+	if scaled {
+		t.Error("Want no function scaled without replicas")
+	}
+
+	// zero replicas is a request to scale to zero rather than a missing count
+	withScaleFunction(func(namespace *awsutil.Namespace, name string, replicas int64, max int64) (*awsutil.ScaleResult, error) {
+		return &awsutil.ScaleResult{Function: name, Requested: replicas, Replicas: replicas}, nil
+	}, func() {
+		if w := scaleReplicas(`{"serviceName": "echo", "replicas": 0}`); w.Code != http.StatusAccepted {
+			t.Errorf("Want 202 scaling to zero, got %d", w.Code)
+		}
+	})
+}
+
+func Test_ReplicaUpdater_StatusCodes(t *testing.T) {
+	withScaleFunction(func(*awsutil.Namespace, string, int64, int64) (*awsutil.ScaleResult, error) {
+		return nil, nil
+	}, func() {
+		if w := scaleReplicas(`{"serviceName": "echo", "replicas": 2}`); w.Code != http.StatusNotFound {
+			t.Errorf("Want 404 for an unknown function, got %d", w.Code)
+		}
+	})
+
+	withScaleFunction(func(*awsutil.Namespace, string, int64, int64) (*awsutil.ScaleResult, error) {
+		return nil, &awsutil.ConflictError{}
+	}, func() {
+		if w := scaleReplicas(`{"serviceName": "echo", "replicas": 2}`); w.Code != http.StatusConflict {
+			t.Errorf("Want 409 during a rollout, got %d", w.Code)
+		}
+	})
+
+	withScaleFunction(func(namespace *awsutil.Namespace, name string, replicas int64, max int64) (*awsutil.ScaleResult, error) {
+		return &awsutil.ScaleResult{Function: name, Requested: replicas, Replicas: 5, Reason: "lowered to the maximum of 5"}, nil
+	}, func() {
+		w := scaleReplicas(`{"serviceName": "echo", "replicas": 8}`)
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("Want 202, got %d", w.Code)
+		}
+
+		result := awsutil.ScaleResult{}
+		if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+
+		if result.Function != "echo" || result.Requested != 8 || result.Replicas != 5 {
+			t.Errorf("Want echo scaled to 5 of the 8 requested, got %+v", result)
+		}
+	})
+}
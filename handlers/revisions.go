@@ -0,0 +1,46 @@
+// Copyright (c) Edward Wilde 2018. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	awsutil "github.com/ewilde/faas-fargate/aws"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// MakeFunctionRevisionsReader lists the task definition revisions of a function, newest first
+func MakeFunctionRevisionsReader() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		functionName := vars["name"]
+
+		log.Infof("Read revisions for %s", functionName)
+
+		namespace := namespaceFromRequest(w, r)
+		if namespace == nil {
+			return
+		}
+
+		revisions, err := awsutil.GetFunctionRevisions(namespace, functionName)
+		if err != nil {
+			log.Errorf("Error reading revisions for function %s. %v", functionName, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		if len(revisions) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		revisionBytes, _ := json.Marshal(revisions)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(revisionBytes)
+	}
+}
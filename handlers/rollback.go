@@ -0,0 +1,64 @@
+// Copyright (c) Edward Wilde 2018. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	awsutil "github.com/ewilde/faas-fargate/aws"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// rollbackRequest chooses the revision to roll back to, the previous revision when empty
+type rollbackRequest struct {
+	Revision int64 `json:"revision"`
+}
+
+// MakeRollbackHandler points a function back at an earlier revision
+func MakeRollbackHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		vars := mux.Vars(r)
+		functionName := vars["name"]
+
+		request := rollbackRequest{}
+		body, _ := ioutil.ReadAll(r.Body)
+		if len(body) > 0 {
+			if err := json.Unmarshal(body, &request); err != nil {
+				log.Errorln("Error during unmarshal of rollback request. ", err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+
+		namespace := namespaceFromRequest(w, r)
+		if namespace == nil {
+			return
+		}
+
+		log.Infof("Rollback request for function %s to revision %d", functionName, request.Revision)
+
+		revision, err := awsutil.RollbackFunction(namespace, functionName, request.Revision)
+		if err != nil {
+			log.Errorf("Error rolling back function %s. %v", functionName, err)
+			w.WriteHeader(statusCodeForError(err))
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		if revision == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		revisionBytes, _ := json.Marshal(revision)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write(revisionBytes)
+	}
+}
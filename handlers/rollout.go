@@ -0,0 +1,131 @@
+// Copyright (c) Edward Wilde 2018. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	awsutil "github.com/ewilde/faas-fargate/aws"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultRolloutWaitTimeout is how long a deploy or update with wait=true waits for the rollout
+const defaultRolloutWaitTimeout = 5 * time.Minute
+
+// MakeRolloutStatusReader returns the progress of the latest rollout of a function
+func MakeRolloutStatusReader() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		functionName := vars["name"]
+
+		namespace := namespaceFromRequest(w, r)
+		if namespace == nil {
+			return
+		}
+
+		status, err := awsutil.GetRolloutStatus(namespace, functionName)
+		if err != nil {
+			log.Errorf("Error reading rollout status for function %s. %v", functionName, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		if status == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		writeRolloutStatus(w, http.StatusOK, status)
+	}
+}
+
+// isWait returns true when the request asks to wait for the rollout to finish before responding
+func isWait(r *http.Request) bool {
+	return r.URL.Query().Get("wait") == "true"
+}
+
+// waitTimeout returns the timeout query parameter, a duration such as 90s, or the default. The timeout counts from
+// the start of the request, and must end a tenth of the write timeout before it so the response reaches the caller.
+// A longer timeout is rejected and the default is shortened to fit.
+func waitTimeout(r *http.Request, writeTimeout time.Duration) (time.Duration, error) {
+	longest := writeTimeout - writeTimeout/10
+
+	timeout, err := time.ParseDuration(r.URL.Query().Get("timeout"))
+	if err != nil || timeout <= 0 {
+		if defaultRolloutWaitTimeout > longest {
+			return longest, nil
+		}
+
+		return defaultRolloutWaitTimeout, nil
+	}
+
+	if timeout > longest {
+		return 0, fmt.Errorf("timeout %s must be at most %s, to wait longer raise the write_timeout of %s",
+			timeout, longest, writeTimeout)
+	}
+
+	return timeout, nil
+}
+
+// validateWait checks the timeout of a request made with wait=true before the deploy starts, writing a 400 and
+// returning false when the write timeout does not cover it
+func validateWait(w http.ResponseWriter, r *http.Request, writeTimeout time.Duration) bool {
+	if !isWait(r) {
+		return true
+	}
+
+	if _, err := waitTimeout(r, writeTimeout); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return false
+	}
+
+	return true
+}
+
+// writeDeployAccepted responds to a deploy or update that was started. With wait=true the response waits for the
+// rollout, returning 200 when it completed, 500 when it failed and 202 when it is still in progress at the timeout.
+// The timeout counts from when the request started, so the time taken to deploy is part of it.
+func writeDeployAccepted(
+	w http.ResponseWriter,
+	r *http.Request,
+	namespace *awsutil.Namespace,
+	functionName string,
+	started time.Time,
+	writeTimeout time.Duration) {
+
+	if !isWait(r) {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	timeout, _ := waitTimeout(r, writeTimeout)
+	status, err := awsutil.WaitForRollout(namespace, functionName, started.Add(timeout))
+	if err != nil || status == nil {
+		log.Errorf("Error waiting for rollout of %s. %v", functionName, err)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	switch status.State {
+	case awsutil.RolloutCompleted:
+		writeRolloutStatus(w, http.StatusOK, status)
+	case awsutil.RolloutInProgress:
+		writeRolloutStatus(w, http.StatusAccepted, status)
+	default:
+		writeRolloutStatus(w, http.StatusInternalServerError, status)
+	}
+}
+
+func writeRolloutStatus(w http.ResponseWriter, statusCode int, status *awsutil.RolloutStatus) {
+	statusBytes, _ := json.Marshal(status)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(statusBytes)
+}
@@ -0,0 +1,43 @@
+// Copyright (c) Edward Wilde 2018. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	awsutil "github.com/ewilde/faas-fargate/aws"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// MakeScheduleReader returns the scaling schedule of a function with its upcoming scheduled actions
+func MakeScheduleReader(scheduler *awsutil.ScaleScheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		functionName := mux.Vars(r)["name"]
+
+		namespace := namespaceFromRequest(w, r)
+		if namespace == nil {
+			return
+		}
+
+		status, err := scheduler.Status(namespace, functionName)
+		if err != nil {
+			log.Errorf("Error reading schedule of function %s. %v", functionName, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		if status == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		statusBytes, _ := json.Marshal(status)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(statusBytes)
+	}
+}
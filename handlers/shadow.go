@@ -0,0 +1,216 @@
+// Copyright (c) Edward Wilde 2018. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	awsutil "github.com/ewilde/faas-fargate/aws"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxShadowRequests limits the mirrored requests in flight, requests beyond it are not mirrored
+const maxShadowRequests = 100
+
+// maxShadowBodyBytes limits the body of a mirrored request, which is held in memory to replay it to the shadow.
+// Requests with a larger body are not mirrored.
+const maxShadowBodyBytes = 1 << 20
+
+var shadowSlots = make(chan struct{}, maxShadowRequests)
+
+var shadowMetricsLock = &sync.Mutex{}
+var shadowMetrics = map[string]*ShadowMetrics{}
+
+// ShadowMetrics compares the responses of a function with those of its shadow, for the requests that were mirrored
+type ShadowMetrics struct {
+	Function string             `json:"function"`
+	Primary  ShadowTargetMetric `json:"primary"`
+	Shadow   ShadowTargetMetric `json:"shadow"`
+	// StatusMismatches counts mirrored requests the shadow answered with a different status code
+	StatusMismatches int64 `json:"statusMismatches"`
+	// Dropped counts requests that were not mirrored because too many mirrored requests were in flight or their body
+	// was larger than maxShadowBodyBytes
+	Dropped int64 `json:"dropped"`
+}
+
+// ShadowTargetMetric summarises the responses of the function or of its shadow
+type ShadowTargetMetric struct {
+	Requests int64 `json:"requests"`
+	// Errors counts requests that got no response
+	Errors        int64         `json:"errors"`
+	StatusCodes   map[int]int64 `json:"statusCodes"`
+	MeanLatencyMs float64       `json:"meanLatencyMs"`
+	MaxLatencyMs  float64       `json:"maxLatencyMs"`
+	totalLatency  time.Duration
+}
+
+// proxyResult is the outcome of sending a request to the function or its shadow
+type proxyResult struct {
+	statusCode int
+	latency    time.Duration
+	err        error
+}
+
+func (m *ShadowTargetMetric) record(result proxyResult) {
+	m.Requests++
+	if result.err != nil {
+		m.Errors++
+		return
+	}
+
+	if m.StatusCodes == nil {
+		m.StatusCodes = map[int]int64{}
+	}
+	m.StatusCodes[result.statusCode]++
+
+	m.totalLatency += result.latency
+	m.MeanLatencyMs = float64(m.totalLatency) / float64(m.Requests-m.Errors) / float64(time.Millisecond)
+	if latency := float64(result.latency) / float64(time.Millisecond); latency > m.MaxLatencyMs {
+		m.MaxLatencyMs = latency
+	}
+}
+
+// recordShadowResult adds a mirrored request, answered by both the function and its shadow, to the metrics
+func recordShadowResult(key string, functionName string, primary proxyResult, shadow proxyResult) {
+	shadowMetricsLock.Lock()
+	defer shadowMetricsLock.Unlock()
+
+	metrics := shadowMetricsFor(key, functionName)
+	metrics.Primary.record(primary)
+	metrics.Shadow.record(shadow)
+	if primary.err == nil && shadow.err == nil && primary.statusCode != shadow.statusCode {
+		metrics.StatusMismatches++
+	}
+}
+
+func recordShadowDropped(key string, functionName string) {
+	shadowMetricsLock.Lock()
+	defer shadowMetricsLock.Unlock()
+
+	shadowMetricsFor(key, functionName).Dropped++
+}
+
+// shadowMetricsFor returns the metrics of the function, the caller must hold shadowMetricsLock
+func shadowMetricsFor(key string, functionName string) *ShadowMetrics {
+	metrics, found := shadowMetrics[key]
+	if !found {
+		metrics = &ShadowMetrics{Function: functionName}
+		shadowMetrics[key] = metrics
+	}
+
+	return metrics
+}
+
+// mirrorRequest sends a copy of the request to the shadow, discarding the response. The result of the request to the
+// function must be sent to the returned channel so the two can be compared. Returns nil when the request was not
+// mirrored.
+func mirrorRequest(
+	client *http.Client,
+	key string,
+	functionName string,
+	method string,
+	url string,
+	header http.Header,
+	body []byte) chan<- proxyResult {
+
+	select {
+	case shadowSlots <- struct{}{}:
+	default:
+		recordShadowDropped(key, functionName)
+		return nil
+	}
+
+	request, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		<-shadowSlots
+		log.Errorf("Error creating shadow request for %s. %v", functionName, err)
+		return nil
+	}
+	copyHeaders(&request.Header, &header)
+	removeHopHeaders(request.Header)
+
+	primary := make(chan proxyResult, 1)
+	go func() {
+		defer func() { <-shadowSlots }()
+
+		// latency is measured to the response headers, as it is for the function
+		started := time.Now()
+		response, err := client.Do(request)
+		shadow := proxyResult{latency: time.Since(started), err: err}
+		if err == nil {
+			shadow.statusCode = response.StatusCode
+			io.Copy(ioutil.Discard, response.Body)
+			response.Body.Close()
+		}
+
+		recordShadowResult(key, functionName, <-primary, shadow)
+	}()
+
+	return primary
+}
+
+// readShadowBody reads the request body so it can be replayed to the shadow, returning false when the body is larger
+// than maxShadowBodyBytes. The returned reader sends the whole body to the function either way.
+func readShadowBody(r *http.Request) (io.Reader, []byte, bool, error) {
+	if r.ContentLength > maxShadowBodyBytes {
+		return r.Body, nil, false, nil
+	}
+
+	buffered, err := ioutil.ReadAll(io.LimitReader(r.Body, maxShadowBodyBytes+1))
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	if len(buffered) > maxShadowBodyBytes {
+		return io.MultiReader(bytes.NewReader(buffered), r.Body), nil, false, nil
+	}
+
+	return bytes.NewReader(buffered), buffered, true, nil
+}
+
+// MakeShadowMetricsReader returns the comparison of a function with its shadow, DELETE resets it
+func MakeShadowMetricsReader() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		functionName := mux.Vars(r)["name"]
+
+		namespace := namespaceFromRequest(w, r)
+		if namespace == nil {
+			return
+		}
+
+		key := shadowKey(namespace, functionName)
+
+		shadowMetricsLock.Lock()
+		metrics, found := shadowMetrics[key]
+		if r.Method == http.MethodDelete {
+			delete(shadowMetrics, key)
+		}
+
+		var metricsBytes []byte
+		if found {
+			metricsBytes, _ = json.Marshal(metrics)
+		}
+		shadowMetricsLock.Unlock()
+
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(metricsBytes)
+	}
+}
+
+func shadowKey(namespace *awsutil.Namespace, functionName string) string {
+	return namespace.Name + "/" + functionName
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	awsutil "github.com/ewilde/faas-fargate/aws"
@@ -17,6 +18,7 @@ func MakeUpdateHandler(
 	config *types.DeployHandlerConfig) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
+		started := time.Now()
 		defer r.Body.Close()
 
 		body, _ := ioutil.ReadAll(r.Body)
@@ -28,21 +30,44 @@ func MakeUpdateHandler(
 			return
 		}
 
-		taskDefinition, err := awsutil.CreateTaskRevision(request, config)
+		namespace := namespaceFromRequest(w, r)
+		if namespace == nil {
+			return
+		}
+
+		if isDryRun(r) {
+			writeDeployPlan(w, namespace, request, config)
+			return
+		}
+
+		if !validateWait(w, r, config.WriteTimeout) {
+			return
+		}
+
+		diff, err := awsutil.DiffFunction(namespace, request, config)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			log.Errorf("Error comparing %s with the running function. %v", request.Service, err)
+			w.WriteHeader(statusCodeForError(err))
 			w.Write([]byte(err.Error()))
 			return
 		}
 
-		service, err := awsutil.UpdateOrCreateECSService(taskDefinition.TaskDefinition, request, config)
+		if diff.Unchanged() {
+			log.Infof("Function %s is unchanged, skipping update", request.Service)
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("unchanged"))
+			return
+		}
+
+		log.Infof("Updating function %s, changes: %v", request.Service, diff.Changes)
+
+		service, err := awsutil.DeployFunction(namespace, request, config)
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(err.Error()))
+			writeDeployError(w, err)
 			return
 		}
 
 		log.Infof("Updated service - %s %s.", request.Service, aws.StringValue(service.ServiceArn))
-		w.WriteHeader(http.StatusAccepted)
+		writeDeployAccepted(w, r, namespace, request.Service, started, config.WriteTimeout)
 	}
 }
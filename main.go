@@ -2,7 +2,6 @@ package main
 
 import (
 	"os"
-	"time"
 
 	ecsutil "github.com/ewilde/faas-fargate/aws"
 	"github.com/ewilde/faas-fargate/handlers"
@@ -14,12 +13,6 @@ import (
 )
 
 func main() {
-	functionNamespace := "default" // TODO: this isn't used at the moment
-
-	if namespace, exists := os.LookupEnv("function_namespace"); exists {
-		functionNamespace = namespace
-	}
-
 	initLogging()
 
 	readConfig := types.ReadConfig{}
@@ -30,35 +23,96 @@ func main() {
 	log.Infof("HTTP Read Timeout: %s", cfg.ReadTimeout)
 	log.Infof("HTTP Write Timeout: %s", cfg.WriteTimeout)
 	log.Infof("Function Readiness Probe Enabled: %v", cfg.EnableFunctionReadinessProbe)
-	log.Infof("Function namespace: %v - WARNING not used at the moment", functionNamespace)
+	log.Infof("Default function namespace: %v", cfg.DefaultFunctionNamespace)
+	log.Infof("Function namespaces: %v", cfg.FunctionNamespaces)
+
+	ecsutil.ConfigureNamespaces(cfg.DefaultFunctionNamespace, cfg.FunctionNamespaces)
 
 	deployConfig := &types.DeployHandlerConfig{
-		AssignPublicIP:  cfg.AssignPublicIP,
-		SecurityGroupID: cfg.SecurityGroupID,
-		SubnetIDs:       cfg.SubnetIDs,
-		Region:          cfg.DefaultAWSRegion,
-		VpcID:           ecsutil.VpcFromSubnet(cfg.SubnetIDs),
+		AssignPublicIP:               cfg.AssignPublicIP,
+		SecurityGroupID:              cfg.SecurityGroupID,
+		SubnetIDs:                    cfg.SubnetIDs,
+		Region:                       cfg.DefaultAWSRegion,
+		VpcID:                        ecsutil.VpcFromSubnet(cfg.SubnetIDs),
+		DefaultEnvVars:               cfg.DefaultFunctionEnv,
+		EnableFunctionReadinessProbe: cfg.EnableFunctionReadinessProbe,
+		RevisionHistoryLimit:         cfg.RevisionHistoryLimit,
+		WriteTimeout:                 cfg.WriteTimeout,
+		MaxReplicas:                  cfg.MaxReplicas,
 	}
 
+	garbageCollector := ecsutil.NewGarbageCollector(cfg.GCGracePeriod, cfg.GCRemoveOrphans)
+	if cfg.GCInterval > 0 {
+		log.Infof("Collecting orphaned resources every %s, grace period %s, removing them %t",
+			cfg.GCInterval, cfg.GCGracePeriod, cfg.GCRemoveOrphans)
+		garbageCollector.Start(cfg.GCInterval)
+	}
+
+	if cfg.WakeTimeout >= cfg.WriteTimeout {
+		log.Fatalf("Wake timeout %s must be below the write timeout %s", cfg.WakeTimeout, cfg.WriteTimeout)
+	}
+
+	wakeTimeout := cfg.WakeTimeout
+	if limit := cfg.WriteTimeout - cfg.UpstreamTimeout; wakeTimeout > limit {
+		log.Warnf("Wake timeout %s limited to %s, the write timeout less the upstream timeout", wakeTimeout, limit)
+		wakeTimeout = limit
+	}
+
+	if wakeTimeout <= 0 {
+		log.Fatalf("Wake timeout %s must be above zero, the upstream timeout %s leaves none of the write timeout %s",
+			wakeTimeout, cfg.UpstreamTimeout, cfg.WriteTimeout)
+	}
+
+	scheduler := ecsutil.NewScaleScheduler(int64(cfg.MaxReplicas))
+	scheduler.Start()
+
+	autoscaler := ecsutil.NewRequestAutoscaler(wakeTimeout, scheduler)
+	if cfg.AutoscalerInterval > 0 {
+		log.Infof("Autoscaling functions on requests in flight every %s", cfg.AutoscalerInterval)
+		autoscaler.Start(cfg.AutoscalerInterval)
+	}
+
+	ecsutil.StartCanaryRouting()
+
 	bootstrapHandlers := bootTypes.FaaSHandlers{
-		FunctionProxy:  handlers.MakeProxy(cfg.ReadTimeout),
+		FunctionProxy:  handlers.MakeProxy(cfg.ReadTimeout, cfg.UpstreamTimeout, autoscaler),
 		DeleteHandler:  handlers.MakeDeleteHandler(deployConfig),
 		DeployHandler:  handlers.MakeDeployHandler(deployConfig),
 		FunctionReader: handlers.MakeFunctionReader(),
 		ReplicaReader:  handlers.MakeReplicaReader(),
-		ReplicaUpdater: handlers.MakeReplicaUpdater(),
+		ReplicaUpdater: handlers.MakeReplicaUpdater(scheduler, int64(cfg.MaxReplicas)),
 		UpdateHandler:  handlers.MakeUpdateHandler(deployConfig),
 		Health:         handlers.MakeHealthHandler(),
 		InfoHandler:    handlers.MakeInfoHandler(version.BuildVersion(), version.GitCommitSHA),
 	}
 
 	bootstrapConfig := bootTypes.FaaSConfig{
-		ReadTimeout:  time.Second * 8,
-		WriteTimeout: time.Second * 8,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
 		TCPPort:      &cfg.Port,
 		EnableHealth: true,
 	}
 
+	router := bootstrap.Router()
+	router.HandleFunc("/system/function/{name:[-a-zA-Z_0-9]+}/events", handlers.MakeFunctionEventsReader()).Methods("GET")
+	router.HandleFunc("/system/function/{name:[-a-zA-Z_0-9]+}/revisions", handlers.MakeFunctionRevisionsReader()).Methods("GET")
+	router.HandleFunc("/system/function/{name:[-a-zA-Z_0-9]+}/rollback", handlers.MakeRollbackHandler()).Methods("POST")
+	router.HandleFunc("/system/function/{name:[-a-zA-Z_0-9]+}/rollout", handlers.MakeRolloutStatusReader()).Methods("GET")
+	router.HandleFunc("/system/function/{name:[-a-zA-Z_0-9]+}/canary", handlers.MakeCanaryReader()).Methods("GET")
+	router.HandleFunc("/system/function/{name:[-a-zA-Z_0-9]+}/canary", handlers.MakeCanaryDeployHandler(deployConfig)).Methods("POST")
+	router.HandleFunc("/system/function/{name:[-a-zA-Z_0-9]+}/canary/weight", handlers.MakeCanaryWeightHandler()).Methods("POST")
+	router.HandleFunc("/system/function/{name:[-a-zA-Z_0-9]+}/canary/shadow", handlers.MakeCanaryShadowHandler()).Methods("POST")
+	router.HandleFunc("/system/function/{name:[-a-zA-Z_0-9]+}/shadow", handlers.MakeShadowMetricsReader()).Methods("GET", "DELETE")
+	router.HandleFunc("/system/function/{name:[-a-zA-Z_0-9]+}/canary/promote", handlers.MakeCanaryPromoteHandler(deployConfig)).Methods("POST")
+	router.HandleFunc("/system/function/{name:[-a-zA-Z_0-9]+}/canary/abort", handlers.MakeCanaryAbortHandler(deployConfig)).Methods("POST")
+	router.HandleFunc("/system/function/{name:[-a-zA-Z_0-9]+}/autoscaler", handlers.MakeAutoscalerReader(autoscaler)).Methods("GET")
+	router.HandleFunc("/system/function/{name:[-a-zA-Z_0-9]+}/schedule", handlers.MakeScheduleReader(scheduler)).Methods("GET")
+	router.HandleFunc("/system/namespaces", handlers.MakeNamespaceReader()).Methods("GET")
+	router.HandleFunc("/system/gc", handlers.MakeGarbageCollectionReader(garbageCollector)).Methods("GET")
+	router.HandleFunc("/function/{name:[-a-zA-Z_0-9]+}.{namespace:[-a-zA-Z_0-9]+}", bootstrapHandlers.FunctionProxy)
+	router.PathPrefix("/function/{name:[-a-zA-Z_0-9]+}.{namespace:[-a-zA-Z_0-9]+}/").HandlerFunc(bootstrapHandlers.FunctionProxy)
+	router.PathPrefix("/function/{name:[-a-zA-Z_0-9]+}/").HandlerFunc(bootstrapHandlers.FunctionProxy)
+
 	log.Infof("Listening on port %d", cfg.Port)
 	bootstrap.Serve(&bootstrapHandlers, &bootstrapConfig)
 }
@@ -1,10 +1,19 @@
 package types
 
+import "time"
+
 // DeployHandlerConfig specify options for Deployments
 type DeployHandlerConfig struct {
-	AssignPublicIP  string
-	SecurityGroupID string
-	SubnetIDs       string
-	VpcID           string
-	Region          string
+	AssignPublicIP               string
+	SecurityGroupID              string
+	SubnetIDs                    string
+	VpcID                        string
+	Region                       string
+	DefaultEnvVars               map[string]string
+	EnableFunctionReadinessProbe bool
+	RevisionHistoryLimit         int
+	// WriteTimeout of the http server, a deploy made with wait=true must respond within it
+	WriteTimeout time.Duration
+	// MaxReplicas is the provider wide limit on replicas, the scaling schedule of a function must keep within it
+	MaxReplicas int
 }
@@ -7,6 +7,7 @@ package types
 import (
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -68,21 +69,72 @@ func parseString(val string, fallback string) string {
 	return fallback
 }
 
+func parseMap(val string, fallback map[string]string) map[string]string {
+	if len(val) == 0 {
+		return fallback
+	}
+
+	result := map[string]string{}
+	for _, pair := range strings.Split(val, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || len(strings.TrimSpace(parts[0])) == 0 {
+			continue
+		}
+
+		result[strings.TrimSpace(parts[0])] = parts[1]
+	}
+
+	return result
+}
+
+// parseNamespaces parses a comma separated list of namespaces, each optionally mapped to an ECS cluster using
+// namespace=cluster
+func parseNamespaces(val string) map[string]string {
+	result := map[string]string{}
+	for _, item := range strings.Split(val, ",") {
+		parts := strings.SplitN(item, "=", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(name) == 0 {
+			continue
+		}
+
+		result[name] = ""
+		if len(parts) == 2 {
+			result[name] = strings.TrimSpace(parts[1])
+		}
+	}
+
+	return result
+}
+
 // Read fetches config from environmental variables.
 func (ReadConfig) Read(hasEnv HasEnv) BootstrapConfig {
 	defaultTCPPort := 8080
 
 	cfg := BootstrapConfig{}
 
-	cfg.EnableFunctionReadinessProbe = parseBoolValue(hasEnv.Getenv("enable_function_readiness_probe"), true)
+	cfg.EnableFunctionReadinessProbe = parseBoolValue(hasEnv.Getenv("enable_function_readiness_probe"), false)
 	cfg.ReadTimeout = parseIntOrDurationValue(hasEnv.Getenv("read_timeout"), time.Second*10)
 	cfg.WriteTimeout = parseIntOrDurationValue(hasEnv.Getenv("write_timeout"), time.Second*10)
+	// the function must respond inside the write timeout for its 504 to reach the caller
+	cfg.UpstreamTimeout = parseIntOrDurationValue(hasEnv.Getenv("upstream_timeout"), cfg.WriteTimeout-cfg.WriteTimeout/10)
 	cfg.ImagePullPolicy = parseString(hasEnv.Getenv("image_pull_policy"), "Always")
 	cfg.AssignPublicIP = parseString(hasEnv.Getenv("assign_public_ip"), "DISABLED")
 	cfg.Port = parseIntValue(hasEnv.Getenv("port"), defaultTCPPort)
 	cfg.SubnetIDs = parseString(hasEnv.Getenv("subnet_ids"), "")
 	cfg.SecurityGroupID = parseString(hasEnv.Getenv("security_group_id"), "")
 	cfg.DefaultAWSRegion = parseString(hasEnv.Getenv("AWS_DEFAULT_REGION"), "us-east-1")
+	cfg.DefaultFunctionEnv = parseMap(hasEnv.Getenv("default_function_env"), map[string]string{})
+	cfg.DefaultFunctionNamespace = parseString(hasEnv.Getenv("function_namespace"), "default")
+	cfg.FunctionNamespaces = parseNamespaces(hasEnv.Getenv("function_namespaces"))
+	cfg.RevisionHistoryLimit = parseIntValue(hasEnv.Getenv("revision_history_limit"), 10)
+	cfg.GCInterval = parseIntOrDurationValue(hasEnv.Getenv("gc_interval"), time.Hour)
+	cfg.GCGracePeriod = parseIntOrDurationValue(hasEnv.Getenv("gc_grace_period"), time.Hour)
+	cfg.GCRemoveOrphans = parseBoolValue(hasEnv.Getenv("gc_remove_orphans"), false)
+	cfg.AutoscalerInterval = parseIntOrDurationValue(hasEnv.Getenv("autoscaler_interval"), 10*time.Second)
+	// a woken function must still respond inside the write timeout, so by default the wake gets what is left of it
+	cfg.WakeTimeout = parseIntOrDurationValue(hasEnv.Getenv("wake_timeout"), cfg.WriteTimeout-cfg.UpstreamTimeout)
+	cfg.MaxReplicas = parseIntValue(hasEnv.Getenv("max_replicas"), 100)
 
 	return cfg
 }
@@ -97,5 +149,16 @@ type BootstrapConfig struct {
 	SubnetIDs                    string
 	SecurityGroupID              string
 	WriteTimeout                 time.Duration
+	UpstreamTimeout              time.Duration
 	DefaultAWSRegion             string
+	DefaultFunctionEnv           map[string]string
+	DefaultFunctionNamespace     string
+	FunctionNamespaces           map[string]string
+	RevisionHistoryLimit         int
+	GCInterval                   time.Duration
+	GCGracePeriod                time.Duration
+	GCRemoveOrphans              bool
+	AutoscalerInterval           time.Duration
+	WakeTimeout                  time.Duration
+	MaxReplicas                  int
 }